@@ -0,0 +1,276 @@
+// Package attestation implements remote attestation of ASCON endpoints. A verifier challenges the
+// prover with PROVE and a fresh nonce, and the prover answers with a Proof which carries its measurement
+// bound to the nonce and to the session key of the connection.
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// NonceSize is the size of the challenge of the verifier.
+	NonceSize = 16
+	// MaxMeasurementSize is the largest measurement which fits into the evidence.
+	MaxMeasurementSize = math.MaxUint16
+)
+
+const (
+	evidenceNonce byte = iota + 1
+	evidenceMeasurement
+	evidenceBinding
+
+	bindingKeyLabel     = "ascon attestation binding"
+	handshakeNonceLabel = "ascon handshake attestation"
+	groupNonceLabel     = "ascon group attestation"
+)
+
+var (
+	ErrProofNotFound   = errors.New("peer has no proof")
+	ErrUnauthorized    = errors.New("peer refused to prove")
+	ErrInvalidEvidence = errors.New("invalid evidence")
+)
+
+// MeasurementFunc returns the measurement of the prover.
+type MeasurementFunc func() ([]byte, error)
+
+// Evidence is the content of a Proof.
+type Evidence struct {
+	Nonce       []byte
+	Measurement []byte
+	// Binding is a MAC over the nonce and the measurement under a key derived from the session key,
+	// so the evidence can't be relayed from another session. The evidence of the group attestation
+	// is bound to the identity key of the prover instead, see NewGroupEvidence.
+	Binding []byte
+	// Format is AppCoseSign1 or AppCWT when the measurement is an EAT, otherwise the evidence is encoded as TLV.
+	Format message.MediaType
+}
+
+// FormatOf returns the content format of the quotes of the attester.
+func FormatOf(a Attester) message.MediaType {
+	if f, ok := a.(interface{ ContentFormat() message.MediaType }); ok {
+		return f.ContentFormat()
+	}
+	return message.AppOctets
+}
+
+// IsToken reports whether the measurement is an EAT.
+func (e Evidence) IsToken() bool {
+	return isTokenFormat(e.Format)
+}
+
+func isTokenFormat(format message.MediaType) bool {
+	return format == message.AppCoseSign1 || format == message.AppCWT
+}
+
+// NewNonce creates a fresh challenge.
+func NewNonce() ([]byte, error) {
+	nonce := coder.RandomBytes(NonceSize)
+	if nonce == nil {
+		return nil, errors.New("cannot generate nonce")
+	}
+	return nonce, nil
+}
+
+// BindingKey derives the key which binds evidence to the session.
+func BindingKey(sessionKey []byte) ([]byte, error) {
+	key := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sessionKey, nil, []byte(bindingKeyLabel)), key); err != nil {
+		return nil, fmt.Errorf("cannot derive binding key: %w", err)
+	}
+	return key, nil
+}
+
+// HandshakeNonce derives the nonce of the evidence which is sent in the handshake from its transcript.
+func HandshakeNonce(transcript []byte) ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, transcript, nil, []byte(handshakeNonceLabel)), nonce); err != nil {
+		return nil, fmt.Errorf("cannot derive handshake nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// NewEvidence binds the measurement to the nonce and the session.
+func NewEvidence(bindingKey, nonce, measurement []byte) Evidence {
+	e := Evidence{
+		Nonce:       nonce,
+		Measurement: measurement,
+	}
+	e.Binding = e.mac(bindingKey)
+	return e
+}
+
+func (e Evidence) mac(bindingKey []byte) []byte {
+	mac := hmac.New(sha256.New, bindingKey)
+	mac.Write(appendTLV(appendTLV(nil, evidenceNonce, e.Nonce), evidenceMeasurement, e.Measurement))
+	return mac.Sum(nil)
+}
+
+// Verify checks that the evidence answers the nonce and that it was created in the session.
+func (e Evidence) Verify(bindingKey, nonce []byte) error {
+	if !hmac.Equal(e.Nonce, nonce) {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidEvidence)
+	}
+	if !hmac.Equal(e.Binding, e.mac(bindingKey)) {
+		return fmt.Errorf("%w: not bound to the session", ErrInvalidEvidence)
+	}
+	return nil
+}
+
+// GroupNonce derives the nonce which the member of the group quotes from the nonce of the multicast PROVE
+// and the identity key of the member, so the quote of its attestation key names the member.
+func GroupNonce(nonce []byte, identity ed25519.PublicKey) ([]byte, error) {
+	groupNonce := make([]byte, NonceSize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, append(append([]byte(nil), nonce...), identity...), nil, []byte(groupNonceLabel)), groupNonce); err != nil {
+		return nil, fmt.Errorf("cannot derive group nonce: %w", err)
+	}
+	return groupNonce, nil
+}
+
+// NewGroupEvidence binds the measurement, which quotes GroupNonce of the nonce, to the identity key of the member.
+// The binding is the identity public key followed by its signature over the nonce, the identity and the measurement.
+func NewGroupEvidence(identityKey ed25519.PrivateKey, nonce, measurement []byte) (Evidence, error) {
+	identity := identityKey.Public().(ed25519.PublicKey)
+	groupNonce, err := GroupNonce(nonce, identity)
+	if err != nil {
+		return Evidence{}, err
+	}
+	e := Evidence{
+		Nonce:       groupNonce,
+		Measurement: measurement,
+	}
+	e.Binding = append(append([]byte(nil), identity...), ed25519.Sign(identityKey, e.groupSignedData(nonce, identity))...)
+	return e, nil
+}
+
+func (e Evidence) groupSignedData(nonce []byte, identity ed25519.PublicKey) []byte {
+	data := appendTLV([]byte(groupNonceLabel), evidenceNonce, nonce)
+	data = appendTLV(data, evidenceBinding, identity)
+	return appendTLV(data, evidenceMeasurement, e.Measurement)
+}
+
+// VerifyGroup checks that the evidence answers the nonce of the multicast PROVE and returns the identity key
+// of the member which signed it.
+func (e Evidence) VerifyGroup(nonce []byte) (ed25519.PublicKey, error) {
+	if len(e.Binding) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: not bound to an identity", ErrInvalidEvidence)
+	}
+	identity := ed25519.PublicKey(bytes.Clone(e.Binding[:ed25519.PublicKeySize]))
+	groupNonce, err := GroupNonce(nonce, identity)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(e.Nonce, groupNonce) {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidEvidence)
+	}
+	if !ed25519.Verify(identity, e.groupSignedData(nonce, identity), e.Binding[ed25519.PublicKeySize:]) {
+		return nil, fmt.Errorf("%w: invalid identity signature", ErrInvalidEvidence)
+	}
+	return identity, nil
+}
+
+// Marshal encodes the evidence as TLV (1 byte type, 2 bytes length).
+func (e Evidence) Marshal() []byte {
+	buf := appendTLV(nil, evidenceNonce, e.Nonce)
+	buf = appendTLV(buf, evidenceMeasurement, e.Measurement)
+	return appendTLV(buf, evidenceBinding, e.Binding)
+}
+
+// Encode encodes the evidence for the proof. An EAT is sent as is, with the binding in its unprotected header.
+func (e Evidence) Encode() (message.MediaType, []byte, error) {
+	if !e.IsToken() {
+		return message.AppOctets, e.Marshal(), nil
+	}
+	t, err := parseSignedToken(e.Measurement)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(e.Binding) > 0 {
+		binding, errM := cborEncMode.Marshal(e.Binding)
+		if errM != nil {
+			return 0, nil, errM
+		}
+		t.sign1.Unprotected[coseHeaderBinding] = binding
+	}
+	data, err := t.marshal()
+	if err != nil {
+		return 0, nil, err
+	}
+	return e.Format, data, nil
+}
+
+// DecodeEvidence decodes the proof of the content format.
+func DecodeEvidence(format message.MediaType, data []byte) (Evidence, error) {
+	if !isTokenFormat(format) {
+		return ParseEvidence(data)
+	}
+	t, err := parseSignedToken(data)
+	if err != nil {
+		return Evidence{}, err
+	}
+	var binding []byte
+	if raw, ok := t.sign1.Unprotected[coseHeaderBinding]; ok {
+		if err = cborDecMode.Unmarshal(raw, &binding); err != nil {
+			return Evidence{}, fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+		}
+		delete(t.sign1.Unprotected, coseHeaderBinding)
+	}
+	var claims Claims
+	if err = cborDecMode.Unmarshal(t.sign1.Payload, &claims); err != nil {
+		return Evidence{}, fmt.Errorf("%w: %v", ErrInvalidEvidence, err)
+	}
+	measurement, err := t.marshal()
+	if err != nil {
+		return Evidence{}, err
+	}
+	return Evidence{
+		Nonce:       claims.Nonce,
+		Measurement: measurement,
+		Binding:     binding,
+		Format:      format,
+	}, nil
+}
+
+// ParseEvidence decodes the evidence, unknown types are skipped.
+func ParseEvidence(data []byte) (Evidence, error) {
+	var e Evidence
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return Evidence{}, ErrInvalidEvidence
+		}
+		typ := data[0]
+		size := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if len(data) < size {
+			return Evidence{}, ErrInvalidEvidence
+		}
+		value := data[:size]
+		data = data[size:]
+		switch typ {
+		case evidenceNonce:
+			e.Nonce = value
+		case evidenceMeasurement:
+			e.Measurement = value
+		case evidenceBinding:
+			e.Binding = value
+		}
+	}
+	return e, nil
+}
+
+func appendTLV(buf []byte, typ byte, value []byte) []byte {
+	buf = append(buf, typ)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+	return append(buf, value...)
+}
@@ -0,0 +1,65 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvidence(t *testing.T) {
+	bindingKey, err := BindingKey([]byte("session key"))
+	require.NoError(t, err)
+	otherKey, err := BindingKey([]byte("other session key"))
+	require.NoError(t, err)
+	nonce, err := NewNonce()
+	require.NoError(t, err)
+	otherNonce, err := NewNonce()
+	require.NoError(t, err)
+
+	evidence, err := ParseEvidence(NewEvidence(bindingKey, nonce, []byte("measurement")).Marshal())
+	require.NoError(t, err)
+	require.Equal(t, []byte("measurement"), evidence.Measurement)
+	require.NoError(t, evidence.Verify(bindingKey, nonce))
+	require.ErrorIs(t, evidence.Verify(bindingKey, otherNonce), ErrInvalidEvidence)
+	require.ErrorIs(t, evidence.Verify(otherKey, nonce), ErrInvalidEvidence)
+
+	evidence.Measurement = []byte("forged")
+	require.ErrorIs(t, evidence.Verify(bindingKey, nonce), ErrInvalidEvidence)
+
+	_, err = ParseEvidence([]byte{evidenceNonce, 0, 16, 1})
+	require.ErrorIs(t, err, ErrInvalidEvidence)
+}
+
+func TestGroupEvidence(t *testing.T) {
+	identityKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	nonce, err := NewNonce()
+	require.NoError(t, err)
+	otherNonce, err := NewNonce()
+	require.NoError(t, err)
+
+	e, err := NewGroupEvidence(identityKey, nonce, []byte("measurement"))
+	require.NoError(t, err)
+	evidence, err := ParseEvidence(e.Marshal())
+	require.NoError(t, err)
+	groupNonce, err := GroupNonce(nonce, identityKey.Public().(ed25519.PublicKey))
+	require.NoError(t, err)
+	require.Equal(t, groupNonce, evidence.Nonce)
+	identity, err := evidence.VerifyGroup(nonce)
+	require.NoError(t, err)
+	require.Equal(t, identityKey.Public(), identity)
+	_, err = evidence.VerifyGroup(otherNonce)
+	require.ErrorIs(t, err, ErrInvalidEvidence)
+
+	// the evidence of the member can't be claimed by another identity
+	claimed := evidence
+	claimed.Binding = append(append([]byte(nil), otherKey.Public().(ed25519.PublicKey)...), evidence.Binding[ed25519.PublicKeySize:]...)
+	_, err = claimed.VerifyGroup(nonce)
+	require.ErrorIs(t, err, ErrInvalidEvidence)
+
+	evidence.Measurement = []byte("forged")
+	_, err = evidence.VerifyGroup(nonce)
+	require.ErrorIs(t, err, ErrInvalidEvidence)
+}
@@ -0,0 +1,127 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Formats of the quote.
+const (
+	QuoteSoftware = "software"
+	QuoteTPM2     = "tpm2"
+
+	softwareQuoteLabel = "ascon software quote"
+)
+
+var ErrInvalidQuote = errors.New("invalid quote")
+
+// Attester answers the challenge of the verifier with the evidence of the prover.
+type Attester interface {
+	// Quote returns the measurement which is proved to the verifier for the nonce.
+	Quote(nonce []byte) ([]byte, error)
+}
+
+// Quote implements Attester by returning the measurement, which doesn't depend on the nonce.
+func (f MeasurementFunc) Quote([]byte) ([]byte, error) {
+	return f()
+}
+
+// Quote is the measurement produced by SoftwareAttester and TPMAttester.
+type Quote struct {
+	Format string          `json:"format"`
+	Nonce  []byte          `json:"nonce"`
+	Log    *MeasurementLog `json:"log"`
+	// Attest is the TPMS_ATTEST structure signed by the TPM.
+	Attest []byte `json:"attest,omitempty"`
+	// Signature is ed25519 over the software quote data or ASN.1 ECDSA over Attest.
+	Signature []byte `json:"signature,omitempty"`
+	// PublicKey is the PKIX key which verifies the signature.
+	PublicKey []byte `json:"publicKey,omitempty"`
+}
+
+// Marshal encodes the quote as JSON.
+func (q *Quote) Marshal() ([]byte, error) {
+	return json.Marshal(q)
+}
+
+// ParseQuote decodes the quote and verifies that its measurement log replays to its PCR.
+func ParseQuote(data []byte) (*Quote, error) {
+	var q Quote
+	if err := json.Unmarshal(data, &q); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	if q.Log == nil {
+		return nil, fmt.Errorf("%w: missing measurement log", ErrInvalidQuote)
+	}
+	if err := q.Log.Verify(); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// Verify checks that the quote answers the nonce and that it is signed by its public key.
+// An unsigned software quote is only bound to the session by the evidence.
+func (q *Quote) Verify(nonce []byte) error {
+	if !bytes.Equal(q.Nonce, nonce) {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidQuote)
+	}
+	switch q.Format {
+	case QuoteSoftware:
+		return q.verifySoftware()
+	case QuoteTPM2:
+		return q.verifyTPM()
+	}
+	return fmt.Errorf("%w: unknown format %v", ErrInvalidQuote, q.Format)
+}
+
+func softwareQuoteData(nonce, pcr []byte) []byte {
+	data := append([]byte(softwareQuoteLabel), nonce...)
+	return append(data, pcr...)
+}
+
+func (q *Quote) verifySoftware() error {
+	if q.Signature == nil && q.PublicKey == nil {
+		return nil
+	}
+	key, err := x509.ParsePKIXPublicKey(q.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok || !ed25519.Verify(pub, softwareQuoteData(q.Nonce, q.Log.PCR), q.Signature) {
+		return fmt.Errorf("%w: invalid signature", ErrInvalidQuote)
+	}
+	return nil
+}
+
+// SoftwareAttester quotes the measurement log of the Measurer without a TPM.
+type SoftwareAttester struct {
+	Measurer *Measurer
+	// Key signs the quote, when it is set.
+	Key ed25519.PrivateKey
+}
+
+// Quote measures and signs the measurement log.
+func (a *SoftwareAttester) Quote(nonce []byte) ([]byte, error) {
+	l, err := a.Measurer.Measure()
+	if err != nil {
+		return nil, err
+	}
+	q := Quote{
+		Format: QuoteSoftware,
+		Nonce:  nonce,
+		Log:    l,
+	}
+	if a.Key != nil {
+		q.PublicKey, err = x509.MarshalPKIXPublicKey(a.Key.Public())
+		if err != nil {
+			return nil, fmt.Errorf("cannot marshal public key: %w", err)
+		}
+		q.Signature = ed25519.Sign(a.Key, softwareQuoteData(nonce, l.PCR))
+	}
+	return q.Marshal()
+}
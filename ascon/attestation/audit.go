@@ -0,0 +1,298 @@
+package attestation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+)
+
+var (
+	ErrAuditModified  = errors.New("audit log was modified")
+	ErrAuditTruncated = errors.New("audit log was truncated")
+)
+
+// AuditEvent is the kind of the audit entry.
+type AuditEvent string
+
+const (
+	// AuditRequest records the challenge which was sent to the prover.
+	AuditRequest AuditEvent = "request"
+	// AuditVerdict records the appraisal of the evidence.
+	AuditVerdict AuditEvent = "verdict"
+)
+
+// AuditEntry is an entry of the audit log. Hash is Ascon-Hash of the JSON encoding of the entry without the hash,
+// so every entry commits to the previous one.
+type AuditEntry struct {
+	Sequence uint64     `json:"seq"`
+	Time     time.Time  `json:"time"`
+	Event    AuditEvent `json:"event"`
+	// Method is the way of the attestation: prove, proof, handshake or passport.
+	Method  string `json:"method,omitempty"`
+	Peer    string `json:"peer,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Device  Device `json:"device"`
+	Nonce   Digest `json:"nonce,omitempty"`
+	// EvidenceDigest is Ascon-Hash of the evidence.
+	EvidenceDigest Digest `json:"evidenceDigest,omitempty"`
+	// Status of the verdict, it is empty when the prover didn't answer the challenge.
+	Status   string   `json:"status,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
+	Previous Digest   `json:"prev"`
+	Hash     Digest   `json:"hash,omitempty"`
+}
+
+func (e AuditEntry) digest() ([]byte, error) {
+	e.Hash = nil
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return coder.Hash(data), nil
+}
+
+// EvidenceDigest returns Ascon-Hash of the evidence for the audit entry.
+func EvidenceDigest(data []byte) Digest {
+	return coder.Hash(data)
+}
+
+// AuditLog is the append-only, hash-chained log of the attestations. When it is opened from a file, the entries
+// are appended to the file as JSON lines and only the hash and the sequence number of the last entry are kept in memory.
+type AuditLog struct {
+	mutex    sync.Mutex
+	entries  []AuditEntry
+	head     []byte
+	sequence uint64
+	path     string
+	file     *os.File
+}
+
+// NewAuditLog creates the log in memory.
+func NewAuditLog() *AuditLog {
+	return &AuditLog{head: make([]byte, coder.HashBytes)}
+}
+
+// OpenAuditLog verifies the JSON lines of the file and appends the following entries to it. A missing file is created.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+	var chain auditChain
+	err = chain.read(f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekEnd)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot load audit log %v: %w", path, err)
+	}
+	l := NewAuditLog()
+	if chain.sequence > 0 {
+		l.head = chain.previous
+	}
+	l.sequence = chain.sequence
+	l.path = path
+	l.file = f
+	return l, nil
+}
+
+// Append sets the sequence number, the time when it is zero, and the hashes of the entry and appends it.
+func (l *AuditLog) Append(e AuditEntry) (AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.path != "" && l.file == nil {
+		return AuditEntry{}, fmt.Errorf("cannot write audit log: %w", os.ErrClosed)
+	}
+	e.Sequence = l.sequence
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	// the time is encoded as UTC, so the entry hashes the same after it is read back
+	e.Time = e.Time.UTC().Round(0)
+	e.Previous = l.head
+	hash, err := e.digest()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	e.Hash = hash
+	if l.file != nil {
+		line, errM := json.Marshal(e)
+		if errM != nil {
+			return AuditEntry{}, errM
+		}
+		if _, err = l.file.Write(append(line, '\n')); err != nil {
+			return AuditEntry{}, fmt.Errorf("cannot write audit log: %w", err)
+		}
+	} else {
+		l.entries = append(l.entries, e)
+	}
+	l.sequence++
+	l.head = hash
+	return e, nil
+}
+
+// Head returns the hash of the last entry, which must be kept apart from the log to detect truncation.
+func (l *AuditLog) Head() []byte {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]byte(nil), l.head...)
+}
+
+// Entries returns a copy of the entries of the log in memory. The log which is opened from a file doesn't keep
+// its entries, use Export or read the file by VerifyAuditFile.
+func (l *AuditLog) Entries() []AuditEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]AuditEntry(nil), l.entries...)
+}
+
+// Export writes the entries as JSON lines. The entries of the log which is opened from a file are copied from the file.
+func (l *AuditLog) Export(w io.Writer) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.path != "" {
+		f, err := os.Open(l.path)
+		if err != nil {
+			return fmt.Errorf("cannot open audit log: %w", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		_, err = io.Copy(w, f)
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range l.entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file of the log.
+func (l *AuditLog) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ReadAuditLog decodes the JSON lines.
+func ReadAuditLog(r io.Reader) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	err := scanAuditLog(r, func(e AuditEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func scanAuditLog(r io.Reader, f func(e AuditEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var n int
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e AuditEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("%w: entry %v: %v", ErrAuditModified, n, err)
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+		n++
+	}
+	return scanner.Err()
+}
+
+// VerifyAuditLog checks the hash chain from the first entry. When head is set, the log must end by the entry with
+// the hash, otherwise the entries removed from the end are not detected.
+func VerifyAuditLog(entries []AuditEntry, head []byte) error {
+	var chain auditChain
+	for _, e := range entries {
+		if err := chain.next(e); err != nil {
+			return err
+		}
+	}
+	return chain.end(head)
+}
+
+// VerifyAuditFile checks the hash chain of the JSON lines of the file like VerifyAuditLog. The entries are read
+// one by one, the log is not loaded in memory.
+func VerifyAuditFile(path string, head []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot open audit log: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var chain auditChain
+	if err = chain.read(f); err != nil {
+		return err
+	}
+	return chain.end(head)
+}
+
+// auditChain verifies the entries in order, it keeps the hash and the sequence number of the next entry.
+type auditChain struct {
+	sequence uint64
+	previous []byte
+}
+
+func (c *auditChain) next(e AuditEntry) error {
+	if c.previous == nil {
+		c.previous = make([]byte, coder.HashBytes)
+	}
+	if e.Sequence != c.sequence {
+		return fmt.Errorf("%w: entry %v has sequence %v", ErrAuditTruncated, c.sequence, e.Sequence)
+	}
+	if !bytes.Equal(e.Previous, c.previous) {
+		return fmt.Errorf("%w: entry %v doesn't follow the previous entry", ErrAuditModified, c.sequence)
+	}
+	hash, err := e.digest()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, e.Hash) {
+		return fmt.Errorf("%w: entry %v", ErrAuditModified, c.sequence)
+	}
+	c.previous = hash
+	c.sequence++
+	return nil
+}
+
+func (c *auditChain) read(r io.Reader) error {
+	return scanAuditLog(r, c.next)
+}
+
+func (c *auditChain) end(head []byte) error {
+	previous := c.previous
+	if previous == nil {
+		previous = make([]byte, coder.HashBytes)
+	}
+	if head != nil && !bytes.Equal(head, previous) {
+		return fmt.Errorf("%w: log doesn't end by the head %x", ErrAuditTruncated, head)
+	}
+	return nil
+}
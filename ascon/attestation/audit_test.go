@@ -0,0 +1,100 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := OpenAuditLog(path)
+	require.NoError(t, err)
+	device := Device{Class: "sensor", FirmwareVersion: "1.0"}
+	_, err = l.Append(AuditEntry{Event: AuditRequest, Method: "prove", Peer: "127.0.0.1:5684", Nonce: []byte("nonce")})
+	require.NoError(t, err)
+	_, err = l.Append(AuditEntry{Event: AuditVerdict, Method: "prove", Device: device, EvidenceDigest: EvidenceDigest([]byte("evidence")), Status: StatusAffirming.String()})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// the log continues after it is reopened
+	l, err = OpenAuditLog(path)
+	require.NoError(t, err)
+	e, err := l.Append(AuditEntry{Event: AuditVerdict, Method: "passport", Status: StatusContraindicated.String(), Reasons: []string{"passport expired"}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), e.Sequence)
+	head := l.Head()
+	require.Equal(t, []byte(e.Hash), head)
+	// the entries are kept in the file only
+	require.Empty(t, l.Entries())
+	require.NoError(t, l.Close())
+	require.NoError(t, VerifyAuditFile(path, head))
+	_, err = l.Append(AuditEntry{Event: AuditRequest})
+	require.Error(t, err)
+
+	var exported bytes.Buffer
+	require.NoError(t, l.Export(&exported))
+	entries, err := ReadAuditLog(&exported)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.NoError(t, VerifyAuditLog(entries, head))
+
+	tests := []struct {
+		name    string
+		tamper  func(entries []AuditEntry) []AuditEntry
+		wantErr error
+	}{
+		{
+			name: "modified",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1].Status = StatusWarning.String()
+				return entries
+			},
+			wantErr: ErrAuditModified,
+		},
+		{
+			name: "rehashed",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				entries[1].Status = StatusWarning.String()
+				hash, err := entries[1].digest()
+				require.NoError(t, err)
+				entries[1].Hash = hash
+				return entries
+			},
+			wantErr: ErrAuditModified,
+		},
+		{
+			name: "removed",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			wantErr: ErrAuditTruncated,
+		},
+		{
+			name: "truncated",
+			tamper: func(entries []AuditEntry) []AuditEntry {
+				return entries[:2]
+			},
+			wantErr: ErrAuditTruncated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := tt.tamper(append([]AuditEntry(nil), entries...))
+			require.ErrorIs(t, VerifyAuditLog(tampered, head), tt.wantErr)
+
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			for _, e := range tampered {
+				require.NoError(t, enc.Encode(e))
+			}
+			tamperedPath := filepath.Join(t.TempDir(), "audit.jsonl")
+			require.NoError(t, os.WriteFile(tamperedPath, buf.Bytes(), 0o600))
+			require.ErrorIs(t, VerifyAuditFile(tamperedPath, head), tt.wantErr)
+		})
+	}
+}
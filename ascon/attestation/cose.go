@@ -0,0 +1,188 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE and CBOR identifiers (RFC 9052, RFC 8392).
+const (
+	coseAlgES256  = -7
+	coseAlgEdDSA  = -8
+	coseHeaderAlg = 1
+	// coseHeaderBinding is the private unprotected header which carries the session binding of the evidence.
+	coseHeaderBinding = -65537
+
+	cborTagCoseSign1 = 18
+	cborTagCWT       = 61
+
+	coseSignature1 = "Signature1"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+var (
+	cborEncMode = func() cbor.EncMode {
+		m, err := cbor.CoreDetEncOptions().EncMode()
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}()
+	cborDecMode = func() cbor.DecMode {
+		m, err := cbor.DecOptions{IntDec: cbor.IntDecConvertSigned}.DecMode()
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}()
+)
+
+type coseHeader struct {
+	Alg int64 `cbor:"1,keyasint"`
+}
+
+type coseSign1 struct {
+	_           struct{} `cbor:",toarray"`
+	Protected   []byte
+	Unprotected map[int64]cbor.RawMessage
+	Payload     []byte
+	Signature   []byte
+}
+
+// signedToken is COSE_Sign1, optionally wrapped in the CWT tag.
+type signedToken struct {
+	sign1 coseSign1
+	cwt   bool
+}
+
+func (t *signedToken) marshal() ([]byte, error) {
+	var v interface{} = cbor.Tag{Number: cborTagCoseSign1, Content: t.sign1}
+	if t.cwt {
+		v = cbor.Tag{Number: cborTagCWT, Content: v}
+	}
+	return cborEncMode.Marshal(v)
+}
+
+func parseSignedToken(data []byte) (*signedToken, error) {
+	var t signedToken
+	var tag cbor.RawTag
+	if err := cborDecMode.Unmarshal(data, &tag); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if tag.Number == cborTagCWT {
+		t.cwt = true
+		if err := cborDecMode.Unmarshal(tag.Content, &tag); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+	}
+	if tag.Number != cborTagCoseSign1 {
+		return nil, fmt.Errorf("%w: unexpected tag %v", ErrInvalidToken, tag.Number)
+	}
+	if err := cborDecMode.Unmarshal(tag.Content, &t.sign1); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if t.sign1.Unprotected == nil {
+		t.sign1.Unprotected = map[int64]cbor.RawMessage{}
+	}
+	return &t, nil
+}
+
+func toBeSigned(protected, payload []byte) ([]byte, error) {
+	return cborEncMode.Marshal([]interface{}{coseSignature1, protected, []byte{}, payload})
+}
+
+// signToken signs the payload by ed25519 (EdDSA) or P-256 (ES256) key.
+func signToken(key crypto.Signer, payload []byte, cwt bool) (*signedToken, error) {
+	var alg int64
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		alg = coseAlgEdDSA
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve %v", pub.Curve.Params().Name)
+		}
+		alg = coseAlgES256
+	default:
+		return nil, fmt.Errorf("unsupported key %T", pub)
+	}
+	protected, err := cborEncMode.Marshal(coseHeader{Alg: alg})
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := toBeSigned(protected, payload)
+	if err != nil {
+		return nil, err
+	}
+	var signature []byte
+	if alg == coseAlgEdDSA {
+		signature, err = key.Sign(rand.Reader, tbs, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(tbs)
+		signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err == nil {
+			signature, err = ecdsaRawSignature(signature)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign token: %w", err)
+	}
+	return &signedToken{
+		sign1: coseSign1{
+			Protected:   protected,
+			Unprotected: map[int64]cbor.RawMessage{},
+			Payload:     payload,
+			Signature:   signature,
+		},
+		cwt: cwt,
+	}, nil
+}
+
+// ecdsaRawSignature converts ASN.1 signature to r || s.
+func ecdsaRawSignature(signature []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+	return raw, nil
+}
+
+func (t *signedToken) verify(key crypto.PublicKey) error {
+	var h coseHeader
+	if err := cborDecMode.Unmarshal(t.sign1.Protected, &h); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	tbs, err := toBeSigned(t.sign1.Protected, t.sign1.Payload)
+	if err != nil {
+		return err
+	}
+	switch pub := key.(type) {
+	case ed25519.PublicKey:
+		if h.Alg == coseAlgEdDSA && ed25519.Verify(pub, tbs, t.sign1.Signature) {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if h.Alg == coseAlgES256 && len(t.sign1.Signature) == 64 {
+			digest := sha256.Sum256(tbs)
+			r := new(big.Int).SetBytes(t.sign1.Signature[:32])
+			s := new(big.Int).SetBytes(t.sign1.Signature[32:])
+			if ecdsa.Verify(pub, digest[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: invalid signature", ErrInvalidToken)
+}
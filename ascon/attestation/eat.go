@@ -0,0 +1,118 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+)
+
+// Claims of the Entity Attestation Token (RFC 9711).
+type Claims struct {
+	IssuedAt     int64            `cbor:"6,keyasint,omitempty"`
+	Nonce        []byte           `cbor:"10,keyasint,omitempty"`
+	UEID         []byte           `cbor:"256,keyasint,omitempty"`
+	BootSeed     []byte           `cbor:"268,keyasint,omitempty"`
+	Measurements []EATMeasurement `cbor:"273,keyasint,omitempty"`
+}
+
+// EATMeasurement is the measurement claim, the content is identified by the CoAP content format.
+type EATMeasurement struct {
+	_             struct{} `cbor:",toarray"`
+	ContentFormat message.MediaType
+	Content       []byte
+}
+
+// Log returns the measurement log from the measurements.
+func (c *Claims) Log() (*MeasurementLog, error) {
+	for _, m := range c.Measurements {
+		if m.ContentFormat == message.AppJSON {
+			return ParseMeasurementLog(m.Content)
+		}
+	}
+	return nil, fmt.Errorf("%w: missing measurement log", ErrInvalidToken)
+}
+
+// Token is the EAT which is signed in COSE_Sign1.
+type Token struct {
+	Claims Claims
+	signed *signedToken
+}
+
+// ParseToken decodes COSE_Sign1 or CWT EAT. The signature must be checked by Verify.
+func ParseToken(data []byte) (*Token, error) {
+	signed, err := parseSignedToken(data)
+	if err != nil {
+		return nil, err
+	}
+	t := Token{signed: signed}
+	if err = cborDecMode.Unmarshal(signed.sign1.Payload, &t.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &t, nil
+}
+
+// Verify checks the signature of the token by the PKIX public key.
+func (t *Token) Verify(publicKey []byte) error {
+	key, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return t.signed.verify(key)
+}
+
+// ContentFormat returns AppCWT or AppCoseSign1.
+func (t *Token) ContentFormat() message.MediaType {
+	if t.signed.cwt {
+		return message.AppCWT
+	}
+	return message.AppCoseSign1
+}
+
+// EATAttester quotes the measurement log of the Measurer in a signed EAT.
+type EATAttester struct {
+	Measurer *Measurer
+	// Key is ed25519 or P-256 key which signs the token.
+	Key      crypto.Signer
+	UEID     []byte
+	BootSeed []byte
+	// CWT wraps the token in the CWT tag and sends it as AppCWT instead of AppCoseSign1.
+	CWT bool
+}
+
+// Quote measures and signs the claims.
+func (a *EATAttester) Quote(nonce []byte) ([]byte, error) {
+	l, err := a.Measurer.Measure()
+	if err != nil {
+		return nil, err
+	}
+	log, err := l.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := cborEncMode.Marshal(Claims{
+		IssuedAt:     time.Now().Unix(),
+		Nonce:        nonce,
+		UEID:         a.UEID,
+		BootSeed:     a.BootSeed,
+		Measurements: []EATMeasurement{{ContentFormat: message.AppJSON, Content: log}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal claims: %w", err)
+	}
+	signed, err := signToken(a.Key, payload, a.CWT)
+	if err != nil {
+		return nil, err
+	}
+	return signed.marshal()
+}
+
+// ContentFormat returns the content format of the proof.
+func (a *EATAttester) ContentFormat() message.MediaType {
+	if a.CWT {
+		return message.AppCWT
+	}
+	return message.AppCoseSign1
+}
@@ -0,0 +1,93 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEATEvidence(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	bindingKey, err := BindingKey([]byte("session key"))
+	require.NoError(t, err)
+	measurer := &Measurer{Config: []byte("runtime")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	device := Device{Class: "sensor", FirmwareVersion: "1.0"}
+
+	tests := []struct {
+		name       string
+		key        crypto.Signer
+		cwt        bool
+		wantFormat message.MediaType
+	}{
+		{name: "eddsa", key: edKey, wantFormat: message.AppCoseSign1},
+		{name: "es256-cwt", key: ecKey, cwt: true, wantFormat: message.AppCWT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicKey, err := x509.MarshalPKIXPublicKey(tt.key.Public())
+			require.NoError(t, err)
+			references := &ReferenceValues{}
+			references.Add(Reference{Device: device, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{golden.PCR}})
+			v := NewVerifier(references, 0)
+			nonce, err := v.NewNonce()
+			require.NoError(t, err)
+
+			attester := &EATAttester{Measurer: measurer, Key: tt.key, UEID: []byte{0x01, 0xaa}, BootSeed: []byte("seed"), CWT: tt.cwt}
+			token, err := attester.Quote(nonce)
+			require.NoError(t, err)
+			evidence := NewEvidence(bindingKey, nonce, token)
+			evidence.Format = FormatOf(attester)
+			format, data, err := evidence.Encode()
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFormat, format)
+
+			decoded, err := DecodeEvidence(format, data)
+			require.NoError(t, err)
+			require.Equal(t, evidence, decoded)
+			require.NoError(t, decoded.Verify(bindingKey, nonce))
+
+			parsed, err := ParseToken(decoded.Measurement)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFormat, parsed.ContentFormat())
+			require.Equal(t, []byte("seed"), parsed.Claims.BootSeed)
+			require.NoError(t, parsed.Verify(publicKey))
+
+			result := v.Verify(device, &decoded, bindingKey)
+			require.Equal(t, StatusAffirming, result.Status, result.Reasons)
+			require.Equal(t, []byte{0x01, 0xaa}, result.UEID)
+		})
+	}
+}
+
+func TestEATUntrustedKey(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := x509.MarshalPKIXPublicKey(other.Public())
+	require.NoError(t, err)
+	device := Device{Class: "sensor"}
+	references := &ReferenceValues{}
+	references.Add(Reference{Device: device, AttestationKeys: [][]byte{otherKey}})
+	v := NewVerifier(references, 0)
+	nonce, err := v.NewNonce()
+	require.NoError(t, err)
+
+	attester := &EATAttester{Measurer: &Measurer{}, Key: key}
+	token, err := attester.Quote(nonce)
+	require.NoError(t, err)
+	evidence := Evidence{Nonce: nonce, Measurement: token, Format: message.AppCoseSign1}
+	result := v.Verify(device, &evidence, nil)
+	require.Equal(t, StatusContraindicated, result.Status)
+	require.Contains(t, result.Reasons, "token is not signed by a trusted attestation key")
+}
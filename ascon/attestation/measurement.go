@@ -1 +1,178 @@
 package attestation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// selfExe is the running binary on Linux.
+const selfExe = "/proc/self/exe"
+
+// Types of the measurement log entries.
+const (
+	EntryBinary = "binary"
+	EntryFile   = "file"
+	EntryConfig = "config"
+)
+
+var ErrPCRMismatch = errors.New("measurement log doesn't replay to the PCR")
+
+// Entry is a measured object.
+type Entry struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Digest []byte `json:"digest"`
+}
+
+// MeasurementLog lists the measured objects in the order in which they were extended into the PCR.
+type MeasurementLog struct {
+	Entries []Entry `json:"entries"`
+	PCR     []byte  `json:"pcr"`
+}
+
+// Extend folds the digest into the PCR value: sha256(pcr || digest).
+func Extend(pcr, digest []byte) []byte {
+	h := sha256.New()
+	h.Write(pcr)
+	h.Write(digest)
+	return h.Sum(nil)
+}
+
+// Replay computes the PCR value from the entries, starting from zeros.
+func (l *MeasurementLog) Replay() []byte {
+	pcr := make([]byte, sha256.Size)
+	for _, e := range l.Entries {
+		pcr = Extend(pcr, e.Digest)
+	}
+	return pcr
+}
+
+// Verify checks that the entries replay to the PCR of the log.
+func (l *MeasurementLog) Verify() error {
+	if !bytes.Equal(l.Replay(), l.PCR) {
+		return ErrPCRMismatch
+	}
+	return nil
+}
+
+// Marshal encodes the log as JSON.
+func (l *MeasurementLog) Marshal() ([]byte, error) {
+	return json.Marshal(l)
+}
+
+// ParseMeasurementLog decodes the log from the measurement of evidence and verifies its PCR.
+func ParseMeasurementLog(data []byte) (*MeasurementLog, error) {
+	var l MeasurementLog
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("cannot parse measurement log: %w", err)
+	}
+	if err := l.Verify(); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (l *MeasurementLog) extend(typ, name string, digest []byte) {
+	l.Entries = append(l.Entries, Entry{Type: typ, Name: name, Digest: digest})
+	l.PCR = Extend(l.PCR, digest)
+}
+
+// Measurer measures the running binary, the configured files and directories and the runtime configuration.
+type Measurer struct {
+	// Binary enables the measurement of the running binary.
+	Binary bool
+	// Paths are files or directories. Directories are walked in lexical order.
+	Paths []string
+	// Config is the runtime configuration blob.
+	Config []byte
+}
+
+// Measure creates the measurement log. The order of the entries is the binary, the paths and the configuration.
+func (m *Measurer) Measure() (*MeasurementLog, error) {
+	l := &MeasurementLog{PCR: make([]byte, sha256.Size)}
+	if m.Binary {
+		name, digest, err := measureBinary()
+		if err != nil {
+			return nil, err
+		}
+		l.extend(EntryBinary, name, digest)
+	}
+	for _, path := range m.Paths {
+		if err := measurePath(l, path); err != nil {
+			return nil, err
+		}
+	}
+	if m.Config != nil {
+		digest := sha256.Sum256(m.Config)
+		l.extend(EntryConfig, EntryConfig, digest[:])
+	}
+	return l, nil
+}
+
+// MeasurementFunc returns the marshaled measurement log, so it can be proved by the connection.
+func (m *Measurer) MeasurementFunc() MeasurementFunc {
+	return func() ([]byte, error) {
+		l, err := m.Measure()
+		if err != nil {
+			return nil, err
+		}
+		return l.Marshal()
+	}
+}
+
+func measureBinary() (string, []byte, error) {
+	name, err := os.Executable()
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot find binary: %w", err)
+	}
+	digest, err := hashFile(selfExe)
+	if errors.Is(err, fs.ErrNotExist) {
+		// no procfs
+		digest, err = hashFile(name)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot measure binary: %w", err)
+	}
+	return name, digest, nil
+}
+
+func measurePath(l *MeasurementLog, path string) error {
+	err := filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		digest, err := hashFile(name)
+		if err != nil {
+			return err
+		}
+		l.extend(EntryFile, name, digest)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot measure %v: %w", path, err)
+	}
+	return nil
+}
+
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
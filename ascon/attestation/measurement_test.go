@@ -0,0 +1,47 @@
+package attestation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeasurer(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "conf.d"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "b"), []byte("b"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "a"), []byte("a"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.yaml"), []byte("app"), 0o600))
+
+	m := Measurer{
+		Binary: true,
+		Paths:  []string{filepath.Join(dir, "app.yaml"), filepath.Join(dir, "conf.d")},
+		Config: []byte(`{"debug":false}`),
+	}
+	measurement, err := m.MeasurementFunc()()
+	require.NoError(t, err)
+	l, err := ParseMeasurementLog(measurement)
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range l.Entries {
+		names = append(names, e.Type+":"+filepath.Base(e.Name))
+	}
+	bin, err := os.Executable()
+	require.NoError(t, err)
+	require.Equal(t, []string{"binary:" + filepath.Base(bin), "file:app.yaml", "file:a", "file:b", "config:config"}, names)
+
+	again, err := m.Measure()
+	require.NoError(t, err)
+	require.Equal(t, l.PCR, again.PCR)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "b"), []byte("changed"), 0o600))
+	changed, err := m.Measure()
+	require.NoError(t, err)
+	require.NotEqual(t, l.PCR, changed.PCR)
+
+	l.Entries[1].Digest = changed.Entries[3].Digest
+	require.ErrorIs(t, l.Verify(), ErrPCRMismatch)
+}
@@ -0,0 +1,110 @@
+package attestation
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultPassportLifetime is the time for which the passport is valid.
+const DefaultPassportLifetime = time.Hour
+
+var ErrPassportExpired = errors.New("passport expired")
+
+// PassportClaims of the attestation result which the verifier issues to the attester in the passport model
+// (RFC 9334). The CWT claims (RFC 8392) carry the issuer, the subject and the validity, and the private claims
+// carry the appraisal.
+type PassportClaims struct {
+	Issuer string `cbor:"1,keyasint,omitempty"`
+	// Subject identifies the attester, which must present the passport in a session of the same identity.
+	Subject         string   `cbor:"2,keyasint"`
+	Expiry          int64    `cbor:"4,keyasint"`
+	IssuedAt        int64    `cbor:"6,keyasint"`
+	UEID            []byte   `cbor:"256,keyasint,omitempty"`
+	Status          Status   `cbor:"-70001,keyasint"`
+	Reasons         []string `cbor:"-70002,keyasint,omitempty"`
+	Class           string   `cbor:"-70003,keyasint,omitempty"`
+	FirmwareVersion string   `cbor:"-70004,keyasint,omitempty"`
+}
+
+// Passport is the attestation result token signed by the verifier.
+type Passport struct {
+	Claims PassportClaims
+	signed *signedToken
+}
+
+// IssuePassport signs the attestation result for the subject in a CWT. The key is ed25519 or P-256 key of the
+// verifier. Non-positive lifetime is replaced by DefaultPassportLifetime.
+func IssuePassport(key crypto.Signer, issuer, subject string, result *AttestationResult, lifetime time.Duration) ([]byte, error) {
+	if subject == "" {
+		return nil, errors.New("cannot issue passport: missing subject")
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultPassportLifetime
+	}
+	claims := PassportClaims{
+		Issuer:          issuer,
+		Subject:         subject,
+		Expiry:          result.Time.Add(lifetime).Unix(),
+		IssuedAt:        result.Time.Unix(),
+		UEID:            result.UEID,
+		Status:          result.Status,
+		Reasons:         result.Reasons,
+		Class:           result.Device.Class,
+		FirmwareVersion: result.Device.FirmwareVersion,
+	}
+	payload, err := cborEncMode.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	t, err := signToken(key, payload, true)
+	if err != nil {
+		return nil, err
+	}
+	return t.marshal()
+}
+
+// ParsePassport decodes the passport. The signature must be checked by Verify.
+func ParsePassport(data []byte) (*Passport, error) {
+	signed, err := parseSignedToken(data)
+	if err != nil {
+		return nil, err
+	}
+	p := Passport{signed: signed}
+	if err = cborDecMode.Unmarshal(signed.sign1.Payload, &p.Claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return &p, nil
+}
+
+// Verify checks the signature of the passport by the PKIX public key of the verifier and its validity at now.
+func (p *Passport) Verify(publicKey []byte, now time.Time) error {
+	key, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if err = p.signed.verify(key); err != nil {
+		return err
+	}
+	if now.Unix() >= p.Claims.Expiry {
+		return ErrPassportExpired
+	}
+	return nil
+}
+
+// Result returns the attestation result which the passport carries.
+func (p *Passport) Result() *AttestationResult {
+	return &AttestationResult{
+		Status:  p.Claims.Status,
+		Reasons: p.Claims.Reasons,
+		Device: Device{
+			Class:           p.Claims.Class,
+			FirmwareVersion: p.Claims.FirmwareVersion,
+		},
+		UEID:    p.Claims.UEID,
+		Time:    time.Unix(p.Claims.IssuedAt, 0),
+		Expires: time.Unix(p.Claims.Expiry, 0),
+	}
+}
@@ -0,0 +1,57 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPassport(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherPublicKey, err := x509.MarshalPKIXPublicKey(otherKey.Public())
+	require.NoError(t, err)
+
+	result := &AttestationResult{
+		Status:  StatusWarning,
+		Reasons: []string{"file /etc/app.conf has no reference value"},
+		Device:  Device{Class: "sensor", FirmwareVersion: "1.0"},
+		UEID:    []byte{0x01, 0xaa},
+		Time:    time.Now().Truncate(time.Second),
+	}
+	data, err := IssuePassport(key, "verifier", "psk:device", result, time.Minute)
+	require.NoError(t, err)
+	_, err = IssuePassport(key, "verifier", "", result, time.Minute)
+	require.Error(t, err)
+
+	p, err := ParsePassport(data)
+	require.NoError(t, err)
+	require.Equal(t, "verifier", p.Claims.Issuer)
+	require.Equal(t, "psk:device", p.Claims.Subject)
+	require.NoError(t, p.Verify(publicKey, time.Now()))
+	require.ErrorIs(t, p.Verify(otherPublicKey, time.Now()), ErrInvalidToken)
+	require.ErrorIs(t, p.Verify(publicKey, result.Time.Add(time.Minute)), ErrPassportExpired)
+
+	got := p.Result()
+	require.Equal(t, result.Status, got.Status)
+	require.Equal(t, result.Reasons, got.Reasons)
+	require.Equal(t, result.Device, got.Device)
+	require.Equal(t, result.UEID, got.UEID)
+	require.True(t, result.Time.Equal(got.Time))
+	require.True(t, result.Time.Add(time.Minute).Equal(got.Expires))
+
+	// the claims are signed
+	p.Claims.Status = StatusAffirming
+	p.signed.sign1.Payload, err = cborEncMode.Marshal(p.Claims)
+	require.NoError(t, err)
+	require.ErrorIs(t, p.Verify(publicKey, time.Now()), ErrInvalidToken)
+}
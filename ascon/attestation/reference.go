@@ -0,0 +1,213 @@
+package attestation
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Digest is a hash which is encoded as hex in JSON.
+type Digest []byte
+
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(d)), nil
+}
+
+func (d *Digest) UnmarshalText(text []byte) error {
+	v, err := hex.DecodeString(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid digest: %w", err)
+	}
+	*d = v
+	return nil
+}
+
+// Device selects the reference values of the prover.
+type Device struct {
+	Class           string `json:"deviceClass"`
+	FirmwareVersion string `json:"firmwareVersion"`
+	// Identity is the fingerprint of the identity key of an enrolled device, which selects the golden values
+	// of that device only. The reference of the class and firmware version has no identity.
+	Identity string `json:"identity,omitempty"`
+}
+
+// ReferenceEntry is the golden digest of a measured object.
+type ReferenceEntry struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Digest Digest `json:"digest"`
+}
+
+// Reference holds the golden values of a device class and firmware version.
+type Reference struct {
+	Device
+	// AttestationKeys are the trusted PKIX keys which sign quotes and tokens. The evidence must be signed by one of the keys.
+	AttestationKeys [][]byte `json:"attestationKeys,omitempty"`
+	// AllowUntrustedQuotes accepts the quotes which are unsigned or signed by any key (trust on first use), e.g. of devices
+	// without an attestation key. An unsigned quote is appraised with a warning. The quote proves nothing about the device
+	// then, anybody can send the golden values.
+	AllowUntrustedQuotes bool `json:"allowUntrustedQuotes,omitempty"`
+	// PCRs are the accepted values of the extend chain. A matching PCR affirms the measurement without comparing the entries.
+	PCRs []Digest `json:"pcrs,omitempty"`
+	// Entries are compared to the measurement log.
+	Entries []ReferenceEntry `json:"entries,omitempty"`
+}
+
+// ReferenceStore looks up the reference values of the device.
+type ReferenceStore interface {
+	Lookup(device Device) (*Reference, error)
+}
+
+// ReferenceValues is a ReferenceStore which is loaded from JSON.
+type ReferenceValues struct {
+	mutex      sync.RWMutex
+	references []Reference
+	version    uint64
+}
+
+type referenceValuesFile struct {
+	References []Reference `json:"references"`
+}
+
+// ParseReferenceValues decodes the JSON reference values.
+func ParseReferenceValues(data []byte) (*ReferenceValues, error) {
+	var f referenceValuesFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot parse reference values: %w", err)
+	}
+	return &ReferenceValues{references: f.References}, nil
+}
+
+// LoadReferenceValues reads the JSON reference values from the files. The references of later files are appended.
+func LoadReferenceValues(paths ...string) (*ReferenceValues, error) {
+	v := &ReferenceValues{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read reference values: %w", err)
+		}
+		f, err := ParseReferenceValues(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		v.references = append(v.references, f.references...)
+	}
+	return v, nil
+}
+
+// Add adds or replaces the reference of the device.
+func (v *ReferenceValues) Add(r Reference) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.version++
+	for i := range v.references {
+		if v.references[i].Device == r.Device {
+			v.references[i] = r
+			return
+		}
+	}
+	v.references = append(v.references, r)
+}
+
+// Version is incremented when the reference values change, so the attestation results which were appraised
+// against the previous values can be invalidated.
+func (v *ReferenceValues) Version() uint64 {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.version
+}
+
+// Marshal encodes the reference values as JSON.
+func (v *ReferenceValues) Marshal() ([]byte, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return json.MarshalIndent(referenceValuesFile{References: v.references}, "", "  ")
+}
+
+func (v *ReferenceValues) Lookup(device Device) (*Reference, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	for i := range v.references {
+		if v.references[i].Device == device {
+			r := v.references[i]
+			return &r, nil
+		}
+	}
+	if device.Identity != "" {
+		return nil, fmt.Errorf("%w: class %q, firmware version %q, identity %v", ErrReferenceNotFound, device.Class, device.FirmwareVersion, device.Identity)
+	}
+	return nil, fmt.Errorf("%w: class %q, firmware version %q", ErrReferenceNotFound, device.Class, device.FirmwareVersion)
+}
+
+func (r *Reference) trustsKey(publicKey []byte) bool {
+	if r.AllowUntrustedQuotes {
+		return true
+	}
+	for _, k := range r.AttestationKeys {
+		if bytes.Equal(k, publicKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reference) acceptsPCR(pcr []byte) bool {
+	for _, p := range r.PCRs {
+		if bytes.Equal(p, pcr) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewReference takes the golden values of the device from its initial evidence, e.g. at the enrollment.
+// The quote must be signed, and it is signed by attestationKey when it is set. The token must be signed by attestationKey.
+func NewReference(device Device, evidence *Evidence, attestationKey []byte) (*Reference, error) {
+	var log *MeasurementLog
+	if evidence.IsToken() {
+		if attestationKey == nil {
+			return nil, fmt.Errorf("%w: missing attestation key", ErrInvalidToken)
+		}
+		t, err := ParseToken(evidence.Measurement)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(t.Claims.Nonce, evidence.Nonce) {
+			return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+		}
+		if err = t.Verify(attestationKey); err != nil {
+			return nil, err
+		}
+		if log, err = t.Claims.Log(); err != nil {
+			return nil, err
+		}
+	} else {
+		q, err := ParseQuote(evidence.Measurement)
+		if err != nil {
+			return nil, err
+		}
+		if err = q.Verify(evidence.Nonce); err != nil {
+			return nil, err
+		}
+		if q.Signature == nil {
+			return nil, fmt.Errorf("%w: quote is not signed", ErrInvalidQuote)
+		}
+		if attestationKey != nil && !bytes.Equal(attestationKey, q.PublicKey) {
+			return nil, fmt.Errorf("%w: unexpected attestation key", ErrInvalidQuote)
+		}
+		attestationKey = q.PublicKey
+		log = q.Log
+	}
+	r := &Reference{
+		Device:          device,
+		AttestationKeys: [][]byte{attestationKey},
+		PCRs:            []Digest{log.PCR},
+	}
+	for _, e := range log.Entries {
+		r.Entries = append(r.Entries, ReferenceEntry{Type: e.Type, Name: e.Name, Digest: e.Digest})
+	}
+	return r, nil
+}
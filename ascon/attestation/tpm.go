@@ -0,0 +1,145 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// MeasurementPCR is the resettable PCR which holds the measurements of the TPMAttester.
+const MeasurementPCR = 23
+
+var akTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagSignerDefault,
+	ECCParameters: &tpm2.ECCParams{
+		Sign: &tpm2.SigScheme{
+			Alg:  tpm2.AlgECDSA,
+			Hash: tpm2.AlgSHA256,
+		},
+		CurveID: tpm2.CurveNISTP256,
+	},
+}
+
+// TPMAttester quotes the measurements with the attestation key of a TPM 2.0. The measurement log is
+// extended into MeasurementPCR when the attester is created.
+type TPMAttester struct {
+	mutex     sync.Mutex
+	rw        io.ReadWriter
+	ak        tpmutil.Handle
+	publicKey []byte
+	log       *MeasurementLog
+}
+
+// NewTPMAttester creates the attestation key in the owner hierarchy, resets MeasurementPCR and extends the measurements into it.
+// The rw is a TPM opened by tpm2.OpenTPM or a simulator.
+func NewTPMAttester(rw io.ReadWriter, m *Measurer) (*TPMAttester, error) {
+	l, err := m.Measure()
+	if err != nil {
+		return nil, err
+	}
+	pcr := tpmutil.Handle(MeasurementPCR)
+	if err = tpm2.PCRReset(rw, pcr); err != nil {
+		return nil, fmt.Errorf("cannot reset pcr: %w", err)
+	}
+	for _, e := range l.Entries {
+		if err = tpm2.PCRExtend(rw, pcr, tpm2.AlgSHA256, e.Digest, ""); err != nil {
+			return nil, fmt.Errorf("cannot extend pcr: %w", err)
+		}
+	}
+	ak, pub, err := tpm2.CreatePrimary(rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", akTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create attestation key: %w", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		_ = tpm2.FlushContext(rw, ak)
+		return nil, fmt.Errorf("cannot marshal attestation key: %w", err)
+	}
+	return &TPMAttester{
+		rw:        rw,
+		ak:        ak,
+		publicKey: publicKey,
+		log:       l,
+	}, nil
+}
+
+// PublicKey returns the PKIX attestation key.
+func (a *TPMAttester) PublicKey() []byte {
+	return a.publicKey
+}
+
+// Quote signs MeasurementPCR and the nonce with the attestation key.
+func (a *TPMAttester) Quote(nonce []byte) ([]byte, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	sel := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: []int{MeasurementPCR}}
+	attest, sig, err := tpm2.Quote(a.rw, a.ak, "", "", nonce, sel, tpm2.AlgNull)
+	if err != nil {
+		return nil, fmt.Errorf("cannot quote: %w", err)
+	}
+	if sig.ECC == nil {
+		return nil, fmt.Errorf("unexpected signature algorithm %v", sig.Alg)
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal signature: %w", err)
+	}
+	q := Quote{
+		Format:    QuoteTPM2,
+		Nonce:     nonce,
+		Log:       a.log,
+		Attest:    attest,
+		Signature: signature,
+		PublicKey: a.publicKey,
+	}
+	return q.Marshal()
+}
+
+// Close flushes the attestation key.
+func (a *TPMAttester) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return tpm2.FlushContext(a.rw, a.ak)
+}
+
+func (q *Quote) verifyTPM() error {
+	key, err := x509.ParsePKIXPublicKey(q.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	digest := sha256.Sum256(q.Attest)
+	if !ok || !ecdsa.VerifyASN1(pub, digest[:], q.Signature) {
+		return fmt.Errorf("%w: invalid signature", ErrInvalidQuote)
+	}
+	attest, err := tpm2.DecodeAttestationData(q.Attest)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	if attest.Type != tpm2.TagAttestQuote || attest.AttestedQuoteInfo == nil {
+		return fmt.Errorf("%w: not a quote", ErrInvalidQuote)
+	}
+	if !bytes.Equal(attest.ExtraData, q.Nonce) {
+		return fmt.Errorf("%w: nonce mismatch", ErrInvalidQuote)
+	}
+	sel := attest.AttestedQuoteInfo.PCRSelection
+	if sel.Hash != tpm2.AlgSHA256 || len(sel.PCRs) != 1 || sel.PCRs[0] != MeasurementPCR {
+		return fmt.Errorf("%w: unexpected pcr selection", ErrInvalidQuote)
+	}
+	pcrDigest := sha256.Sum256(q.Log.PCR)
+	if !bytes.Equal(attest.AttestedQuoteInfo.PCRDigest, pcrDigest[:]) {
+		return fmt.Errorf("%w: %v", ErrInvalidQuote, ErrPCRMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+//go:build cgo
+
+package attestation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm-tools/simulator"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTPMAttester(t *testing.T) {
+	sim, err := simulator.Get()
+	require.NoError(t, err)
+	defer func() {
+		errC := sim.Close()
+		require.NoError(t, errC)
+	}()
+
+	config := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(config, []byte("config"), 0o600))
+	a, err := NewTPMAttester(sim, &Measurer{Binary: true, Paths: []string{config}})
+	require.NoError(t, err)
+	defer func() {
+		errC := a.Close()
+		require.NoError(t, errC)
+	}()
+
+	nonce, err := NewNonce()
+	require.NoError(t, err)
+	data, err := a.Quote(nonce)
+	require.NoError(t, err)
+	q, err := ParseQuote(data)
+	require.NoError(t, err)
+	require.Equal(t, QuoteTPM2, q.Format)
+	require.Len(t, q.Log.Entries, 2)
+	require.NoError(t, q.Verify(nonce))
+
+	otherNonce, err := NewNonce()
+	require.NoError(t, err)
+	require.ErrorIs(t, q.Verify(otherNonce), ErrInvalidQuote)
+
+	// the log doesn't match the quoted pcr
+	q.Log.Entries = q.Log.Entries[:1]
+	q.Log.PCR = q.Log.Replay()
+	require.ErrorIs(t, q.Verify(nonce), ErrInvalidQuote)
+}
@@ -1 +1,256 @@
 package attestation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/cache"
+)
+
+// DefaultNonceLifetime is the time in which the prover must answer the nonce.
+const DefaultNonceLifetime = time.Minute
+
+var ErrReferenceNotFound = errors.New("reference values not found")
+
+// Status is the appraisal of the evidence.
+type Status int
+
+const (
+	// StatusAffirming means the evidence matches the reference values.
+	StatusAffirming Status = iota
+	// StatusWarning means the evidence is valid, but it doesn't fully match the reference values.
+	StatusWarning
+	// StatusContraindicated means the prover must not be trusted.
+	StatusContraindicated
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusAffirming:
+		return "affirming"
+	case StatusWarning:
+		return "warning"
+	case StatusContraindicated:
+		return "contraindicated"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	for _, v := range []Status{StatusAffirming, StatusWarning, StatusContraindicated} {
+		if v.String() == string(text) {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid status %q", text)
+}
+
+// AttestationResult is the outcome of the verification.
+type AttestationResult struct {
+	Status  Status   `json:"status"`
+	Reasons []string `json:"reasons,omitempty"`
+	Device  Device   `json:"device"`
+	// UEID is the universal entity ID claimed by EAT evidence.
+	UEID []byte          `json:"ueid,omitempty"`
+	Log  *MeasurementLog `json:"log,omitempty"`
+	Time time.Time       `json:"time"`
+	// Expires is set when the result is presented in a passport.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (r *AttestationResult) raise(status Status, format string, args ...interface{}) {
+	if status > r.Status {
+		r.Status = status
+	}
+	r.Reasons = append(r.Reasons, fmt.Sprintf(format, args...))
+}
+
+// Verifier appraises the evidence for the nonces which it issued.
+type Verifier struct {
+	references    ReferenceStore
+	nonceLifetime time.Duration
+	nonces        *cache.Cache[string, struct{}]
+}
+
+// NewVerifier creates the verifier. Non-positive nonceLifetime is replaced by DefaultNonceLifetime.
+func NewVerifier(references ReferenceStore, nonceLifetime time.Duration) *Verifier {
+	if nonceLifetime <= 0 {
+		nonceLifetime = DefaultNonceLifetime
+	}
+	return &Verifier{
+		references:    references,
+		nonceLifetime: nonceLifetime,
+		nonces:        cache.NewCache[string, struct{}](),
+	}
+}
+
+// NewNonce issues a fresh nonce, which is accepted once within the nonce lifetime.
+func (v *Verifier) NewNonce() ([]byte, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	v.nonces.Store(string(nonce), cache.NewElement(struct{}{}, time.Now().Add(v.nonceLifetime), nil))
+	return nonce, nil
+}
+
+// CheckExpirations removes the expired nonces.
+func (v *Verifier) CheckExpirations(now time.Time) {
+	v.nonces.CheckExpirations(now)
+}
+
+func (v *Verifier) consumeNonce(nonce []byte) bool {
+	e, ok := v.nonces.LoadAndDelete(string(nonce))
+	return ok && !e.IsExpired(time.Now())
+}
+
+// Verify appraises the evidence of the device. The nonce of the evidence must be issued by NewNonce, and it
+// is consumed. When bindingKey is set, the evidence must be bound to the session.
+func (v *Verifier) Verify(device Device, evidence *Evidence, bindingKey []byte) *AttestationResult {
+	if !v.consumeNonce(evidence.Nonce) {
+		result := newAttestationResult(device)
+		result.raise(StatusContraindicated, "nonce was not issued, expired or was already used")
+		return result
+	}
+	return v.appraise(device, evidence, bindingKey)
+}
+
+// VerifyNonce appraises the evidence for the nonce which the caller derived instead of NewNonce,
+// e.g. from the transcript of the handshake.
+func (v *Verifier) VerifyNonce(device Device, evidence *Evidence, bindingKey, nonce []byte) *AttestationResult {
+	if !bytes.Equal(evidence.Nonce, nonce) {
+		result := newAttestationResult(device)
+		result.raise(StatusContraindicated, "nonce mismatch")
+		return result
+	}
+	return v.appraise(device, evidence, bindingKey)
+}
+
+func newAttestationResult(device Device) *AttestationResult {
+	return &AttestationResult{
+		Status: StatusAffirming,
+		Device: device,
+		Time:   time.Now(),
+	}
+}
+
+func (v *Verifier) appraise(device Device, evidence *Evidence, bindingKey []byte) *AttestationResult {
+	result := newAttestationResult(device)
+	if bindingKey != nil {
+		if err := evidence.Verify(bindingKey, evidence.Nonce); err != nil {
+			result.raise(StatusContraindicated, "%v", err)
+			return result
+		}
+	}
+	reference, err := v.references.Lookup(device)
+	if err != nil {
+		result.raise(StatusContraindicated, "%v", err)
+		return result
+	}
+	var log *MeasurementLog
+	if evidence.IsToken() {
+		log = appraiseToken(result, reference, evidence)
+	} else {
+		log = appraiseQuote(result, reference, evidence)
+	}
+	if result.Status == StatusContraindicated {
+		return result
+	}
+	result.Log = log
+	if reference.acceptsPCR(log.PCR) {
+		return result
+	}
+	if len(reference.Entries) == 0 {
+		result.raise(StatusContraindicated, "pcr %x doesn't match the reference values", log.PCR)
+		return result
+	}
+	compareEntries(result, reference.Entries, log.Entries)
+	return result
+}
+
+func appraiseQuote(result *AttestationResult, reference *Reference, evidence *Evidence) *MeasurementLog {
+	q, err := ParseQuote(evidence.Measurement)
+	if err != nil {
+		result.raise(StatusContraindicated, "%v", err)
+		return nil
+	}
+	if err = q.Verify(evidence.Nonce); err != nil {
+		result.raise(StatusContraindicated, "%v", err)
+		return nil
+	}
+	switch {
+	case q.Signature == nil && reference.AllowUntrustedQuotes:
+		result.raise(StatusWarning, "quote is not signed")
+	case q.Signature == nil:
+		result.raise(StatusContraindicated, "quote is not signed")
+		return nil
+	case !reference.trustsKey(q.PublicKey):
+		result.raise(StatusContraindicated, "attestation key is not trusted")
+		return nil
+	}
+	return q.Log
+}
+
+// appraiseToken verifies the EAT by the attestation keys of the reference, because the token doesn't carry its key.
+func appraiseToken(result *AttestationResult, reference *Reference, evidence *Evidence) *MeasurementLog {
+	t, err := ParseToken(evidence.Measurement)
+	if err != nil {
+		result.raise(StatusContraindicated, "%v", err)
+		return nil
+	}
+	if !bytes.Equal(t.Claims.Nonce, evidence.Nonce) {
+		result.raise(StatusContraindicated, "%v: nonce mismatch", ErrInvalidToken)
+		return nil
+	}
+	trusted := false
+	for _, key := range reference.AttestationKeys {
+		if t.Verify(key) == nil {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		result.raise(StatusContraindicated, "token is not signed by a trusted attestation key")
+		return nil
+	}
+	result.UEID = t.Claims.UEID
+	log, err := t.Claims.Log()
+	if err != nil {
+		result.raise(StatusContraindicated, "%v", err)
+		return nil
+	}
+	return log
+}
+
+func compareEntries(result *AttestationResult, references []ReferenceEntry, entries []Entry) {
+	measured := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		measured[e.Type+":"+e.Name] = true
+		found := false
+		for _, r := range references {
+			if r.Type != e.Type || r.Name != e.Name {
+				continue
+			}
+			found = true
+			if !bytes.Equal(r.Digest, e.Digest) {
+				result.raise(StatusContraindicated, "%v %v has unexpected digest %x", e.Type, e.Name, e.Digest)
+			}
+			break
+		}
+		if !found {
+			result.raise(StatusWarning, "%v %v has no reference value", e.Type, e.Name)
+		}
+	}
+	for _, r := range references {
+		if !measured[r.Type+":"+r.Name] {
+			result.raise(StatusWarning, "%v %v was not measured", r.Type, r.Name)
+		}
+	}
+}
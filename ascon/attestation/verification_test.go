@@ -0,0 +1,137 @@
+package attestation
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(config, []byte("config"), 0o600))
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	bindingKey, err := BindingKey([]byte("session key"))
+	require.NoError(t, err)
+
+	measurer := &Measurer{Paths: []string{config}, Config: []byte("runtime")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	device := Device{Class: "sensor", FirmwareVersion: "1.0"}
+	entries := func(digest []byte) []ReferenceEntry {
+		return []ReferenceEntry{
+			{Type: EntryFile, Name: config, Digest: digest},
+			{Type: EntryConfig, Name: EntryConfig, Digest: golden.Entries[1].Digest},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		reference  Reference
+		unsigned   bool
+		reuseNonce bool
+		wantStatus Status
+	}{
+		{
+			name:       "pcr",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{golden.PCR}},
+			wantStatus: StatusAffirming,
+		},
+		{
+			name:       "entries",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, Entries: entries(golden.Entries[0].Digest)},
+			wantStatus: StatusAffirming,
+		},
+		{
+			name:       "unsigned",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{golden.PCR}},
+			unsigned:   true,
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "unsigned-allowed",
+			reference:  Reference{Device: device, AllowUntrustedQuotes: true, PCRs: []Digest{golden.PCR}},
+			unsigned:   true,
+			wantStatus: StatusWarning,
+		},
+		{
+			name:       "self-signed",
+			reference:  Reference{Device: device, PCRs: []Digest{golden.PCR}},
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "self-signed-allowed",
+			reference:  Reference{Device: device, AllowUntrustedQuotes: true, PCRs: []Digest{golden.PCR}},
+			wantStatus: StatusAffirming,
+		},
+		{
+			name:       "unknown-entry",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, Entries: entries(golden.Entries[0].Digest)[1:]},
+			wantStatus: StatusWarning,
+		},
+		{
+			name:       "unexpected-digest",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, Entries: entries([]byte{1, 2, 3})},
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "unexpected-pcr",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{make([]byte, 32)}},
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "untrusted-key",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{{1}}, PCRs: []Digest{golden.PCR}},
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "no-reference",
+			reference:  Reference{Device: Device{Class: "gateway"}, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{golden.PCR}},
+			wantStatus: StatusContraindicated,
+		},
+		{
+			name:       "replayed-nonce",
+			reference:  Reference{Device: device, AttestationKeys: [][]byte{publicKey}, PCRs: []Digest{golden.PCR}},
+			reuseNonce: true,
+			wantStatus: StatusContraindicated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			references, err := ParseReferenceValues([]byte(`{"references":[]}`))
+			require.NoError(t, err)
+			references.Add(tt.reference)
+			data, err := references.Marshal()
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), "references.json")
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+			references, err = LoadReferenceValues(path)
+			require.NoError(t, err)
+
+			v := NewVerifier(references, 0)
+			nonce, err := v.NewNonce()
+			require.NoError(t, err)
+			attester := &SoftwareAttester{Measurer: measurer, Key: key}
+			if tt.unsigned {
+				attester.Key = nil
+			}
+			quote, err := attester.Quote(nonce)
+			require.NoError(t, err)
+			evidence := NewEvidence(bindingKey, nonce, quote)
+			if tt.reuseNonce {
+				require.Equal(t, StatusAffirming, v.Verify(device, &evidence, bindingKey).Status)
+			}
+			result := v.Verify(device, &evidence, bindingKey)
+			require.Equal(t, tt.wantStatus, result.Status, result.Reasons)
+			if tt.wantStatus != StatusAffirming {
+				require.NotEmpty(t, result.Reasons)
+			}
+		})
+	}
+}
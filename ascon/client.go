@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
 	"net"
 	"time"
 
@@ -39,11 +38,11 @@ func Dial(target string, opts ...ClientOption) (*connection.Conn, error) {
 		return nil, fmt.Errorf("unsupported connection type: %T", c)
 	}
 	opts = append(opts, options.WithCloseSocket())
-	return client(conn, opts...), nil
+	return client(conn, opts...)
 }
 
 // Client creates client over ascon connection.
-func client(conn *net.UDPConn, opts ...ClientOption) *connection.Conn {
+func client(conn *net.UDPConn, opts ...ClientOption) (*connection.Conn, error) {
 	cfg := connection.DefaultClientConfig
 	for _, o := range opts {
 		o.ASCONClientApply(&cfg)
@@ -70,6 +69,10 @@ func client(conn *net.UDPConn, opts ...ClientOption) *connection.Conn {
 		}
 		errorsFunc(fmt.Errorf("ascon: %v: %w", conn.RemoteAddr(), err))
 	}
+	if cfg.HybridKeyExchange && cfg.BlockwiseEnable {
+		// the hybrid key shares exceed one block, so they have to be split into blocks which fit into the MTU
+		cfg.BlockwiseSZX = fitSZXToMTU(cfg.BlockwiseSZX, cfg.MTU)
+	}
 	addr, _ := conn.RemoteAddr().(*net.UDPAddr)
 	createBlockWise := func(cc *connection.Conn) *blockwise.BlockWise[*connection.Conn] {
 		return nil
@@ -119,20 +122,37 @@ func client(conn *net.UDPConn, opts ...ClientOption) *connection.Conn {
 		}
 	}()
 
-	err := handshake(cc)
+	err := handshake(cc, cfg.HybridKeyExchange)
 	if err != nil {
-		log.Fatalf("Could not handshake %s", err)
+		_ = cc.Close()
+		return nil, fmt.Errorf("cannot handshake: %w", err)
 	}
 
-	return cc
+	return cc, nil
 }
 
-func handshake(cc *connection.Conn) error {
-	fmt.Println("\nClient Handshake")
+// fitSZXToMTU returns the largest block size up to szx whose datagrams fit into the MTU.
+func fitSZXToMTU(szx blockwise.SZX, mtu uint16) blockwise.SZX {
+	// headroom for the CoAP header, token, block and size options, and the ASCON tag and nonce
+	const headroom = 64 + coder.Overhead
+	for szx > blockwise.SZX16 && szx.Size()+headroom > int64(mtu) {
+		szx--
+	}
+	return szx
+}
 
-	// return nil
-	clientPrivateKey := coder.RandomBytes(32)
-	clientPublicKey := coder.ComputePublicKey(clientPrivateKey)
+func handshake(cc *connection.Conn, hybrid bool) error {
+	newKeyShare := coder.NewX25519KeyShare
+	expectedCode := codes.Empty
+	if hybrid {
+		newKeyShare = coder.NewHybridKeyShare
+		expectedCode = codes.Content
+	}
+	keyShare, err := newKeyShare()
+	if err != nil {
+		return fmt.Errorf("cannot create key share: %w", err)
+	}
+	defer keyShare.Wipe()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -146,7 +166,7 @@ func handshake(cc *connection.Conn) error {
 
 	request.SetCode(codes.HANDSHAKE)
 	request.SetToken(token)
-	request.SetBody(bytes.NewReader(clientPublicKey))
+	request.SetBody(bytes.NewReader(keyShare.Public()))
 
 	defer cc.ReleaseMessage(request)
 
@@ -155,29 +175,30 @@ func handshake(cc *connection.Conn) error {
 		return fmt.Errorf("cannot send client hello: %w", err)
 	}
 
-	serverPublicKey, err := response.ReadBody()
-	if err != nil {
-		return fmt.Errorf("cannot read server hello %w", err)
+	defer cc.ReleaseMessage(response)
+	if response.Code() != expectedCode {
+		return fmt.Errorf("server rejected client hello: %v", response.Code())
 	}
 
-	if len(serverPublicKey) != 32 {
-		return fmt.Errorf("invalid server hello")
+	serverShare, err := response.ReadBody()
+	if err != nil {
+		return fmt.Errorf("cannot read server hello %w", err)
 	}
 
-	sharedKey, err := coder.DeriveSharedKey(clientPrivateKey, serverPublicKey)
+	sessionKey, err := keyShare.SessionKey(serverShare)
 	if err != nil {
-		return fmt.Errorf("Could not compute shared key: %w\n", err)
+		return fmt.Errorf("invalid server hello: %w", err)
 	}
 
-	fmt.Printf("Client public: %X\n", clientPublicKey)
-	fmt.Printf("Server public: %X\n", serverPublicKey)
-	fmt.Printf("Shared key: %X\n", sharedKey)
+	cc.Logger().Debugf("%v: handshake: client public %X, server public %X", cc.RemoteAddr(), keyShare.Public()[:coder.X25519ShareSize], serverShare[:coder.X25519ShareSize])
 
 	// save shared secret
-	cc.SetClientSecret(sharedKey[:16])
+	cc.SetClientSecret(coder.Transcript(keyShare.Public(), serverShare), sessionKey)
 
-	//send client ack (non-confirmable)
+	if err = cc.Authenticate(); err != nil {
+		return fmt.Errorf("cannot authenticate: %w", err)
+	}
 
-	fmt.Println("Finish Handshake")
+	cc.Logger().Debugf("%v: handshake: finished", cc.RemoteAddr())
 	return nil
 }
@@ -0,0 +1,717 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keylog"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/sessionstore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/blockwise"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnHandshake(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverOptions []ServerOption
+		clientOptions []ClientOption
+		hybrid        bool
+		wantErr       bool
+	}{
+		{
+			name: "x25519",
+		},
+		{
+			name:          "hybrid-blockwise",
+			serverOptions: []ServerOption{options.WithHybridKeyExchange()},
+			clientOptions: []ClientOption{options.WithHybridKeyExchange()},
+			hybrid:        true,
+		},
+		{
+			name:          "hybrid-small-mtu",
+			serverOptions: []ServerOption{options.WithHybridKeyExchange()},
+			clientOptions: []ClientOption{options.WithHybridKeyExchange(), options.WithMTU(512)},
+			hybrid:        true,
+		},
+		{
+			name:          "hybrid-single-datagram",
+			serverOptions: []ServerOption{options.WithHybridKeyExchange()},
+			clientOptions: []ClientOption{
+				options.WithHybridKeyExchange(),
+				options.WithBlockwise(false, blockwise.SZX1024, time.Second),
+			},
+			hybrid: true,
+		},
+		{
+			name:          "hybrid-required",
+			serverOptions: []ServerOption{options.WithHybridKeyExchange()},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.hybrid {
+				if _, err := coder.NewHybridKeyShare(); errors.Is(err, coder.ErrHybridUnsupported) {
+					t.Skip(err)
+				}
+			}
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+
+			s := NewServer(append(tt.serverOptions, options.WithMux(m))...)
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.LocalAddr().String(), tt.clientOptions...)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+
+			// several requests, so both directions are verified to be encrypted with the session key
+			for i := 0; i < 3; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+				resp, err := cc.Get(ctx, "/a")
+				cancel()
+				require.NoError(t, err)
+				require.Equal(t, codes.Content, resp.Code())
+				body, err := resp.ReadBody()
+				require.NoError(t, err)
+				require.Equal(t, []byte("a"), body)
+			}
+
+			// a plaintext client hello from the address of the client doesn't reset the session
+			conns := s.getConns()
+			require.Len(t, conns, 1)
+			transcript := conns[0].Transcript()
+			keyShare, err := coder.NewX25519KeyShare()
+			require.NoError(t, err)
+			defer keyShare.Wipe()
+			hello := make([]byte, 128)
+			n, err := coder.DefaultCoder.Encode(message.Message{
+				Code:      codes.HANDSHAKE,
+				Type:      message.Confirmable,
+				MessageID: 1,
+				Token:     []byte{1},
+				Payload:   keyShare.Public(),
+			}, hello)
+			require.NoError(t, err)
+			_, err = cc.NetConn().Write(hello[:n])
+			require.NoError(t, err)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code())
+			require.Equal(t, transcript, conns[0].Transcript())
+		})
+	}
+}
+
+func TestConnAuthentication(t *testing.T) {
+	clientKey, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	serverKey, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	newKeystore := func(identityKey ed25519.PrivateKey, psk []byte, anchors ...keystore.TrustAnchor) keystore.Keystore {
+		ks := keystore.NewMemoryStore()
+		if identityKey != nil {
+			require.NoError(t, ks.SetIdentityKey(identityKey))
+		}
+		if psk != nil {
+			require.NoError(t, ks.SetPSK("device", psk))
+		}
+		for _, a := range anchors {
+			require.NoError(t, ks.AddTrustAnchor(a))
+		}
+		return ks
+	}
+	clientAnchor := keystore.TrustAnchor{Name: "client", PublicKey: clientKey.Public().(ed25519.PublicKey)}
+	serverAnchor := keystore.TrustAnchor{Name: "server", PublicKey: serverKey.Public().(ed25519.PublicKey)}
+
+	tests := []struct {
+		name          string
+		serverOptions []ServerOption
+		clientOptions []ClientOption
+		wantErr       bool
+		wantCode      codes.Code
+		wantPeer      connection.PeerIdentity
+	}{
+		{
+			name: "psk",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+				options.WithPeerAuthentication(),
+			},
+			clientOptions: []ClientOption{
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+				options.WithPSKIdentity("device"),
+				options.WithPeerAuthentication(),
+			},
+			wantCode: codes.Content,
+			wantPeer: connection.PeerIdentity{PSKIdentity: "device"},
+		},
+		{
+			name: "identity-keys",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(serverKey, nil, clientAnchor)),
+				options.WithPeerAuthentication(),
+			},
+			clientOptions: []ClientOption{
+				options.WithKeystore(newKeystore(clientKey, nil, serverAnchor)),
+				options.WithPeerAuthentication(),
+			},
+			wantCode: codes.Content,
+			wantPeer: connection.PeerIdentity{PublicKey: serverAnchor.PublicKey, TrustAnchor: "server"},
+		},
+		{
+			name: "invalid-psk",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+			},
+			clientOptions: []ClientOption{
+				options.WithKeystore(newKeystore(nil, []byte("guess"))),
+				options.WithPSKIdentity("device"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "untrusted-identity-key",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(serverKey, nil)),
+				options.WithPeerAuthentication(),
+			},
+			clientOptions: []ClientOption{
+				options.WithKeystore(newKeystore(clientKey, nil, serverAnchor)),
+			},
+			wantErr: true,
+		},
+		{
+			name: "untrusted-server",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(serverKey, nil)),
+			},
+			clientOptions: []ClientOption{
+				options.WithKeystore(newKeystore(clientKey, nil)),
+				options.WithPeerAuthentication(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unauthenticated-request",
+			serverOptions: []ServerOption{
+				options.WithKeystore(newKeystore(serverKey, nil, clientAnchor)),
+				options.WithPeerAuthentication(),
+			},
+			wantCode: codes.Unauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+
+			s := NewServer(append(tt.serverOptions, options.WithMux(m))...)
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.LocalAddr().String(), tt.clientOptions...)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+			require.Equal(t, tt.wantPeer, cc.PeerIdentity())
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, resp.Code())
+
+			// the request codes which the library doesn't know are gated as well, e.g. iPATCH
+			req := cc.AcquireMessage(ctx)
+			defer cc.ReleaseMessage(req)
+			token, err := cc.GetToken()
+			require.NoError(t, err)
+			req.SetCode(codes.Code(7))
+			req.SetToken(token)
+			require.NoError(t, req.SetPath("/a"))
+			resp, err = cc.Do(req)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, resp.Code())
+		})
+	}
+}
+
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) entries(t *testing.T) []keylog.Entry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	entries, err := keylog.Parse(bytes.NewReader(b.buf.Bytes()))
+	require.NoError(t, err)
+	return entries
+}
+
+func TestConnKeyLog(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+
+	var serverKeyLog, clientKeyLog syncBuffer
+	s := NewServer(options.WithKeyLogWriter(&serverKeyLog))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	cc, err := Dial(l.LocalAddr().String(), options.WithKeyLogWriter(&clientKeyLog))
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+	}()
+
+	entries := clientKeyLog.entries(t)
+	require.Len(t, entries, 1)
+	require.Equal(t, cc.Transcript(), entries[0].SessionID)
+	require.Len(t, entries[0].Key, coder.KeyBytes)
+	require.Equal(t, entries, serverKeyLog.entries(t))
+}
+
+func TestServerResumesSession(t *testing.T) {
+	store := sessionstore.NewMemoryStore()
+	masterKey := coder.RandomBytes(coder.KeyBytes)
+	m := mux.NewRouter()
+	err := m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+
+	serve := func(addr string) func() {
+		l, errL := coapNet.NewListenUDP("udp", addr)
+		require.NoError(t, errL)
+		s := NewServer(options.WithMux(m), options.WithSessionStore(store, masterKey))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errS := s.Serve(l)
+			require.NoError(t, errS)
+		}()
+		return func() {
+			s.Stop()
+			wg.Wait()
+			errC := l.Close()
+			require.NoError(t, errC)
+		}
+	}
+	get := func(cc *connection.Conn) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		resp, errG := cc.Get(ctx, "/a")
+		require.NoError(t, errG)
+		require.Equal(t, codes.Content, resp.Code())
+	}
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	addr := l.LocalAddr().String()
+	require.NoError(t, l.Close())
+
+	stop := serve(addr)
+	cc, err := Dial(addr)
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+	}()
+	get(cc)
+	stop()
+
+	// the restarted server decrypts the requests of the session without a new handshake
+	stop = serve(addr)
+	defer stop()
+	get(cc)
+}
+
+func TestConnAttest(t *testing.T) {
+	measure := func(measurement []byte) attestation.MeasurementFunc {
+		return func() ([]byte, error) {
+			return measurement, nil
+		}
+	}
+	large := bytes.Repeat([]byte("m"), 4096)
+	tests := []struct {
+		name            string
+		serverOptions   []ServerOption
+		wantMeasurement []byte
+		wantQuote       bool
+		wantToken       bool
+		wantErr         error
+	}{
+		{
+			name:            "proof",
+			serverOptions:   []ServerOption{options.WithMeasurement(measure([]byte("measurement")))},
+			wantMeasurement: []byte("measurement"),
+		},
+		{
+			name:            "blockwise-proof",
+			serverOptions:   []ServerOption{options.WithMeasurement(measure(large))},
+			wantMeasurement: large,
+		},
+		{
+			name: "software-attester",
+			serverOptions: []ServerOption{options.WithAttester(&attestation.SoftwareAttester{
+				Measurer: &attestation.Measurer{Binary: true, Config: []byte("config")},
+				Key:      ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)),
+			})},
+			wantQuote: true,
+		},
+		{
+			name: "eat",
+			serverOptions: []ServerOption{options.WithAttester(&attestation.EATAttester{
+				Measurer: &attestation.Measurer{Config: []byte("config")},
+				Key:      ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)),
+				CWT:      true,
+			})},
+			wantToken: true,
+		},
+		{
+			name:    "no-measurement",
+			wantErr: attestation.ErrProofNotFound,
+		},
+		{
+			name: "measurement-failed",
+			serverOptions: []ServerOption{options.WithMeasurement(func() ([]byte, error) {
+				return nil, errors.New("failed")
+			})},
+			wantErr: attestation.ErrProofNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			s := NewServer(tt.serverOptions...)
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.LocalAddr().String())
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			evidence, err := cc.Attest(ctx)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantToken {
+				require.Equal(t, message.AppCWT, evidence.Format)
+				token, errT := attestation.ParseToken(evidence.Measurement)
+				require.NoError(t, errT)
+				require.Equal(t, evidence.Nonce, token.Claims.Nonce)
+				return
+			}
+			if tt.wantQuote {
+				q, errQ := attestation.ParseQuote(evidence.Measurement)
+				require.NoError(t, errQ)
+				require.NoError(t, q.Verify(evidence.Nonce))
+				return
+			}
+			require.Equal(t, tt.wantMeasurement, evidence.Measurement)
+		})
+	}
+}
+
+func TestConnHandshakeAttestation(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	references := &attestation.ReferenceValues{}
+	references.Add(attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}})
+
+	tests := []struct {
+		name          string
+		clientOptions []ClientOption
+		wantErr       bool
+		wantCode      codes.Code
+	}{
+		{
+			name: "quote",
+			clientOptions: []ClientOption{
+				options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key}),
+				options.WithAttestInHandshake(),
+			},
+			wantCode: codes.Content,
+		},
+		{
+			name: "eat",
+			clientOptions: []ClientOption{
+				options.WithAttester(&attestation.EATAttester{Measurer: measurer, Key: key}),
+				options.WithAttestInHandshake(),
+			},
+			wantCode: codes.Content,
+		},
+		{
+			name: "compromised",
+			clientOptions: []ClientOption{
+				options.WithAttester(&attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte("compromised")}, Key: key}),
+				options.WithAttestInHandshake(),
+			},
+			wantErr: true,
+		},
+		{
+			name:          "evidence-after-handshake",
+			clientOptions: []ClientOption{options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key})},
+			wantCode:      codes.Unauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				cc, ok := w.Conn().(*connection.Conn)
+				require.True(t, ok)
+				require.Equal(t, attestation.StatusAffirming, cc.AttestationResult().Status)
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+
+			s := NewServer(options.WithMux(m), options.WithHandshakeAttestation(connection.HandshakeAttestation{
+				Verifier: attestation.NewVerifier(references, 0),
+			}))
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.LocalAddr().String(), tt.clientOptions...)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, resp.Code())
+		})
+	}
+}
+
+func TestConnServerAttestation(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("gateway 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	references := &attestation.ReferenceValues{}
+	references.Add(attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}})
+	verifier := attestation.NewVerifier(references, 0)
+
+	tests := []struct {
+		name          string
+		serverOptions []ServerOption
+		clientOptions []ClientOption
+		wantErr       bool
+	}{
+		{
+			name:          "quote",
+			serverOptions: []ServerOption{options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key})},
+		},
+		{
+			name:          "eat",
+			serverOptions: []ServerOption{options.WithAttester(&attestation.EATAttester{Measurer: measurer, Key: key})},
+		},
+		{
+			name: "mutual",
+			serverOptions: []ServerOption{
+				options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key}),
+				options.WithHandshakeAttestation(connection.HandshakeAttestation{Verifier: verifier}),
+			},
+			clientOptions: []ClientOption{
+				options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key}),
+				options.WithAttestInHandshake(),
+			},
+		},
+		{
+			name:          "compromised",
+			serverOptions: []ServerOption{options.WithAttester(&attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte("compromised")}, Key: key})},
+			wantErr:       true,
+		},
+		{
+			name:    "no-attester",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+
+			s := NewServer(append([]ServerOption{options.WithMux(m)}, tt.serverOptions...)...)
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			clientOptions := append([]ClientOption{options.WithHandshakeAttestation(connection.HandshakeAttestation{Verifier: verifier})}, tt.clientOptions...)
+			cc, err := Dial(l.LocalAddr().String(), clientOptions...)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+			require.Equal(t, attestation.StatusAffirming, cc.AttestationResult().Status)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code())
+		})
+	}
+}
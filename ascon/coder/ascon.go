@@ -136,7 +136,7 @@ func (ascon *Ascon) processPlaintext(plaintext []byte) []byte {
 	l := textLen % BlockBytes
 	lastByte := textLen
 
-	if l > 0 {
+	if l > 0 || textLen == 0 {
 		// do padding P||1||0 r-1(|P| % r)
 		tmp := make([]byte, BlockBytes-l)
 		tmp[0] = 0x80
@@ -175,7 +175,7 @@ func (ascon *Ascon) processCyphertext(cyphertext []byte) []byte {
 	l := textLen % BlockBytes
 	lastByte := textLen
 
-	if l > 0 {
+	if l > 0 || textLen == 0 {
 		lastByte -= l
 	} else {
 		lastByte -= BlockBytes
@@ -196,7 +196,7 @@ func (ascon *Ascon) processCyphertext(cyphertext []byte) []byte {
 		ascon.permutation(ascon.b)
 	}
 
-	if l == 0 {
+	if l == 0 && textLen > 0 {
 
 		cyphertextBlock := *(*block)(cyphertext[lastByte:])
 
@@ -264,5 +264,10 @@ func Decrypt(key []byte, nonce []byte, cyphertext []byte, tag []byte) []byte {
 		return nil
 	}
 
+	if plaintext == nil {
+		// the empty plaintext is not the nil of the tag mismatch
+		plaintext = []byte{}
+	}
+
 	return plaintext
 }
@@ -5,33 +5,131 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
 )
 
+const (
+	TagBytes   = 16
+	NonceBytes = 16
+	// Overhead is the number of bytes appended to an encrypted message: tag 16 + nonce 16.
+	Overhead = TagBytes + NonceBytes
+)
+
+// Coder encodes and decodes messages of one ASCON session.
+//
+// Until a key is set, messages are passed in plaintext. During the handshake the server
+// keeps the derived key pending and activates it once the peer sends the first message
+// which authenticates under that key. Once the coder holds any key, the messages which don't
+// authenticate are refused; only the handshake of the pending key passes in plaintext, and only
+// while no session key is active.
 type Coder struct {
-	secret []byte
+	mutex     sync.Mutex
+	secret    *SecretKey
+	pending   *SecretKey
+	activated func()
+	closed    bool
 }
 
+// DefaultCoder encodes and decodes plaintext messages. It is never keyed.
 var DefaultCoder = new(Coder)
 
-// func MakeDefaultCoder(isSecretReady bool, secret []byte) *Coder {
-// 	cdr := DefaultCoder
-// 	if isSecretReady {
-// 		DefaultCoder.SetSecret(secret)
-// 	}
-// 	return cdr
-// }
+// NewCoder creates a coder for a new session.
+func NewCoder() *Coder {
+	return new(Coder)
+}
 
-func (c *Coder) SetSecret(secret []byte) *Coder {
-	fmt.Printf("Set Coder secret: %x\n", secret)
-	c.secret = secret
+// SetSecret activates the session key, all following messages are encrypted.
+// The coder takes ownership of the key and wipes the keys of the previous handshake.
+func (c *Coder) SetSecret(secret *SecretKey) *Coder {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(secret, nil)
+	c.activated = nil
 	return c
 }
 
+// SetPendingSecret stores the key of a new handshake and replaces the key of the previous pending one.
+// The current session key stays active until the peer proves possession of the pending key, then
+// the pending key replaces it and activated is called. Without a session key, messages are sent in plaintext
+// until then.
+func (c *Coder) SetPendingSecret(secret *SecretKey, activated func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(c.secret, secret)
+	c.activated = activated
+}
+
+// Close wipes the session keys. Afterwards the coder refuses to encode and decode messages,
+// so nothing falls back to plaintext.
+func (c *Coder) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(nil, nil)
+	c.activated = nil
+	c.closed = true
+}
+
+func (c *Coder) replaceKeys(secret, pending *SecretKey) {
+	if c.secret != secret && c.secret != pending {
+		c.secret.Wipe()
+	}
+	if c.pending != secret && c.pending != pending {
+		c.pending.Wipe()
+	}
+	c.secret = secret
+	c.pending = pending
+}
+
+// IsEstablished reports whether the session key is active.
+func (c *Coder) IsEstablished() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.secret != nil
+}
+
+// SessionKey returns a copy of the session key, which the caller wipes after use. When no key is
+// active, it returns the pending key. It returns nil before the key exchange.
+func (c *Coder) SessionKey() (key []byte, established bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch {
+	case c.secret != nil:
+		return append([]byte(nil), c.secret.Bytes()...), true
+	case c.pending != nil:
+		return append([]byte(nil), c.pending.Bytes()...), false
+	}
+	return nil, false
+}
+
+// getSecret returns a copy of the session key, which the caller wipes after use.
+func (c *Coder) getSecret() ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, ErrCoderClosed
+	}
+	if c.secret == nil {
+		return nil, nil
+	}
+	return append([]byte(nil), c.secret.Bytes()...), nil
+}
+
 // size in bytes
 func (c *Coder) Size(m message.Message) (int, error) {
+	size, err := c.plaintextSize(m)
+	if err != nil {
+		return -1, err
+	}
+	if c.IsEstablished() {
+		size += Overhead
+	}
+	return size, nil
+}
+
+func (c *Coder) plaintextSize(m message.Message) (int, error) {
 	if len(m.Token) > message.MaxTokenSize {
 		return -1, message.ErrInvalidTokenLen
 	}
@@ -69,10 +167,18 @@ func (c *Coder) Encode(m message.Message, buf []byte) (int, error) {
 	if !message.ValidateType(m.Type) {
 		return -1, fmt.Errorf("invalid Type(%v)", m.Type)
 	}
-	size, err := c.Size(m)
+	secret, err := c.getSecret()
 	if err != nil {
 		return -1, err
 	}
+	defer Wipe(secret)
+	size, err := c.plaintextSize(m)
+	if err != nil {
+		return -1, err
+	}
+	if secret != nil && len(buf) < size+Overhead {
+		return size + Overhead, message.ErrTooSmall
+	}
 	if len(buf) < size {
 		return size, message.ErrTooSmall
 	}
@@ -110,19 +216,12 @@ func (c *Coder) Encode(m message.Message, buf []byte) (int, error) {
 
 	copy(buf, m.Payload)
 
-	if c.secret != nil {
-		fmt.Println("Encrypting")
-		nonce := RandomBytes(16)
-
-		tmp, tag := Encrypt(c.secret, nonce, fullBuf[:size])
-
-		copy(fullBuf, tmp)
-
-		buf = fullBuf[:size]
-		buf = append(buf, tag...)
-		size += 16
-		buf = append(buf, nonce[:]...)
-		size += 16 //nonce 16 + tag 16
+	if secret != nil {
+		record, err := Seal(secret, fullBuf[:size])
+		if err != nil {
+			return -1, err
+		}
+		size = copy(fullBuf, record)
 	}
 
 	return size, nil
@@ -134,13 +233,11 @@ func (c *Coder) Decode(data []byte, m *message.Message) (int, error) {
 		return -1, ErrMessageTruncated
 	}
 
-	if c.secret != nil {
-		fmt.Println("Decrypting")
-		copy(data, Decrypt(c.secret, data[size-16:], data[:size-32], data[size-32:size-16]))
-
-		data = data[:size-32] // local
-		size -= 32            //nonce 16 + tag 16
+	data, err := c.open(data)
+	if err != nil {
+		return -1, err
 	}
+	size = len(data)
 
 	if data[0]>>6 != 1 {
 		return -1, ErrMessageInvalidVersion
@@ -183,6 +280,61 @@ func (c *Coder) Decode(data []byte, m *message.Message) (int, error) {
 	return size, nil
 }
 
+// open authenticates and decrypts data in place and returns the plaintext.
+func (c *Coder) open(data []byte) ([]byte, error) {
+	plaintext, activated, err := c.openLocked(data)
+	if activated != nil {
+		activated()
+	}
+	return plaintext, err
+}
+
+// openLocked returns the callback of the pending key when the data activated it.
+func (c *Coder) openLocked(data []byte) ([]byte, func(), error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, nil, ErrCoderClosed
+	}
+	if c.secret != nil {
+		if plaintext, ok := unseal(c.secret.Bytes(), data); ok {
+			return plaintext, nil, nil
+		}
+	}
+	if c.pending != nil {
+		if plaintext, ok := unseal(c.pending.Bytes(), data); ok {
+			activated := c.activated
+			c.replaceKeys(c.pending, nil)
+			c.activated = nil
+			return plaintext, activated, nil
+		}
+	}
+	switch {
+	case c.secret != nil:
+		return nil, nil, ErrMessageAuthentication
+	case c.pending != nil && !isHandshake(data):
+		// only the retransmitted client hello and the blocks of the server hello precede the pending key
+		return nil, nil, ErrMessageAuthentication
+	}
+	return data, nil, nil
+}
+
+// unseal opens the record of a message in place.
+func unseal(secret []byte, data []byte) ([]byte, bool) {
+	if len(data) < 4+Overhead {
+		return nil, false
+	}
+	plaintext, ok := Open(secret, data)
+	if !ok {
+		return nil, false
+	}
+	return data[:copy(data, plaintext)], true
+}
+
+func isHandshake(data []byte) bool {
+	return data[0]>>6 == 1 && codes.Code(data[1]) == codes.HANDSHAKE
+}
+
 func RandomBytes(n int) []byte {
 	b := make([]byte, n)
 	_, err := rand.Read(b)
@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrMessageTruncated      = errors.New("message is truncated")
 	ErrMessageInvalidVersion = errors.New("message has invalid version")
+	ErrMessageAuthentication = errors.New("message authentication failed")
+	ErrCoderClosed           = errors.New("coder is closed, session keys were wiped")
 )
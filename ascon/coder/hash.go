@@ -0,0 +1,108 @@
+package coder
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// HashBytes is the size of the Ascon-Hash digest.
+const HashBytes = 32
+
+// hashState is the state of Ascon-Hash after the initialization with the IV 00400c0000000100.
+var hashState = [5]uint64{0xee9398aadb67f03d, 0x8bb21831c60f1002, 0xb48a92db98d5da62, 0x43189921b8f8e3e8, 0x348fa5c9d525e140}
+
+// asconHash is Ascon-Hash with 64-bit rate and 12 rounds of the permutation.
+type asconHash struct {
+	state [5]uint64
+	buf   [BlockBytes]byte
+	n     int
+}
+
+// NewHash returns Ascon-Hash.
+func NewHash() hash.Hash {
+	return &asconHash{state: hashState}
+}
+
+// Hash returns Ascon-Hash of the data.
+func Hash(data []byte) []byte {
+	h := NewHash()
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
+
+func (h *asconHash) Reset() {
+	*h = asconHash{state: hashState}
+}
+
+func (h *asconHash) Size() int {
+	return HashBytes
+}
+
+func (h *asconHash) BlockSize() int {
+	return BlockBytes
+}
+
+func (h *asconHash) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		c := copy(h.buf[h.n:], p)
+		h.n += c
+		p = p[c:]
+		if h.n == BlockBytes {
+			h.state[0] ^= binary.BigEndian.Uint64(h.buf[:])
+			permute12(&h.state)
+			h.n = 0
+		}
+	}
+	return n, nil
+}
+
+// Sum appends the digest to b, it doesn't change the state of the hash.
+func (h *asconHash) Sum(b []byte) []byte {
+	state := h.state
+	var last [BlockBytes]byte
+	copy(last[:], h.buf[:h.n])
+	// padding M || 1 || 0*
+	last[h.n] = 0x80
+	state[0] ^= binary.BigEndian.Uint64(last[:])
+	var out [BlockBytes]byte
+	for i := 0; i < HashBytes/BlockBytes; i++ {
+		permute12(&state)
+		binary.BigEndian.PutUint64(out[:], state[0])
+		b = append(b, out[:]...)
+	}
+	return b
+}
+
+// permute12 is the Ascon permutation p^12 on 64-bit words.
+func permute12(s *[5]uint64) {
+	for r := 0; r < 12; r++ {
+		// addition of constants
+		s[2] ^= uint64((0xf-r)<<4 | r)
+		// substitution layer
+		s[0] ^= s[4]
+		s[4] ^= s[3]
+		s[2] ^= s[1]
+		t0 := ^s[0] & s[1]
+		t1 := ^s[1] & s[2]
+		t2 := ^s[2] & s[3]
+		t3 := ^s[3] & s[4]
+		t4 := ^s[4] & s[0]
+		s[0] ^= t1
+		s[1] ^= t2
+		s[2] ^= t3
+		s[3] ^= t4
+		s[4] ^= t0
+		s[1] ^= s[0]
+		s[0] ^= s[4]
+		s[3] ^= s[2]
+		s[2] = ^s[2]
+		// linear diffusion layer
+		s[0] ^= bits.RotateLeft64(s[0], -19) ^ bits.RotateLeft64(s[0], -28)
+		s[1] ^= bits.RotateLeft64(s[1], -61) ^ bits.RotateLeft64(s[1], -39)
+		s[2] ^= bits.RotateLeft64(s[2], -1) ^ bits.RotateLeft64(s[2], -6)
+		s[3] ^= bits.RotateLeft64(s[3], -10) ^ bits.RotateLeft64(s[3], -17)
+		s[4] ^= bits.RotateLeft64(s[4], -7) ^ bits.RotateLeft64(s[4], -41)
+	}
+}
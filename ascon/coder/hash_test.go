@@ -0,0 +1,41 @@
+package coder
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHash(t *testing.T) {
+	// known answers of the reference implementation of Ascon-Hash
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{name: "empty", msg: "", want: "7346bc14f036e87ae03d0997913088f5f68411434b3cf8b54fa796a80d251f91"},
+		{name: "one-byte", msg: "00", want: "8dd446ada58a7740ecf56eb638ef775f7d5c0fd5f0c2bbbdfdec29609d3c43a2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := hex.DecodeString(tt.msg)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, hex.EncodeToString(Hash(msg)))
+		})
+	}
+
+	msg := make([]byte, 100)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	h := NewHash()
+	for _, b := range msg {
+		_, err := h.Write([]byte{b})
+		require.NoError(t, err)
+		require.Len(t, h.Sum(nil), HashBytes)
+	}
+	require.Equal(t, Hash(msg), h.Sum(nil))
+	h.Reset()
+	require.Equal(t, Hash(nil), h.Sum(nil))
+}
@@ -0,0 +1,206 @@
+package coder
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// KeyBytes is the size of the ASCON session key.
+	KeyBytes = 16
+	// X25519ShareSize is the size of an X25519 public key share.
+	X25519ShareSize = 32
+	// HybridClientShareSize is the size of a hybrid client hello: X25519 public key || ML-KEM-768 encapsulation key.
+	HybridClientShareSize = X25519ShareSize + mlkem768EncapsulationKeySize
+	// HybridServerShareSize is the size of a hybrid server hello: X25519 public key || ML-KEM-768 ciphertext.
+	HybridServerShareSize = X25519ShareSize + mlkem768CiphertextSize
+
+	mlkem768EncapsulationKeySize = 1184
+	mlkem768CiphertextSize       = 1088
+
+	hybridKeyScheduleLabel = "ascon x25519mlkem768 session key"
+)
+
+var (
+	ErrInvalidKeyShare    = errors.New("invalid key share")
+	ErrHybridUnsupported  = errors.New("hybrid key exchange requires ML-KEM support (go1.24 or newer)")
+	errInvalidServerShare = fmt.Errorf("server hello: %w", ErrInvalidKeyShare)
+)
+
+// KeyShare holds the ephemeral private part of a client hello until the server hello arrives.
+type KeyShare interface {
+	// Public returns the key share which is sent in the client hello.
+	Public() []byte
+	// SessionKey combines the key share with the server hello and derives the session key.
+	SessionKey(serverShare []byte) (*SecretKey, error)
+	// Wipe overwrites the ephemeral private keys, the key share can't derive a session key afterwards.
+	Wipe()
+}
+
+type x25519KeyShare struct {
+	private *SecretKey
+	public  []byte
+}
+
+// NewX25519KeyShare creates a classic key share which relies only on X25519.
+func NewX25519KeyShare() (KeyShare, error) {
+	private := RandomBytes(32)
+	if private == nil {
+		return nil, errors.New("cannot generate X25519 private key")
+	}
+	return &x25519KeyShare{
+		private: NewSecretKey(private),
+		public:  ComputePublicKey(private),
+	}, nil
+}
+
+func (k *x25519KeyShare) Public() []byte {
+	return k.public
+}
+
+func (k *x25519KeyShare) SessionKey(serverShare []byte) (*SecretKey, error) {
+	if len(serverShare) != X25519ShareSize {
+		return nil, errInvalidServerShare
+	}
+	sharedKey, err := DeriveSharedKey(k.private.Bytes(), serverShare)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute shared key: %w", err)
+	}
+	return classicSessionKey(sharedKey), nil
+}
+
+func (k *x25519KeyShare) Wipe() {
+	k.private.Wipe()
+}
+
+// classicSessionKey truncates the X25519 shared secret to the session key and wipes the rest.
+func classicSessionKey(sharedKey []byte) *SecretKey {
+	defer Wipe(sharedKey)
+	return NewSecretKey(append([]byte(nil), sharedKey[:KeyBytes]...))
+}
+
+// RespondX25519 computes the server hello for a classic client hello and the resulting session key.
+func RespondX25519(clientShare []byte) (serverShare []byte, sessionKey *SecretKey, err error) {
+	if len(clientShare) != X25519ShareSize {
+		return nil, nil, ErrInvalidKeyShare
+	}
+	private := RandomBytes(32)
+	if private == nil {
+		return nil, nil, errors.New("cannot generate X25519 private key")
+	}
+	defer Wipe(private)
+	sharedKey, err := DeriveSharedKey(private, clientShare)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot compute shared key: %w", err)
+	}
+	return ComputePublicKey(private), classicSessionKey(sharedKey), nil
+}
+
+type hybridKeyShare struct {
+	x25519  x25519KeyShare
+	kemSeed *SecretKey
+	public  []byte
+}
+
+// NewHybridKeyShare creates a key share which combines X25519 with ML-KEM-768 encapsulation.
+// It returns ErrHybridUnsupported when the binary was built without ML-KEM support.
+func NewHybridKeyShare() (KeyShare, error) {
+	classic, err := NewX25519KeyShare()
+	if err != nil {
+		return nil, err
+	}
+	kemSeed, encapsulationKey, err := kemGenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &hybridKeyShare{
+		x25519:  *classic.(*x25519KeyShare),
+		kemSeed: NewSecretKey(kemSeed),
+	}
+	k.public = make([]byte, 0, HybridClientShareSize)
+	k.public = append(k.public, k.x25519.public...)
+	k.public = append(k.public, encapsulationKey...)
+	return k, nil
+}
+
+func (k *hybridKeyShare) Public() []byte {
+	return k.public
+}
+
+func (k *hybridKeyShare) SessionKey(serverShare []byte) (*SecretKey, error) {
+	if len(serverShare) != HybridServerShareSize {
+		return nil, errInvalidServerShare
+	}
+	classicSecret, err := DeriveSharedKey(k.x25519.private.Bytes(), serverShare[:X25519ShareSize])
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute shared key: %w", err)
+	}
+	defer Wipe(classicSecret)
+	kemSecret, err := kemDecapsulate(k.kemSeed.Bytes(), serverShare[X25519ShareSize:])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decapsulate shared key: %w", err)
+	}
+	defer Wipe(kemSecret)
+	return hybridSessionKey(classicSecret, kemSecret, k.public, serverShare)
+}
+
+func (k *hybridKeyShare) Wipe() {
+	k.x25519.Wipe()
+	k.kemSeed.Wipe()
+}
+
+// RespondHybrid computes the server hello for a hybrid client hello and the resulting session key.
+func RespondHybrid(clientShare []byte) (serverShare []byte, sessionKey *SecretKey, err error) {
+	if len(clientShare) != HybridClientShareSize {
+		return nil, nil, ErrInvalidKeyShare
+	}
+	private := RandomBytes(32)
+	if private == nil {
+		return nil, nil, errors.New("cannot generate X25519 private key")
+	}
+	defer Wipe(private)
+	classicSecret, err := DeriveSharedKey(private, clientShare[:X25519ShareSize])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot compute shared key: %w", err)
+	}
+	defer Wipe(classicSecret)
+	kemSecret, ciphertext, err := kemEncapsulate(clientShare[X25519ShareSize:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot encapsulate shared key: %w", err)
+	}
+	defer Wipe(kemSecret)
+	serverShare = make([]byte, 0, HybridServerShareSize)
+	serverShare = append(serverShare, ComputePublicKey(private)...)
+	serverShare = append(serverShare, ciphertext...)
+	sessionKey, err = hybridSessionKey(classicSecret, kemSecret, clientShare, serverShare)
+	if err != nil {
+		return nil, nil, err
+	}
+	return serverShare, sessionKey, nil
+}
+
+// hybridSessionKey mixes both shared secrets into the session key. The hellos are used as
+// salt, so the key is bound to the transcript of the handshake.
+func hybridSessionKey(classicSecret, kemSecret, clientShare, serverShare []byte) (*SecretKey, error) {
+	ikm := make([]byte, 0, len(classicSecret)+len(kemSecret))
+	ikm = append(ikm, classicSecret...)
+	ikm = append(ikm, kemSecret...)
+	defer Wipe(ikm)
+	sessionKey := make([]byte, KeyBytes)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ikm, Transcript(clientShare, serverShare), []byte(hybridKeyScheduleLabel)), sessionKey); err != nil {
+		return nil, fmt.Errorf("cannot derive session key: %w", err)
+	}
+	return NewSecretKey(sessionKey), nil
+}
+
+// Transcript hashes the client and server hello of a key exchange.
+func Transcript(clientShare, serverShare []byte) []byte {
+	transcript := sha256.New()
+	transcript.Write(clientShare)
+	transcript.Write(serverShare)
+	return transcript.Sum(nil)
+}
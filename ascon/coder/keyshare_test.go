@@ -0,0 +1,113 @@
+package coder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyShareAgreement(t *testing.T) {
+	tests := []struct {
+		name             string
+		newKeyShare      func() (KeyShare, error)
+		respond          func([]byte) ([]byte, *SecretKey, error)
+		clientShareSize  int
+		serverShareSize  int
+		allowUnsupported bool
+	}{
+		{
+			name:            "x25519",
+			newKeyShare:     NewX25519KeyShare,
+			respond:         RespondX25519,
+			clientShareSize: X25519ShareSize,
+			serverShareSize: X25519ShareSize,
+		},
+		{
+			name:             "hybrid",
+			newKeyShare:      NewHybridKeyShare,
+			respond:          RespondHybrid,
+			clientShareSize:  HybridClientShareSize,
+			serverShareSize:  HybridServerShareSize,
+			allowUnsupported: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyShare, err := tt.newKeyShare()
+			if tt.allowUnsupported && errors.Is(err, ErrHybridUnsupported) {
+				t.Skip(err)
+			}
+			require.NoError(t, err)
+			require.Len(t, keyShare.Public(), tt.clientShareSize)
+
+			serverShare, serverKey, err := tt.respond(keyShare.Public())
+			require.NoError(t, err)
+			require.Len(t, serverShare, tt.serverShareSize)
+			require.Len(t, serverKey.Bytes(), KeyBytes)
+
+			clientKey, err := keyShare.SessionKey(serverShare)
+			require.NoError(t, err)
+			require.Equal(t, serverKey.Bytes(), clientKey.Bytes())
+
+			_, err = keyShare.SessionKey(serverShare[1:])
+			require.ErrorIs(t, err, ErrInvalidKeyShare)
+			_, _, err = tt.respond(keyShare.Public()[1:])
+			require.ErrorIs(t, err, ErrInvalidKeyShare)
+		})
+	}
+}
+
+func TestCoderActivatesPendingSecret(t *testing.T) {
+	key := RandomBytes(KeyBytes)
+	client := NewCoder().SetSecret(NewSecretKey(key))
+	server := NewCoder()
+	activated := false
+	server.SetPendingSecret(NewSecretKey(append([]byte(nil), key...)), func() {
+		activated = true
+	})
+	require.False(t, server.IsEstablished())
+
+	msg := message.Message{
+		Code:      codes.GET,
+		Type:      message.Confirmable,
+		MessageID: 1,
+		Token:     []byte{1, 2, 3},
+		Payload:   []byte("hello"),
+	}
+	size, err := client.Size(msg)
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	n, err := client.Encode(msg, buf)
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+
+	plainHandshake := make([]byte, 64)
+	handshakeLen, err := DefaultCoder.Encode(message.Message{Code: codes.HANDSHAKE, Type: message.Confirmable, MessageID: 2}, plainHandshake)
+	require.NoError(t, err)
+	plain := make([]byte, 64)
+	plainLen, err := DefaultCoder.Encode(msg, plain)
+	require.NoError(t, err)
+
+	// the pending key lets only the handshake pass in plaintext
+	var decoded message.Message
+	_, err = server.Decode(append([]byte(nil), plain[:plainLen]...), &decoded)
+	require.ErrorIs(t, err, ErrMessageAuthentication)
+	_, err = server.Decode(append([]byte(nil), plainHandshake[:handshakeLen]...), &decoded)
+	require.NoError(t, err)
+	require.False(t, activated)
+
+	_, err = server.Decode(buf[:n], &decoded)
+	require.NoError(t, err)
+	require.True(t, server.IsEstablished())
+	require.True(t, activated)
+	require.Equal(t, msg.Payload, decoded.Payload)
+
+	// once established, unauthenticated messages are refused, the handshake too
+	_, err = server.Decode(append([]byte(nil), plain[:plainLen]...), &decoded)
+	require.ErrorIs(t, err, ErrMessageAuthentication)
+	_, err = server.Decode(append([]byte(nil), plainHandshake[:handshakeLen]...), &decoded)
+	require.ErrorIs(t, err, ErrMessageAuthentication)
+}
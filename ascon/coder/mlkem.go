@@ -0,0 +1,30 @@
+//go:build go1.24
+
+package coder
+
+import "crypto/mlkem"
+
+func kemGenerateKey() (seed []byte, encapsulationKey []byte, err error) {
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+	return dk.Bytes(), dk.EncapsulationKey().Bytes(), nil
+}
+
+func kemEncapsulate(encapsulationKey []byte) (sharedKey []byte, ciphertext []byte, err error) {
+	ek, err := mlkem.NewEncapsulationKey768(encapsulationKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	sharedKey, ciphertext = ek.Encapsulate()
+	return sharedKey, ciphertext, nil
+}
+
+func kemDecapsulate(seed []byte, ciphertext []byte) ([]byte, error) {
+	dk, err := mlkem.NewDecapsulationKey768(seed)
+	if err != nil {
+		return nil, err
+	}
+	return dk.Decapsulate(ciphertext)
+}
@@ -0,0 +1,15 @@
+//go:build !go1.24
+
+package coder
+
+func kemGenerateKey() (seed []byte, encapsulationKey []byte, err error) {
+	return nil, nil, ErrHybridUnsupported
+}
+
+func kemEncapsulate([]byte) (sharedKey []byte, ciphertext []byte, err error) {
+	return nil, nil, ErrHybridUnsupported
+}
+
+func kemDecapsulate([]byte, []byte) ([]byte, error) {
+	return nil, ErrHybridUnsupported
+}
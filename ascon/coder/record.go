@@ -0,0 +1,44 @@
+package coder
+
+import "errors"
+
+// Seal encrypts and authenticates the plaintext by the key under a fresh nonce and returns the record:
+// ciphertext || tag 16 || nonce 16. The record is Overhead bytes longer than the plaintext.
+func Seal(key []byte, plaintext []byte) ([]byte, error) {
+	nonce := RandomBytes(NonceBytes)
+	if nonce == nil {
+		return nil, errors.New("cannot generate nonce")
+	}
+	return SealNonce(key, nonce, plaintext), nil
+}
+
+// SealNonce is like Seal under the given nonce of NonceBytes. The caller never reuses a nonce for the key.
+func SealNonce(key []byte, nonce []byte, plaintext []byte) []byte {
+	ciphertext, tag := Encrypt(key, nonce, plaintext)
+	record := make([]byte, 0, len(ciphertext)+Overhead)
+	record = append(record, ciphertext...)
+	record = append(record, tag...)
+	return append(record, nonce...)
+}
+
+// Open authenticates and decrypts the record which was sealed by the key. It reports false
+// when the record is shorter than Overhead or doesn't authenticate.
+func Open(key []byte, record []byte) ([]byte, bool) {
+	if len(record) < Overhead {
+		return nil, false
+	}
+	n := len(record) - Overhead
+	plaintext := Decrypt(key, record[n+TagBytes:], record[:n], record[n:n+TagBytes])
+	if plaintext == nil {
+		return nil, false
+	}
+	return plaintext, true
+}
+
+// RecordNonce returns the nonce of the record, which is authenticated by Open.
+func RecordNonce(record []byte) []byte {
+	if len(record) < Overhead {
+		return nil
+	}
+	return record[len(record)-NonceBytes:]
+}
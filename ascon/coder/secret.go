@@ -0,0 +1,61 @@
+package coder
+
+import (
+	"fmt"
+	"io"
+)
+
+const redacted = "[REDACTED]"
+
+// SecretKey holds key material. It is formatted as redacted by the fmt package,
+// and the key bytes are overwritten with zeros by Wipe.
+type SecretKey struct {
+	key []byte
+}
+
+// NewSecretKey takes ownership of key, the caller must not use the slice afterwards.
+func NewSecretKey(key []byte) *SecretKey {
+	return &SecretKey{key: key}
+}
+
+// Bytes returns the key material. The slice is wiped together with the key.
+func (k *SecretKey) Bytes() []byte {
+	if k == nil {
+		return nil
+	}
+	return k.key
+}
+
+// Len returns the size of the key in bytes.
+func (k *SecretKey) Len() int {
+	return len(k.Bytes())
+}
+
+// Wipe overwrites the key with zeros.
+func (k *SecretKey) Wipe() {
+	if k == nil {
+		return
+	}
+	Wipe(k.key)
+	k.key = nil
+}
+
+func (k *SecretKey) String() string {
+	return redacted
+}
+
+func (k *SecretKey) GoString() string {
+	return redacted
+}
+
+// Format prints the key as redacted for every verb, including %x and %v.
+func (k *SecretKey) Format(f fmt.State, _ rune) {
+	_, _ = io.WriteString(f, redacted)
+}
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,52 @@
+package coder
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretKeyRedacted(t *testing.T) {
+	key := NewSecretKey([]byte{0xde, 0xad, 0xbe, 0xef})
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%x", "%X", "%q"} {
+		require.Equal(t, redacted, fmt.Sprintf(format, key), format)
+	}
+	require.Equal(t, "key "+redacted, fmt.Sprint("key ", key))
+}
+
+func TestCoderWipesKeys(t *testing.T) {
+	first := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	second := RandomBytes(KeyBytes)
+	c := NewCoder().SetSecret(NewSecretKey(first))
+
+	// rekey, the current key stays until the peer proves the new one
+	c.SetPendingSecret(NewSecretKey(second), nil)
+	require.NotEqual(t, make([]byte, KeyBytes), first)
+	require.True(t, c.IsEstablished())
+
+	peer := NewCoder().SetSecret(NewSecretKey(append([]byte(nil), second...)))
+	msg := message.Message{
+		Code:      codes.GET,
+		Type:      message.Confirmable,
+		MessageID: 1,
+	}
+	buf := make([]byte, 64)
+	n, err := peer.Encode(msg, buf)
+	require.NoError(t, err)
+	var decoded message.Message
+	_, err = c.Decode(buf[:n], &decoded)
+	require.NoError(t, err)
+	require.Equal(t, make([]byte, KeyBytes), first)
+
+	c.Close()
+	require.Equal(t, make([]byte, KeyBytes), second)
+
+	// a closed coder doesn't fall back to plaintext
+	_, err = c.Encode(msg, make([]byte, 64))
+	require.ErrorIs(t, err, ErrCoderClosed)
+	_, err = c.Decode([]byte{0x40, byte(codes.GET), 0, 1}, &decoded)
+	require.ErrorIs(t, err, ErrCoderClosed)
+}
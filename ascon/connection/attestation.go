@@ -0,0 +1,388 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/cache"
+)
+
+// DefaultAttestationResultLifetime is the time for which the attestation result is cached when it is not set.
+const DefaultAttestationResultLifetime = time.Hour
+
+var errSessionNotEstablished = errors.New("session key is not established")
+
+// bindingKey derives the key which binds evidence to the session of the connection.
+func (cc *Conn) bindingKey() ([]byte, error) {
+	sessionKey, established := cc.session.Coder().SessionKey()
+	defer coder.Wipe(sessionKey)
+	if !established {
+		return nil, errSessionNotEstablished
+	}
+	return attestation.BindingKey(sessionKey)
+}
+
+// Attest challenges the peer with PROVE and a fresh nonce. It returns the evidence of the peer,
+// which is verified to answer the nonce and to be bound to the session. It returns attestation.ErrProofNotFound
+// or attestation.ErrUnauthorized when the peer doesn't prove.
+func (cc *Conn) Attest(ctx context.Context) (*attestation.Evidence, error) {
+	nonce, err := attestation.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	return cc.attest(ctx, nonce)
+}
+
+// AttestWithVerifier challenges the peer with a nonce issued by the verifier and appraises the evidence
+// against the reference values of the device.
+func (cc *Conn) AttestWithVerifier(ctx context.Context, v *attestation.Verifier, device attestation.Device) (*attestation.AttestationResult, error) {
+	nonce, err := v.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	evidence, err := cc.attest(ctx, nonce)
+	if err != nil {
+		// the verdict without status records that the peer didn't prove
+		cc.audit(attestation.AuditEntry{Event: attestation.AuditVerdict, Method: "prove", Device: device, Nonce: nonce, Reasons: []string{err.Error()}})
+		return nil, err
+	}
+	return cc.verifyEvidence("prove", v, device, evidence)
+}
+
+// AttestationResult returns the cached result of the last appraisal of the peer in the current session. It returns
+// nil when the peer wasn't appraised, or the result expired or was appraised under another policy version.
+func (cc *Conn) AttestationResult() *attestation.AttestationResult {
+	e := cc.attestationResults.Load(cc.currentPolicyVersion())
+	if e == nil {
+		return nil
+	}
+	return e.Data()
+}
+
+// InvalidateAttestationResult drops the cached result, so the peer must be attested again.
+func (cc *Conn) InvalidateAttestationResult() {
+	cc.attestationResults.LoadAndDeleteAll()
+}
+
+func (cc *Conn) setAttestationResult(result *attestation.AttestationResult) {
+	lifetime := cc.attestationResultLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultAttestationResultLifetime
+	}
+	validUntil := result.Time.Add(lifetime)
+	if !result.Expires.IsZero() && result.Expires.Before(validUntil) {
+		validUntil = result.Expires
+	}
+	cc.attestationResults.Store(cc.currentPolicyVersion(), cache.NewElement(result, validUntil, nil))
+	if cc.Quarantined() && cc.liftsQuarantine(result) {
+		cc.SetQuarantined(false)
+	}
+}
+
+func (cc *Conn) currentPolicyVersion() uint64 {
+	if cc.policyVersion == nil {
+		return 0
+	}
+	return cc.policyVersion()
+}
+
+// checkAttestationResults drops the expired results and the results of the previous policy versions.
+func (cc *Conn) checkAttestationResults(now time.Time) {
+	version := cc.currentPolicyVersion()
+	cc.attestationResults.Range(func(key uint64, _ *cache.Element[*attestation.AttestationResult]) bool {
+		if key != version {
+			cc.logger.Debugf("%v: policy changed, dropping attestation result", cc.RemoteAddr())
+			cc.attestationResults.Delete(key)
+		}
+		return true
+	})
+	cc.attestationResults.CheckExpirations(now)
+}
+
+func (cc *Conn) verifyEvidence(method string, v *attestation.Verifier, device attestation.Device, evidence *attestation.Evidence) (*attestation.AttestationResult, error) {
+	bindingKey, err := cc.bindingKey()
+	if err != nil {
+		return nil, err
+	}
+	defer coder.Wipe(bindingKey)
+	result := v.Verify(device, evidence, bindingKey)
+	cc.checkRevokedResult(result)
+	cc.setAttestationResult(result)
+	cc.auditVerdict(method, evidence.Nonce, evidence.Marshal(), result)
+	return result, nil
+}
+
+// Challenge issues a nonce of the verifier, which the peer answers by a PROOF request.
+func (cc *Conn) Challenge(v *attestation.Verifier) ([]byte, error) {
+	nonce, err := v.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	cc.audit(attestation.AuditEntry{Event: attestation.AuditRequest, Method: "proof", Nonce: nonce})
+	return nonce, nil
+}
+
+// VerifyProof appraises the evidence which the peer pushed in a PROOF request for a nonce of the verifier,
+// e.g. after it was challenged by 4.01 Unauthorized. The result is stored as the attestation result of the connection.
+func (cc *Conn) VerifyProof(v *attestation.Verifier, device attestation.Device, r *pool.Message) (*attestation.AttestationResult, error) {
+	if r.Code() != codes.PROOF {
+		return nil, fmt.Errorf("unexpected code %v", r.Code())
+	}
+	evidence, err := decodeEvidence(r)
+	if err != nil {
+		return nil, err
+	}
+	return cc.verifyEvidence("proof", v, device, &evidence)
+}
+
+// VerifyGroupProof appraises the response of the peer to the multicast PROVE with the nonce, which is sent by
+// ascon.Server.AttestGroup. The evidence is bound to the identity key of the member instead of a session, so the result
+// isn't stored as the attestation result of the connection. It returns the fingerprint of the identity key, and device
+// selects the reference values of the identity. The responses which don't prove an identity key return an error without
+// the fingerprint.
+func (cc *Conn) VerifyGroupProof(v *attestation.Verifier, nonce []byte, r *pool.Message, device func(identity string) attestation.Device) (string, *attestation.AttestationResult, error) {
+	switch r.Code() {
+	case codes.Proof:
+	case codes.ProofNotFound:
+		return "", nil, attestation.ErrProofNotFound
+	case codes.Unauthorized:
+		return "", nil, attestation.ErrUnauthorized
+	default:
+		return "", nil, fmt.Errorf("unexpected response to prove: %v", r.Code())
+	}
+	evidence, err := decodeEvidence(r)
+	if err != nil {
+		return "", nil, err
+	}
+	identityKey, err := evidence.VerifyGroup(nonce)
+	if err != nil {
+		return "", nil, err
+	}
+	identity := keystore.Fingerprint(identityKey)
+	result := v.VerifyNonce(device(identity), &evidence, nil, evidence.Nonce)
+	cc.checkRevokedResult(result)
+	cc.audit(attestation.AuditEntry{
+		Event:          attestation.AuditVerdict,
+		Method:         "group",
+		Subject:        identity,
+		Device:         result.Device,
+		Nonce:          nonce,
+		EvidenceDigest: attestation.EvidenceDigest(evidence.Marshal()),
+		Status:         result.Status.String(),
+		Reasons:        result.Reasons,
+	})
+	return identity, result, nil
+}
+
+// Prove answers the challenge nonce of the peer by a PROOF request to the path. It returns the response of the peer.
+func (cc *Conn) Prove(ctx context.Context, path string, nonce []byte, opts ...message.Option) (*pool.Message, error) {
+	if cc.attester == nil {
+		return nil, errors.New("cannot prove: no attester")
+	}
+	if len(nonce) != attestation.NonceSize {
+		return nil, fmt.Errorf("cannot prove: invalid nonce size %v", len(nonce))
+	}
+	format, body, err := cc.newProof(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("cannot prove: %w", err)
+	}
+	req, err := cc.NewPostRequest(ctx, path, format, bytes.NewReader(body), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create proof request: %w", err)
+	}
+	defer cc.ReleaseMessage(req)
+	req.SetCode(codes.PROOF)
+	return cc.Do(req)
+}
+
+// newProof quotes the attester for the nonce and binds the evidence to the session.
+func (cc *Conn) newProof(nonce []byte) (message.MediaType, []byte, error) {
+	bindingKey, err := cc.bindingKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer coder.Wipe(bindingKey)
+	measurement, err := cc.quote(nonce)
+	if err != nil {
+		return 0, nil, err
+	}
+	evidence := attestation.NewEvidence(bindingKey, nonce, measurement)
+	evidence.Format = attestation.FormatOf(cc.attester)
+	return evidence.Encode()
+}
+
+// newGroupProof quotes the attester for the nonce of the multicast PROVE, the evidence is bound to the identity key
+// instead of a session.
+func (cc *Conn) newGroupProof(nonce []byte) (message.MediaType, []byte, error) {
+	if cc.keystore == nil {
+		return 0, nil, errors.New("no identity key")
+	}
+	identityKey, err := cc.keystore.IdentityKey()
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot load identity key: %w", err)
+	}
+	groupNonce, err := attestation.GroupNonce(nonce, identityKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		return 0, nil, err
+	}
+	measurement, err := cc.quote(groupNonce)
+	if err != nil {
+		return 0, nil, err
+	}
+	evidence, err := attestation.NewGroupEvidence(identityKey, nonce, measurement)
+	if err != nil {
+		return 0, nil, err
+	}
+	evidence.Format = attestation.FormatOf(cc.attester)
+	return evidence.Encode()
+}
+
+func (cc *Conn) quote(nonce []byte) ([]byte, error) {
+	measurement, err := cc.attester.Quote(nonce)
+	if err == nil && len(measurement) > attestation.MaxMeasurementSize {
+		err = fmt.Errorf("measurement exceeds %v bytes", attestation.MaxMeasurementSize)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot quote: %w", err)
+	}
+	return measurement, nil
+}
+
+func decodeEvidence(r *pool.Message) (attestation.Evidence, error) {
+	body, err := r.ReadBody()
+	if err != nil {
+		return attestation.Evidence{}, fmt.Errorf("cannot read proof: %w", err)
+	}
+	format, err := r.ContentFormat()
+	if err != nil {
+		format = message.AppOctets
+	}
+	return attestation.DecodeEvidence(format, body)
+}
+
+func (cc *Conn) attest(ctx context.Context, nonce []byte) (*attestation.Evidence, error) {
+	if !cc.session.Coder().IsEstablished() {
+		return nil, fmt.Errorf("cannot attest: %w", errSessionNotEstablished)
+	}
+	req := cc.AcquireMessage(ctx)
+	defer cc.ReleaseMessage(req)
+	token, err := cc.Client.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token: %w", err)
+	}
+	req.SetCode(codes.PROVE)
+	req.SetToken(token)
+	req.SetContentFormat(message.AppOctets)
+	req.SetBody(bytes.NewReader(nonce))
+	cc.audit(attestation.AuditEntry{Event: attestation.AuditRequest, Method: "prove", Nonce: nonce})
+
+	resp, err := cc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot send prove: %w", err)
+	}
+	defer cc.ReleaseMessage(resp)
+	switch resp.Code() {
+	case codes.Proof:
+	case codes.ProofNotFound:
+		return nil, attestation.ErrProofNotFound
+	case codes.Unauthorized:
+		return nil, attestation.ErrUnauthorized
+	default:
+		return nil, fmt.Errorf("unexpected response to prove: %v", resp.Code())
+	}
+	evidence, err := decodeEvidence(resp)
+	if err != nil {
+		return nil, err
+	}
+	bindingKey, err := cc.bindingKey()
+	if err != nil {
+		return nil, err
+	}
+	defer coder.Wipe(bindingKey)
+	if err = evidence.Verify(bindingKey, nonce); err != nil {
+		return nil, err
+	}
+	return &evidence, nil
+}
+
+func isProve(r *pool.Message) bool {
+	return r.Code() == codes.PROVE
+}
+
+// handleProve answers the challenge of the verifier with the quote of the attester of the connection.
+func (cc *Conn) handleProve(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
+	nonce, err := r.ReadBody()
+	if err != nil || len(nonce) != attestation.NonceSize {
+		cc.rejectProve(w, codes.BadRequest, fmt.Errorf("invalid nonce: %v bytes, %w", len(nonce), err))
+		return
+	}
+	if !cc.authenticated() {
+		cc.rejectProve(w, codes.Unauthorized, ErrPeerNotAuthenticated)
+		return
+	}
+	newProof := cc.newProof
+	if !cc.session.Coder().IsEstablished() {
+		if !cc.groupAttestation || r.Type() != message.NonConfirmable {
+			cc.rejectProve(w, codes.Unauthorized, errSessionNotEstablished)
+			return
+		}
+		// the multicast PROVE of the group attestation
+		newProof = cc.newGroupProof
+	}
+	if cc.attester == nil {
+		cc.rejectProve(w, codes.ProofNotFound, errors.New("no attester"))
+		return
+	}
+	format, body, err := newProof(nonce)
+	if err != nil {
+		cc.rejectProve(w, codes.ProofNotFound, err)
+		return
+	}
+	if err = w.SetResponse(codes.Proof, format, bytes.NewReader(body)); err != nil {
+		cc.errors(fmt.Errorf("cannot send proof: %w", err))
+	}
+}
+
+func (cc *Conn) rejectProve(w *responsewriter.ResponseWriter[*Conn], code codes.Code, err error) {
+	cc.logger.Debugf("%v: prove: %v", cc.RemoteAddr(), err)
+	if errS := w.SetResponse(code, message.TextPlain, nil); errS != nil {
+		cc.errors(fmt.Errorf("cannot reject prove: %w", errS))
+	}
+}
+
+// audit appends the entry of the peer to the audit log.
+func (cc *Conn) audit(e attestation.AuditEntry) {
+	if cc.auditLog == nil {
+		return
+	}
+	e.Peer = cc.RemoteAddr().String()
+	if e.Subject == "" {
+		e.Subject = cc.PeerIdentity().PassportSubject()
+	}
+	if _, err := cc.auditLog.Append(e); err != nil {
+		cc.errors(fmt.Errorf("cannot append audit log: %w", err))
+	}
+}
+
+func (cc *Conn) auditVerdict(method string, nonce, evidence []byte, result *attestation.AttestationResult) {
+	cc.audit(attestation.AuditEntry{
+		Event:          attestation.AuditVerdict,
+		Method:         method,
+		Device:         result.Device,
+		Nonce:          nonce,
+		EvidenceDigest: attestation.EvidenceDigest(evidence),
+		Status:         result.Status.String(),
+		Reasons:        result.Reasons,
+	})
+}
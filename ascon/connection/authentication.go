@@ -0,0 +1,525 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+)
+
+// The finished flight follows the key exchange and is sent under the session key. It carries
+// TLV encoded proofs (1 byte type, 2 bytes length) that the endpoint holds a pre-shared key
+// or an identity key. Both proofs cover the transcript of the key exchange.
+const (
+	finishedPSKIdentity byte = iota + 1
+	finishedPSKBinder
+	finishedPublicKey
+	finishedSignature
+	// finishedEvidence carries the content format (2 bytes) and the encoded evidence for the nonce derived from the transcript.
+	finishedEvidence
+	// finishedEvidenceRequest asks the server to send its evidence in the server finished.
+	finishedEvidenceRequest
+
+	clientFinishedLabel = "ascon client finished"
+	serverFinishedLabel = "ascon server finished"
+
+	authenticationTimeout = time.Second
+)
+
+var (
+	ErrPeerNotAuthenticated = errors.New("peer is not authenticated")
+	errInvalidFinished      = errors.New("invalid finished message")
+)
+
+// PeerIdentity describes how the peer authenticated in the handshake.
+type PeerIdentity struct {
+	// PSKIdentity is the identity of the pre-shared key which the peer proved to hold.
+	PSKIdentity string
+	// PublicKey is the identity key which signed the handshake transcript.
+	PublicKey ed25519.PublicKey
+	// TrustAnchor is the name of the trust anchor matching PublicKey; empty when the key is not trusted.
+	TrustAnchor string
+}
+
+// Authenticated reports whether the peer proved a pre-shared key or a trusted identity key.
+func (p PeerIdentity) Authenticated() bool {
+	return p.PSKIdentity != "" || p.TrustAnchor != ""
+}
+
+type finished struct {
+	pskIdentity string
+	pskBinder   []byte
+	publicKey   ed25519.PublicKey
+	signature   []byte
+	evidence    []byte
+
+	evidenceRequested bool
+}
+
+func (f finished) marshal() []byte {
+	var buf []byte
+	appendTLV := func(typ byte, value []byte) {
+		if len(value) == 0 {
+			return
+		}
+		buf = append(buf, typ)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(value)))
+		buf = append(buf, value...)
+	}
+	appendTLV(finishedPSKIdentity, []byte(f.pskIdentity))
+	appendTLV(finishedPSKBinder, f.pskBinder)
+	appendTLV(finishedPublicKey, f.publicKey)
+	appendTLV(finishedSignature, f.signature)
+	appendTLV(finishedEvidence, f.evidence)
+	if f.evidenceRequested {
+		appendTLV(finishedEvidenceRequest, []byte{1})
+	}
+	return buf
+}
+
+func parseFinished(data []byte) (finished, error) {
+	var f finished
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return finished{}, errInvalidFinished
+		}
+		typ := data[0]
+		size := int(binary.BigEndian.Uint16(data[1:3]))
+		data = data[3:]
+		if len(data) < size {
+			return finished{}, errInvalidFinished
+		}
+		value := data[:size]
+		data = data[size:]
+		switch typ {
+		case finishedPSKIdentity:
+			f.pskIdentity = string(value)
+		case finishedPSKBinder:
+			f.pskBinder = value
+		case finishedPublicKey:
+			f.publicKey = value
+		case finishedSignature:
+			f.signature = value
+		case finishedEvidence:
+			f.evidence = value
+		case finishedEvidenceRequest:
+			f.evidenceRequested = true
+		}
+		// unknown types are skipped, so the flight can be extended
+	}
+	return f, nil
+}
+
+func signedContent(transcript []byte, label string) []byte {
+	content := make([]byte, 0, len(transcript)+len(label))
+	content = append(content, transcript...)
+	return append(content, label...)
+}
+
+func pskBinder(psk []byte, transcript []byte, label string) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write(signedContent(transcript, label))
+	return mac.Sum(nil)
+}
+
+func (cc *Conn) newFinished(transcript []byte, label string, pskIdentity string, psk []byte, attest bool) (finished, error) {
+	var evidence []byte
+	if attest && cc.attester != nil {
+		var err error
+		evidence, err = cc.handshakeEvidence(transcript, label)
+		if err != nil {
+			return finished{}, err
+		}
+	}
+	f, err := newFinished(cc.keystore, transcript, label, pskIdentity, psk)
+	if err != nil {
+		return finished{}, err
+	}
+	f.evidence = evidence
+	return f, nil
+}
+
+// newFinished proves the pre-shared key and the identity key from the keystore.
+func newFinished(ks keystore.Keystore, transcript []byte, label string, pskIdentity string, psk []byte) (finished, error) {
+	var f finished
+	if pskIdentity != "" {
+		f.pskIdentity = pskIdentity
+		f.pskBinder = pskBinder(psk, transcript, label)
+	}
+	if ks == nil {
+		return f, nil
+	}
+	key, err := ks.IdentityKey()
+	switch {
+	case errors.Is(err, keystore.ErrNotFound):
+		return f, nil
+	case err != nil:
+		return finished{}, fmt.Errorf("cannot load identity key: %w", err)
+	}
+	f.publicKey = key.Public().(ed25519.PublicKey)
+	f.signature = ed25519.Sign(key, signedContent(transcript, label))
+	return f, nil
+}
+
+// verifyFinished checks the proofs of the peer. It returns the identity of the peer and
+// the pre-shared key which the peer used.
+func (cc *Conn) verifyFinished(f finished, transcript []byte, label string) (PeerIdentity, []byte, error) {
+	return verifyFinished(cc.keystore, f, transcript, label)
+}
+
+func verifyFinished(ks keystore.Keystore, f finished, transcript []byte, label string) (PeerIdentity, []byte, error) {
+	var identity PeerIdentity
+	var psk []byte
+	if f.pskIdentity != "" {
+		var err error
+		psk, err = loadPSK(ks, f.pskIdentity)
+		if err != nil {
+			return PeerIdentity{}, nil, err
+		}
+		if !hmac.Equal(f.pskBinder, pskBinder(psk, transcript, label)) {
+			return PeerIdentity{}, nil, fmt.Errorf("psk %v: invalid binder", f.pskIdentity)
+		}
+		identity.PSKIdentity = f.pskIdentity
+	}
+	if f.publicKey != nil {
+		if len(f.publicKey) != ed25519.PublicKeySize || !ed25519.Verify(f.publicKey, signedContent(transcript, label), f.signature) {
+			return PeerIdentity{}, nil, errors.New("invalid signature of identity key")
+		}
+		identity.PublicKey = f.publicKey
+		if ks != nil {
+			anchor, err := keystore.FindTrustAnchor(ks, f.publicKey)
+			switch {
+			case err == nil:
+				identity.TrustAnchor = anchor.Name
+			case !errors.Is(err, keystore.ErrNotFound):
+				return PeerIdentity{}, nil, err
+			}
+		}
+	}
+	return identity, psk, nil
+}
+
+func loadPSK(ks keystore.Keystore, identity string) ([]byte, error) {
+	if ks == nil {
+		return nil, fmt.Errorf("psk %v: %w", identity, keystore.ErrNotFound)
+	}
+	psk, err := ks.PSK(identity)
+	if err != nil {
+		return nil, fmt.Errorf("psk %v: %w", identity, err)
+	}
+	return psk, nil
+}
+
+// PeerIdentity returns the identity which the peer proved in the handshake.
+func (cc *Conn) PeerIdentity() PeerIdentity {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	return cc.peerIdentity
+}
+
+// Transcript returns the hash of the key exchange of the current session.
+func (cc *Conn) Transcript() []byte {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	return cc.transcript
+}
+
+// setTranscript starts a new session, the peer needs to authenticate and attest again.
+func (cc *Conn) setTranscript(transcript []byte) {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	cc.transcript = transcript
+	cc.peerIdentity = PeerIdentity{}
+	cc.attestationResults.LoadAndDeleteAll()
+	cc.handshakeAttested.Store(false)
+	cc.handshakeRejected.Store(false)
+	cc.handshakeFinished.Store(false)
+}
+
+// setPendingTranscript stores the transcript of the key exchange which replaces the session
+// once the peer proves the new key.
+func (cc *Conn) setPendingTranscript(transcript []byte) {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	cc.pendingTranscript = transcript
+}
+
+func (cc *Conn) getPendingTranscript() []byte {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	return cc.pendingTranscript
+}
+
+// activatePendingSession is called by the coder when the peer proves the pending key.
+func (cc *Conn) activatePendingSession() {
+	cc.authMutex.Lock()
+	transcript := cc.pendingTranscript
+	cc.pendingTranscript = nil
+	cc.authMutex.Unlock()
+	cc.setTranscript(transcript)
+	cc.sessionSaved.Store(false)
+}
+
+func (cc *Conn) setPeerIdentity(identity PeerIdentity) {
+	cc.authMutex.Lock()
+	defer cc.authMutex.Unlock()
+	cc.peerIdentity = identity
+}
+
+// Authenticate finishes the client handshake: it proves the credentials of the client and
+// verifies the proofs of the server. Without credentials and required peer authentication it does nothing.
+func (cc *Conn) Authenticate() error {
+	if cc.pskIdentity == "" && cc.keystore == nil && !cc.requirePeerAuthentication && !cc.attestInHandshake && cc.handshakeAttestation == nil {
+		cc.handshakeFinished.Store(true)
+		return nil
+	}
+	transcript := cc.Transcript()
+	if transcript == nil {
+		return errors.New("authentication before key exchange")
+	}
+
+	var psk []byte
+	if cc.pskIdentity != "" {
+		var err error
+		if psk, err = loadPSK(cc.keystore, cc.pskIdentity); err != nil {
+			return err
+		}
+	}
+	clientFinished, err := cc.newFinished(transcript, clientFinishedLabel, cc.pskIdentity, psk, cc.attestInHandshake)
+	if err != nil {
+		return err
+	}
+	clientFinished.evidenceRequested = cc.handshakeAttestation != nil
+
+	ctx, cancel := context.WithTimeout(cc.Context(), authenticationTimeout)
+	defer cancel()
+	request := cc.AcquireMessage(ctx)
+	defer cc.ReleaseMessage(request)
+	token, err := cc.Client.GetToken()
+	if err != nil {
+		return fmt.Errorf("cannot get token: %w", err)
+	}
+	request.SetCode(codes.HANDSHAKE)
+	request.SetToken(token)
+	request.SetContentFormat(message.AppOctets)
+	request.SetBody(bytes.NewReader(clientFinished.marshal()))
+
+	response, err := cc.LimitParallelRequests.Do(request)
+	if err != nil {
+		return fmt.Errorf("cannot send client finished: %w", err)
+	}
+	defer cc.ReleaseMessage(response)
+	if response.Code() != codes.Content {
+		return fmt.Errorf("server rejected client finished: %v", response.Code())
+	}
+	body, err := response.ReadBody()
+	if err != nil {
+		return fmt.Errorf("cannot read server finished: %w", err)
+	}
+	serverFinished, err := parseFinished(body)
+	if err != nil {
+		return err
+	}
+	identity, _, err := cc.verifyFinished(serverFinished, transcript, serverFinishedLabel)
+	if err != nil {
+		return fmt.Errorf("server finished: %w", err)
+	}
+	if identity.PSKIdentity != cc.pskIdentity {
+		return fmt.Errorf("server finished: psk %v: missing binder", cc.pskIdentity)
+	}
+	if cc.requirePeerAuthentication && !identity.Authenticated() {
+		return fmt.Errorf("server finished: %w", ErrPeerNotAuthenticated)
+	}
+	cc.setPeerIdentity(identity)
+	if cc.handshakeAttestation != nil {
+		if err = cc.verifyHandshakeEvidence(serverFinished, transcript, serverFinishedLabel); err != nil {
+			return fmt.Errorf("server finished: %w", err)
+		}
+		cc.handshakeAttested.Store(true)
+	}
+	cc.handshakeFinished.Store(true)
+	return nil
+}
+
+func isClientFinished(r *pool.Message) bool {
+	if r.Code() != codes.HANDSHAKE {
+		return false
+	}
+	cf, err := r.ContentFormat()
+	return err == nil && cf == message.AppOctets
+}
+
+func (cc *Conn) handleClientFinished(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
+	transcript := cc.Transcript()
+	if transcript == nil || !cc.session.Coder().IsEstablished() {
+		cc.rejectHandshake(w, codes.BadRequest, errors.New("client finished before key exchange"))
+		return
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		cc.rejectHandshake(w, codes.BadRequest, fmt.Errorf("cannot read client finished: %w", err))
+		return
+	}
+	clientFinished, err := parseFinished(body)
+	if err != nil {
+		cc.rejectHandshake(w, codes.BadRequest, err)
+		return
+	}
+	identity, psk, err := cc.verifyFinished(clientFinished, transcript, clientFinishedLabel)
+	if err != nil {
+		cc.rejectHandshake(w, codes.Unauthorized, fmt.Errorf("client finished: %w", err))
+		return
+	}
+	if cc.requirePeerAuthentication && !identity.Authenticated() {
+		cc.rejectHandshake(w, codes.Unauthorized, fmt.Errorf("client finished: %w", ErrPeerNotAuthenticated))
+		return
+	}
+	cc.setPeerIdentity(identity)
+	if err = cc.checkRevoked(); err != nil {
+		cc.handshakeRejected.Store(true)
+		cc.rejectHandshake(w, codes.Unauthorized, fmt.Errorf("client finished: %w", err))
+		return
+	}
+	if cc.handshakeAttestation != nil {
+		if err = cc.verifyHandshakeEvidence(clientFinished, transcript, clientFinishedLabel); err != nil {
+			// the keys of the session are never used, the connection is closed by CheckExpirations
+			cc.handshakeRejected.Store(true)
+			cc.rejectHandshake(w, codes.Unauthorized, fmt.Errorf("client finished: %w", err))
+			return
+		}
+		cc.handshakeAttested.Store(true)
+	}
+	// the requests of the peer are passed to the handler from now on
+	cc.handshakeFinished.Store(true)
+	// the server proves its measurements to the client which requests them
+	serverFinished, err := cc.newFinished(transcript, serverFinishedLabel, identity.PSKIdentity, psk, cc.attestInHandshake || clientFinished.evidenceRequested)
+	if err != nil {
+		cc.rejectHandshake(w, codes.InternalServerError, err)
+		return
+	}
+	if err = cc.SaveSession(); err != nil {
+		cc.errors(fmt.Errorf("%v: cannot save session: %w", cc.RemoteAddr(), err))
+	}
+	if err = w.SetResponse(codes.Content, message.AppOctets, bytes.NewReader(serverFinished.marshal())); err != nil {
+		cc.errors(fmt.Errorf("cannot send server finished: %w", err))
+	}
+}
+
+// requiresFinished reports whether the peer must authenticate or attest by the finished flight.
+func (cc *Conn) requiresFinished() bool {
+	return cc.requirePeerAuthentication || cc.handshakeAttestation != nil
+}
+
+// authenticated reports whether the peer finished the handshake with the authentication and the attestation
+// which the connection requires.
+func (cc *Conn) authenticated() bool {
+	if !cc.requiresFinished() {
+		return true
+	}
+	if !cc.handshakeFinished.Load() {
+		return false
+	}
+	authenticated := !cc.requirePeerAuthentication || cc.PeerIdentity().Authenticated()
+	attested := cc.handshakeAttestation == nil || cc.handshakeAttested.Load()
+	return authenticated && attested
+}
+
+// rejectUnauthenticated answers requests with 4.01 until the peer finished the handshake, when peer authentication
+// or attestation in the handshake is required. Every request code but the handshake is refused, the codes which are
+// unknown to the library too.
+func (cc *Conn) rejectUnauthenticated(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) bool {
+	if !isRequest(r.Code()) || cc.authenticated() {
+		return false
+	}
+	if err := w.SetResponse(codes.Unauthorized, message.TextPlain, nil); err != nil {
+		cc.errors(fmt.Errorf("cannot reject request: %w", err))
+	}
+	return true
+}
+
+// Authenticator runs the finished flight of a key exchange which isn't run by Conn, like the one of CoAP over TCP.
+// It proves and verifies the pre-shared key and the identity key like Conn, evidence isn't sent.
+type Authenticator struct {
+	// Keystore provides the identity key, pre-shared keys and trust anchors.
+	Keystore keystore.Keystore
+	// PSKIdentity selects the pre-shared key which the client proves.
+	PSKIdentity string
+	// RequirePeerAuthentication rejects the peer which proves neither a pre-shared key nor a trusted identity key.
+	RequirePeerAuthentication bool
+}
+
+// NewAuthenticator creates the authenticator with the credentials of the configuration.
+func NewAuthenticator(cfg Config) Authenticator {
+	return Authenticator{
+		Keystore:                  cfg.Keystore,
+		PSKIdentity:               cfg.PSKIdentity,
+		RequirePeerAuthentication: cfg.RequirePeerAuthentication,
+	}
+}
+
+// ClientFinished returns the client finished for the transcript of the key exchange.
+func (a Authenticator) ClientFinished(transcript []byte) ([]byte, error) {
+	var psk []byte
+	if a.PSKIdentity != "" {
+		var err error
+		if psk, err = loadPSK(a.Keystore, a.PSKIdentity); err != nil {
+			return nil, err
+		}
+	}
+	f, err := newFinished(a.Keystore, transcript, clientFinishedLabel, a.PSKIdentity, psk)
+	if err != nil {
+		return nil, err
+	}
+	return f.marshal(), nil
+}
+
+// VerifyClientFinished verifies the client finished and returns the identity of the client
+// and the server finished which answers it.
+func (a Authenticator) VerifyClientFinished(data []byte, transcript []byte) (PeerIdentity, []byte, error) {
+	clientFinished, err := parseFinished(data)
+	if err != nil {
+		return PeerIdentity{}, nil, err
+	}
+	identity, psk, err := verifyFinished(a.Keystore, clientFinished, transcript, clientFinishedLabel)
+	if err != nil {
+		return PeerIdentity{}, nil, fmt.Errorf("client finished: %w", err)
+	}
+	if a.RequirePeerAuthentication && !identity.Authenticated() {
+		return PeerIdentity{}, nil, fmt.Errorf("client finished: %w", ErrPeerNotAuthenticated)
+	}
+	serverFinished, err := newFinished(a.Keystore, transcript, serverFinishedLabel, identity.PSKIdentity, psk)
+	if err != nil {
+		return PeerIdentity{}, nil, err
+	}
+	return identity, serverFinished.marshal(), nil
+}
+
+// VerifyServerFinished verifies the server finished and returns the identity of the server.
+func (a Authenticator) VerifyServerFinished(data []byte, transcript []byte) (PeerIdentity, error) {
+	serverFinished, err := parseFinished(data)
+	if err != nil {
+		return PeerIdentity{}, err
+	}
+	identity, _, err := verifyFinished(a.Keystore, serverFinished, transcript, serverFinishedLabel)
+	if err != nil {
+		return PeerIdentity{}, fmt.Errorf("server finished: %w", err)
+	}
+	if identity.PSKIdentity != a.PSKIdentity {
+		return PeerIdentity{}, fmt.Errorf("server finished: psk %v: missing binder", a.PSKIdentity)
+	}
+	if a.RequirePeerAuthentication && !identity.Authenticated() {
+		return PeerIdentity{}, fmt.Errorf("server finished: %w", ErrPeerNotAuthenticated)
+	}
+	return identity, nil
+}
@@ -2,9 +2,14 @@ package connection
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"time"
 
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/revocation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/sessionstore"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
@@ -26,6 +31,50 @@ type Config struct {
 	TransmissionMaxRetransmit      uint32
 	CloseSocket                    bool
 	MTU                            uint16
+	// HybridKeyExchange makes the client send a hybrid X25519 + ML-KEM-768 key share.
+	// The server always accepts hybrid key shares; with this set it rejects classic ones.
+	HybridKeyExchange bool
+	// Keystore provides the identity key, pre-shared keys and trust anchors for peer authentication.
+	Keystore keystore.Keystore
+	// PSKIdentity selects the pre-shared key which the client proves in the handshake.
+	PSKIdentity string
+	// RequirePeerAuthentication fails the handshake of a peer which proves neither a pre-shared key
+	// nor a trusted identity key, and answers its requests with 4.01 Unauthorized.
+	RequirePeerAuthentication bool
+	// Logger receives diagnostics of the connection.
+	Logger Logger
+	// KeyLogWriter receives the session keys in the format of the keylog package, so captured
+	// traffic can be decrypted. Use of KeyLogWriter compromises security and should only be used for debugging.
+	KeyLogWriter io.Writer
+	// SessionStore persists the sessions of the server, sealed under SessionMasterKey, so they
+	// survive restarts and are shared by replicas.
+	SessionStore     sessionstore.Store
+	SessionMasterKey []byte
+	// Attester quotes the measurement which is sent in the proof when the peer challenges the connection with PROVE.
+	// Without it PROVE is answered with 4.16 Proof Not Found.
+	Attester attestation.Attester
+	// Reattestation attests the peer periodically and acts when it fails.
+	Reattestation *Reattestation
+	// AttestInHandshake sends the evidence of the Attester in the finished flight of the handshake.
+	AttestInHandshake bool
+	// HandshakeAttestation requires the evidence of the peer in the finished flight of the handshake.
+	// The handshake of a peer whose evidence fails is rejected and its requests are answered with 4.01 Unauthorized.
+	HandshakeAttestation *HandshakeAttestation
+	// AuditLog records the challenges and the verdicts of the attestations of the peer.
+	AuditLog *attestation.AuditLog
+	// Revocations are consulted when the handshake completes and when the attestation is verified.
+	// The sessions of the peers which become revoked are closed.
+	Revocations *revocation.List
+	// GroupAttestation answers the multicast PROVE of the group attestation outside of a session.
+	// The evidence is bound to the identity key of the Keystore instead of a session.
+	GroupAttestation bool
+	// AttestationResultLifetime is the time for which the attestation result of the peer is cached,
+	// DefaultAttestationResultLifetime when it is not set. The result is dropped earlier when it expires,
+	// when the session is rekeyed or when the policy version changes.
+	AttestationResultLifetime time.Duration
+	// PolicyVersion returns the version of the appraisal policy, e.g. attestation.ReferenceValues.Version.
+	// The results which were cached under another version are dropped.
+	PolicyVersion func() uint64
 }
 
 func NewConfig(
@@ -47,6 +96,7 @@ func NewConfig(
 		GetMID:                         message.GetMID,
 		MTU:                            1472,
 		Handler:                        handlerFunc,
+		Logger:                         NewNilLogger(),
 	}
 	return opts
 }
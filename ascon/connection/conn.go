@@ -5,12 +5,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keylog"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/revocation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/sessionstore"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
@@ -88,7 +94,41 @@ type Conn struct {
 	numOutstandingInteraction *semaphore.Weighted
 	receivedMessageReader     *client.ReceivedMessageReader[*Conn]
 
-	clientSecret []byte
+	hybridKeyExchange bool
+	logger            Logger
+	keyLogWriter      io.Writer
+
+	sessionStore     sessionstore.Store
+	sessionMasterKey []byte
+	sessionSaved     atomic.Bool
+
+	attester        attestation.Attester
+	reattestation   *Reattestation
+	reattesting     atomic.Bool
+	lastAttestation atomic.Int64
+	quarantined     atomic.Bool
+
+	attestInHandshake    bool
+	handshakeAttestation *HandshakeAttestation
+	handshakeAttested    atomic.Bool
+	handshakeRejected    atomic.Bool
+	// handshakeFinished is set when the finished flight of the session is verified
+	handshakeFinished atomic.Bool
+	auditLog          *attestation.AuditLog
+	revocations       *revocation.List
+	revocationVersion atomic.Uint64
+	groupAttestation  bool
+
+	keystore                  keystore.Keystore
+	pskIdentity               string
+	requirePeerAuthentication bool
+	authMutex                 sync.Mutex
+	transcript                []byte
+	pendingTranscript         []byte
+	peerIdentity              PeerIdentity
+	attestationResults        *cache.Cache[uint64, *attestation.AttestationResult]
+	attestationResultLifetime time.Duration
+	policyVersion             func() uint64
 }
 
 func processReceivedMessage(req *pool.Message, cc *Conn, handler config.HandlerFunc[*Conn]) {
@@ -113,6 +153,9 @@ func NewConn(
 	if cfg.GetToken == nil {
 		cfg.GetToken = message.GetToken
 	}
+	if cfg.Logger == nil {
+		cfg.Logger = NewNilLogger()
+	}
 	if cfg.ReceivedMessageQueueSize < 0 {
 		cfg.ReceivedMessageQueueSize = 0
 	}
@@ -134,8 +177,27 @@ func NewConn(
 		inactivityMonitor:         inactivityMonitor,
 		messagePool:               cfg.MessagePool,
 		numOutstandingInteraction: semaphore.NewWeighted(math.MaxInt64),
+		hybridKeyExchange:         cfg.HybridKeyExchange,
+		logger:                    cfg.Logger,
+		keyLogWriter:              cfg.KeyLogWriter,
+		sessionStore:              cfg.SessionStore,
+		sessionMasterKey:          cfg.SessionMasterKey,
+		attester:                  cfg.Attester,
+		reattestation:             cfg.Reattestation,
+		attestInHandshake:         cfg.AttestInHandshake,
+		handshakeAttestation:      cfg.HandshakeAttestation,
+		auditLog:                  cfg.AuditLog,
+		revocations:               cfg.Revocations,
+		groupAttestation:          cfg.GroupAttestation,
+		keystore:                  cfg.Keystore,
+		pskIdentity:               cfg.PSKIdentity,
+		requirePeerAuthentication: cfg.RequirePeerAuthentication,
+		attestationResults:        cache.NewCache[uint64, *attestation.AttestationResult](),
+		attestationResultLifetime: cfg.AttestationResultLifetime,
+		policyVersion:             cfg.PolicyVersion,
 	}
 	cc.msgID.Store(uint32(cfg.GetMID() - 0xffff/2))
+	cc.lastAttestation.Store(time.Now().UnixNano())
 	cc.blockWise = createBlockWise(&cc)
 	limitParallelRequests := limitparallelrequests.New(cfg.LimitClientParallelRequests, cfg.LimitClientEndpointParallelRequests, cc.do, cc.doObserve)
 	cc.observationHandler = observation.NewHandler(&cc, cfg.Handler, limitParallelRequests.Do)
@@ -144,6 +206,11 @@ func NewConn(
 		cc.processReceivedMessage = processReceivedMessage
 	}
 	cc.receivedMessageReader = client.NewReceivedMessageReader(&cc, cfg.ReceivedMessageQueueSize)
+	if cc.sessionStore != nil {
+		if err := cc.restoreSession(); err != nil {
+			cc.errors(fmt.Errorf("%v: cannot restore session: %w", cc.RemoteAddr(), err))
+		}
+	}
 
 	return &cc
 }
@@ -299,7 +366,7 @@ func (cc *Conn) getResponseFromCache(mid int32, resp *pool.Message) (bool, error
 	}
 	if rawMsg := cachedResp.Data(); len(rawMsg) > 0 {
 
-		_, err := resp.UnmarshalWithDecoder(coder.DefaultCoder.SetSecret(cc.clientSecret), rawMsg)
+		_, err := resp.UnmarshalWithDecoder(coder.DefaultCoder, rawMsg)
 		if err != nil {
 			return false, err
 		}
@@ -332,24 +399,45 @@ func (cc *Conn) handle(w *responsewriter.ResponseWriter[*Conn], m *pool.Message)
 	}
 	if cc.blockWise != nil {
 		cc.blockWise.Handle(w, m, cc.blockwiseSZX, cc.session.MaxMessageSize(), func(rw *responsewriter.ResponseWriter[*Conn], rm *pool.Message) {
+			if rm.Code() == codes.HANDSHAKE {
+				cc.handleHandshake(rw, rm)
+				return
+			}
+			if isProve(rm) {
+				cc.handleProve(rw, rm)
+				return
+			}
 			if h, ok := cc.tokenHandlerContainer.LoadAndDelete(rm.Token().Hash()); ok {
 				h(rw, rm)
 				return
 			}
+			if cc.rejectUnauthenticated(rw, rm) || cc.rejectQuarantined(rw, rm) {
+				return
+			}
 			cc.observationHandler.Handle(rw, rm)
 		})
 		return
 	}
+	if m.Code() == codes.HANDSHAKE {
+		cc.handleHandshake(w, m)
+		return
+	}
+	if isProve(m) {
+		cc.handleProve(w, m)
+		return
+	}
 	if h, ok := cc.tokenHandlerContainer.LoadAndDelete(m.Token().Hash()); ok {
 		h(w, m)
 		return
 	}
+	if cc.rejectUnauthenticated(w, m) || cc.rejectQuarantined(w, m) {
+		return
+	}
 	cc.observationHandler.Handle(w, m)
 }
 
 func (cc *Conn) addResponseToCache(resp *pool.Message) error {
-	fmt.Printf("addResponseToCache: ")
-	marshaledResp, err := resp.MarshalWithEncoder(coder.DefaultCoder.SetSecret(cc.clientSecret))
+	marshaledResp, err := resp.MarshalWithEncoder(coder.DefaultCoder)
 	if err != nil {
 		return err
 	}
@@ -482,51 +570,91 @@ func (cc *Conn) handlePong(w *responsewriter.ResponseWriter[*Conn], r *pool.Mess
 	}
 }
 
+func (cc *Conn) handleHandshake(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
+	if isClientFinished(r) {
+		cc.handleClientFinished(w, r)
+		return
+	}
+	cc.handleClientHello(w, r)
+}
+
 func (cc *Conn) handleClientHello(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
-	fmt.Println("\nServer Handshake")
+	clientShare, err := r.ReadBody()
+	if err != nil {
+		cc.rejectHandshake(w, codes.BadRequest, fmt.Errorf("cannot read client hello: %w", err))
+		return
+	}
 
-	clientPublicKey, err := r.ReadBody()
+	var serverShare []byte
+	var sessionKey *coder.SecretKey
+	code := codes.Empty
+	switch len(clientShare) {
+	case coder.X25519ShareSize:
+		if cc.hybridKeyExchange {
+			cc.rejectHandshake(w, codes.Unauthorized, errors.New("client hello without hybrid key share"))
+			return
+		}
+		serverShare, sessionKey, err = coder.RespondX25519(clientShare)
+	case coder.HybridClientShareSize:
+		// the hybrid server hello doesn't fit into one block, so it is sent as content which can be transferred blockwise
+		code = codes.Content
+		serverShare, sessionKey, err = coder.RespondHybrid(clientShare)
+	default:
+		err = coder.ErrInvalidKeyShare
+	}
 	if err != nil {
-		cc.errors(fmt.Errorf("Could not read key"))
-		fmt.Println("Could not read key")
+		cc.rejectHandshake(w, codes.BadRequest, fmt.Errorf("cannot compute server hello: %w", err))
+		return
 	}
 
-	serverPrivateKey := coder.RandomBytes(32)
-	serverPublicKey := coder.ComputePublicKey(serverPrivateKey)
+	cc.logger.Debugf("%v: handshake: client public %X, server public %X", cc.RemoteAddr(), clientShare[:coder.X25519ShareSize], serverShare[:coder.X25519ShareSize])
+
+	// the key is activated by the first message of the client which authenticates under it,
+	// so the server hello (and its blocks) is sent under the current session key or unencrypted;
+	// the current session stays until then
+	transcript := coder.Transcript(clientShare, serverShare)
+	cc.writeKeyLog(transcript, sessionKey)
+	cc.setPendingTranscript(transcript)
+	cc.session.Coder().SetPendingSecret(sessionKey, cc.activatePendingSession)
 
-	fmt.Printf("Length: %d\n", len(serverPublicKey))
-	err = w.SetResponse(codes.Empty, message.TextPlain, bytes.NewReader(serverPublicKey))
+	err = w.SetResponse(code, message.AppOctets, bytes.NewReader(serverShare))
 	if err != nil {
 		cc.errors(fmt.Errorf("cannot send server hello: %w", err))
-		fmt.Println("cannot send server hello")
 	}
+}
 
-	sharedKey, err := coder.DeriveSharedKey(serverPrivateKey, clientPublicKey)
-	if err != nil {
-		cc.errors(fmt.Errorf("Could not compute shared key: %w\n", err))
-		fmt.Println("Could not compute shared key")
-
+func (cc *Conn) rejectHandshake(w *responsewriter.ResponseWriter[*Conn], code codes.Code, err error) {
+	cc.errors(fmt.Errorf("%v: handshake: %w", cc.RemoteAddr(), err))
+	if errS := w.SetResponse(code, message.TextPlain, nil); errS != nil {
+		cc.errors(fmt.Errorf("cannot reject handshake: %w", errS))
 	}
+}
 
-	fmt.Printf("Client public %X\n", clientPublicKey)
-	fmt.Printf("Server public %X\n", serverPublicKey)
-	fmt.Printf("Shared key: %X\n", sharedKey)
-
-	// save shared secret
-	cc.session.SetServerSecret(sharedKey[:16]) // still send response unencrypted
-
-	w.Message().SetCode(codes.Empty)
-	w.Message().SetMessageID(r.MessageID())
-	w.Message().SetType(message.Acknowledgement)
-	w.Message().SetToken(r.Token())
+func isClientHello(r *pool.Message) bool {
+	if r.Code() != codes.HANDSHAKE || r.Type() != message.Confirmable || len(r.Options()) != 0 {
+		return false
+	}
+	size, _ := r.BodySize()
+	return size == int64(coder.X25519ShareSize) || size == int64(coder.HybridClientShareSize)
 }
 
 func (cc *Conn) handleSpecialMessages(r *pool.Message) bool {
 
-	// Client Hello
-	size, _ := r.BodySize()
-	if r.Code() == codes.HANDSHAKE && r.Type() == message.Confirmable && len(r.Options()) == 0 && size == int64(32) {
-		cc.ProcessReceivedMessageWithHandler(r, cc.handleClientHello)
+	// Client Hello which fits into one datagram, blockwise client hellos are handled by cc.handle
+	if isClientHello(r) {
+		cc.ProcessReceivedMessageWithHandler(r, func(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
+			cc.handleClientHello(w, r)
+			// piggyback the server hello on the acknowledgement
+			w.Message().SetMessageID(r.MessageID())
+			w.Message().SetType(message.Acknowledgement)
+		})
+		return true
+	}
+
+	// attestation challenge, it is answered by the connection instead of the handler; the quote
+	// can take a while, so it doesn't block reading
+	if isProve(r) {
+		go cc.ProcessReceivedMessageWithHandler(r, cc.handleReq)
 		return true
 	}
 
@@ -562,8 +690,14 @@ func (cc *Conn) Process(datagram []byte) error {
 		return fmt.Errorf("max message size(%v) was exceeded %v", cc.session.MaxMessageSize(), len(datagram))
 	}
 	req := cc.AcquireMessage(cc.Context())
-	_, err := req.UnmarshalWithDecoder(coder.DefaultCoder.SetSecret(cc.clientSecret), datagram)
+	_, err := req.UnmarshalWithDecoder(cc.session.Coder(), datagram)
 
+	if errors.Is(err, coder.ErrMessageAuthentication) {
+		// anybody can send the datagram, so it doesn't affect the session
+		cc.ReleaseMessage(req)
+		cc.errors(fmt.Errorf("%v: datagram dropped: %w", cc.RemoteAddr(), err))
+		return nil
+	}
 	if err != nil {
 		cc.ReleaseMessage(req)
 		return err
@@ -572,6 +706,7 @@ func (cc *Conn) Process(datagram []byte) error {
 	req.SetSequence(cc.Sequence())
 	cc.checkMyMessageID(req)
 	cc.inactivityMonitor.Notify()
+	cc.saveEstablishedSession()
 	if cc.handleSpecialMessages(req) {
 		return nil
 	}
@@ -666,7 +801,6 @@ func (cc *Conn) writeMessage(req *pool.Message) error {
 		return fmt.Errorf(errFmtWriteRequest, err)
 	}
 	if err := cc.waitForAcknowledge(req, respChan); err != nil {
-		fmt.Println("No ack")
 		return fmt.Errorf(errFmtWriteRequest, err)
 	}
 	return nil
@@ -706,7 +840,6 @@ func (cc *Conn) doInternal(req *pool.Message) (*pool.Message, error) {
 	}()
 	err := cc.writeMessage(req)
 	if err != nil {
-		fmt.Println("Could not write message")
 		// cc.errors(fmt.Errorf(errFmtWriteRequest, err))
 		return nil, fmt.Errorf(errFmtWriteRequest, err)
 	}
@@ -776,8 +909,16 @@ func (cc *Conn) checkMidHandlerContainer(now time.Time, maxRetransmit int32, ack
 
 // CheckExpirations checks and remove expired items from caches.
 func (cc *Conn) CheckExpirations(now time.Time) {
+	if cc.handshakeRejected.Load() || cc.isRevoked() {
+		if err := cc.Close(); err != nil {
+			cc.errors(fmt.Errorf("cannot close connection: %w", err))
+		}
+		return
+	}
 	cc.inactivityMonitor.CheckInactivity(now, cc)
 	cc.responseMsgCache.CheckExpirations(now)
+	cc.checkAttestationResults(now)
+	cc.checkReattestation(now)
 	if cc.blockWise != nil {
 		cc.blockWise.CheckExpirations(now)
 	}
@@ -824,6 +965,24 @@ func (cc *Conn) NetConn() net.Conn {
 	return cc.session.NetConn()
 }
 
-func (cc *Conn) SetClientSecret(secret []byte) {
-	cc.clientSecret = secret
+// SetClientSecret activates the session key of the client handshake, the conn takes ownership of the key.
+// The transcript hash of the key exchange identifies the session.
+func (cc *Conn) SetClientSecret(transcript []byte, secret *coder.SecretKey) {
+	cc.writeKeyLog(transcript, secret)
+	cc.setTranscript(transcript)
+	cc.session.Coder().SetSecret(secret)
+}
+
+func (cc *Conn) writeKeyLog(transcript []byte, secret *coder.SecretKey) {
+	if cc.keyLogWriter == nil {
+		return
+	}
+	if err := keylog.WriteSessionKey(cc.keyLogWriter, transcript, secret.Bytes()); err != nil {
+		cc.errors(fmt.Errorf("cannot write key log: %w", err))
+	}
+}
+
+// Logger returns the logger of the connection.
+func (cc *Conn) Logger() Logger {
+	return cc.logger
 }
@@ -0,0 +1,76 @@
+package connection
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+)
+
+var errMissingEvidence = errors.New("missing evidence")
+
+// HandshakeAttestation appraises the evidence which the peer sends in the handshake. The nonce of
+// the evidence is derived from the transcript and the finished label of the peer, and the evidence is bound to the session key.
+// The client requests the evidence of the server, so the server proves its measurements before Dial returns.
+type HandshakeAttestation struct {
+	Verifier *attestation.Verifier
+	// Device selects the reference values of the peer. The zero Device is used when it is nil.
+	Device func(cc *Conn) attestation.Device
+	// AllowWarning accepts results with the warning status.
+	AllowWarning bool
+}
+
+// handshakeEvidence quotes the attester for the nonce of the transcript. The label separates the
+// nonces of the client and the server, so the evidence can't be reflected.
+func (cc *Conn) handshakeEvidence(transcript []byte, label string) ([]byte, error) {
+	nonce, err := attestation.HandshakeNonce(signedContent(transcript, label))
+	if err != nil {
+		return nil, err
+	}
+	format, body, err := cc.newProof(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create handshake evidence: %w", err)
+	}
+	if len(body)+2 > math.MaxUint16 {
+		return nil, fmt.Errorf("handshake evidence exceeds %v bytes", math.MaxUint16)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(format)), body...), nil
+}
+
+// verifyHandshakeEvidence appraises the evidence of the finished flight and stores the result.
+func (cc *Conn) verifyHandshakeEvidence(f finished, transcript []byte, label string) error {
+	h := cc.handshakeAttestation
+	if len(f.evidence) < 2 {
+		return errMissingEvidence
+	}
+	evidence, err := attestation.DecodeEvidence(message.MediaType(binary.BigEndian.Uint16(f.evidence)), f.evidence[2:])
+	if err != nil {
+		return err
+	}
+	nonce, err := attestation.HandshakeNonce(signedContent(transcript, label))
+	if err != nil {
+		return err
+	}
+	bindingKey, err := cc.bindingKey()
+	if err != nil {
+		return err
+	}
+	defer coder.Wipe(bindingKey)
+	device := attestation.Device{}
+	if h.Device != nil {
+		device = h.Device(cc)
+	}
+	result := h.Verifier.VerifyNonce(device, &evidence, bindingKey, nonce)
+	cc.checkRevokedResult(result)
+	cc.setAttestationResult(result)
+	cc.auditVerdict("handshake", nonce, evidence.Marshal(), result)
+	if result.Status == attestation.StatusAffirming || (h.AllowWarning && result.Status == attestation.StatusWarning) {
+		return nil
+	}
+	return fmt.Errorf("evidence is %v: %v", result.Status, strings.Join(result.Reasons, "; "))
+}
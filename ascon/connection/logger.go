@@ -0,0 +1,24 @@
+package connection
+
+// Logger receives diagnostics of the ASCON transport. Key material is never passed to it.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// LoggerFunc adapts a printf like function, e.g. log.Printf, to Logger.
+type LoggerFunc func(format string, args ...interface{})
+
+func (f LoggerFunc) Debugf(format string, args ...interface{}) {
+	f(format, args...)
+}
+
+type nilLogger struct{}
+
+func (nilLogger) Debugf(string, ...interface{}) {
+	// default no-op
+}
+
+// NewNilLogger creates a logger which drops all diagnostics.
+func NewNilLogger() Logger {
+	return nilLogger{}
+}
@@ -0,0 +1,59 @@
+package connection
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+)
+
+// PassportSubject identifies the peer in the passports: the identity key which signed the handshake,
+// or the pre-shared key identity. It is empty when the peer didn't prove any identity.
+func (p PeerIdentity) PassportSubject() string {
+	switch {
+	case p.PublicKey != nil:
+		return "ed25519:" + hex.EncodeToString(p.PublicKey)
+	case p.PSKIdentity != "":
+		return "psk:" + p.PSKIdentity
+	}
+	return ""
+}
+
+// VerifyPassport checks that the passport is signed by one of the PKIX public keys of the verifiers,
+// it is valid, and it was issued to the identity of the peer. The result of the passport is stored as
+// the attestation result of the connection.
+func (cc *Conn) VerifyPassport(verifierKeys [][]byte, data []byte) (*attestation.AttestationResult, error) {
+	result, err := cc.verifyPassport(verifierKeys, data)
+	if err != nil {
+		result = &attestation.AttestationResult{Status: attestation.StatusContraindicated, Reasons: []string{err.Error()}, Time: time.Now()}
+		cc.auditVerdict("passport", nil, data, result)
+		return nil, err
+	}
+	cc.checkRevokedResult(result)
+	cc.setAttestationResult(result)
+	cc.auditVerdict("passport", nil, data, result)
+	return result, nil
+}
+
+func (cc *Conn) verifyPassport(verifierKeys [][]byte, data []byte) (*attestation.AttestationResult, error) {
+	p, err := attestation.ParsePassport(data)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	err = attestation.ErrInvalidToken
+	for _, key := range verifierKeys {
+		if err = p.Verify(key, now); err == nil || errors.Is(err, attestation.ErrPassportExpired) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subject := cc.PeerIdentity().PassportSubject(); subject == "" || subject != p.Claims.Subject {
+		return nil, fmt.Errorf("passport was issued to %q", p.Claims.Subject)
+	}
+	return p.Result(), nil
+}
@@ -0,0 +1,150 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+)
+
+// DefaultReattestationInterval is the interval of the re-attestation when it is not set.
+const DefaultReattestationInterval = time.Hour
+
+// ReattestationAction is run when the re-attestation of the peer fails.
+type ReattestationAction int
+
+const (
+	// ReattestationClose closes the connection.
+	ReattestationClose ReattestationAction = iota
+	// ReattestationQuarantine restricts the peer to GET requests, until it attests successfully again.
+	ReattestationQuarantine
+	// ReattestationCallback only calls OnFailure.
+	ReattestationCallback
+)
+
+// Reattestation attests the peer periodically from CheckExpirations.
+type Reattestation struct {
+	Verifier *attestation.Verifier
+	// Device selects the reference values of the peer. The zero Device is used when it is nil.
+	Device func(cc *Conn) attestation.Device
+	// Interval between the attestations, DefaultReattestationInterval when it is not set. The last
+	// attestation result of the connection, e.g. by a middleware, postpones the re-attestation.
+	Interval time.Duration
+	// Timeout of the PROVE exchange, the interval when it is not set.
+	Timeout time.Duration
+	// AllowWarning accepts results with the warning status.
+	AllowWarning bool
+	Action       ReattestationAction
+	// OnFailure is called for every failed re-attestation, with the result or the error.
+	OnFailure func(cc *Conn, result *attestation.AttestationResult, err error)
+}
+
+func (r *Reattestation) interval() time.Duration {
+	if r.Interval <= 0 {
+		return DefaultReattestationInterval
+	}
+	return r.Interval
+}
+
+func (r *Reattestation) accepts(result *attestation.AttestationResult) bool {
+	return result.Status == attestation.StatusAffirming || (r.AllowWarning && result.Status == attestation.StatusWarning)
+}
+
+// Quarantined reports whether the peer failed the re-attestation and is restricted to GET requests.
+func (cc *Conn) Quarantined() bool {
+	return cc.quarantined.Load()
+}
+
+// SetQuarantined restricts the peer to GET requests or lifts the restriction.
+func (cc *Conn) SetQuarantined(quarantined bool) {
+	cc.quarantined.Store(quarantined)
+}
+
+// isRequest reports whether the code is a request code other than the handshake, including the codes
+// which are unknown to the library.
+func isRequest(code codes.Code) bool {
+	return code != codes.Empty && code>>5 == 0 && code != codes.HANDSHAKE
+}
+
+// liftsQuarantine reports whether the attestation result, of any appraisal of the peer, lifts the quarantine.
+func (cc *Conn) liftsQuarantine(result *attestation.AttestationResult) bool {
+	if cc.reattestation != nil {
+		return cc.reattestation.accepts(result)
+	}
+	return result.Status == attestation.StatusAffirming
+}
+
+// rejectQuarantined answers requests with 4.03 when the peer is quarantined, except GET and PROOF,
+// by which the peer attests again.
+func (cc *Conn) rejectQuarantined(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) bool {
+	if !cc.Quarantined() || !isRequest(r.Code()) || r.Code() == codes.GET || r.Code() == codes.PROOF {
+		return false
+	}
+	if err := w.SetResponse(codes.Forbidden, message.TextPlain, nil); err != nil {
+		cc.errors(fmt.Errorf("cannot reject request: %w", err))
+	}
+	return true
+}
+
+// checkReattestation starts the re-attestation when it is due.
+func (cc *Conn) checkReattestation(now time.Time) {
+	r := cc.reattestation
+	if r == nil || !cc.session.Coder().IsEstablished() {
+		return
+	}
+	last := time.Unix(0, cc.lastAttestation.Load())
+	if result := cc.AttestationResult(); result != nil && result.Time.After(last) {
+		last = result.Time
+	}
+	if now.Sub(last) < r.interval() || !cc.reattesting.CompareAndSwap(false, true) {
+		return
+	}
+	cc.lastAttestation.Store(now.UnixNano())
+	go func() {
+		defer cc.reattesting.Store(false)
+		cc.reattest(r)
+	}()
+}
+
+func (cc *Conn) reattest(r *Reattestation) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = r.interval()
+	}
+	ctx, cancel := context.WithTimeout(cc.Context(), timeout)
+	defer cancel()
+	device := attestation.Device{}
+	if r.Device != nil {
+		device = r.Device(cc)
+	}
+	result, err := cc.AttestWithVerifier(ctx, r.Verifier, device)
+	if err == nil && r.accepts(result) {
+		// the result lifted the quarantine
+		return
+	}
+	if cc.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		cc.logger.Debugf("%v: re-attestation failed: %v", cc.RemoteAddr(), err)
+	} else {
+		cc.logger.Debugf("%v: re-attestation %v: %v", cc.RemoteAddr(), result.Status, result.Reasons)
+	}
+	if r.OnFailure != nil {
+		r.OnFailure(cc, result, err)
+	}
+	switch r.Action {
+	case ReattestationClose:
+		if errC := cc.Close(); errC != nil {
+			cc.errors(fmt.Errorf("cannot close connection: %w", errC))
+		}
+	case ReattestationQuarantine:
+		cc.SetQuarantined(true)
+	case ReattestationCallback:
+	}
+}
@@ -0,0 +1,104 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/sessionstore"
+)
+
+// messageIDGap skips the message IDs which the server may have used after the state was saved.
+const messageIDGap = 1 << 10
+
+// SaveSession writes the state of the session to the session store. It does nothing without
+// a session store or before the key exchange.
+func (cc *Conn) SaveSession() error {
+	if cc.sessionStore == nil {
+		return nil
+	}
+	key, established := cc.session.Coder().SessionKey()
+	if key == nil {
+		return nil
+	}
+	sessionID := cc.Transcript()
+	if !established {
+		sessionID = cc.getPendingTranscript()
+	}
+	peer := cc.PeerIdentity()
+	state := sessionstore.State{
+		Address:         cc.RemoteAddr().String(),
+		SessionID:       sessionID,
+		Key:             key,
+		Established:     established,
+		MessageID:       cc.msgID.Load(),
+		PeerPSKIdentity: peer.PSKIdentity,
+		PeerPublicKey:   peer.PublicKey,
+		PeerTrustAnchor: peer.TrustAnchor,
+		PeerAttested:    cc.handshakeAttested.Load(),
+	}
+	defer state.Wipe()
+	sealed, err := sessionstore.Seal(cc.sessionMasterKey, state)
+	if err != nil {
+		return fmt.Errorf("cannot seal session: %w", err)
+	}
+	if err = cc.sessionStore.Save(state.Address, sealed, time.Now().Add(sessionstore.DefaultLifetime)); err != nil {
+		return err
+	}
+	cc.sessionSaved.Store(established)
+	return nil
+}
+
+// saveEstablishedSession saves the session once the peer proved possession of the session key.
+func (cc *Conn) saveEstablishedSession() {
+	if cc.sessionStore == nil || cc.sessionSaved.Load() || !cc.session.Coder().IsEstablished() {
+		return
+	}
+	if cc.requiresFinished() && !cc.handshakeFinished.Load() {
+		// the session is saved by the verified finished flight
+		return
+	}
+	if err := cc.SaveSession(); err != nil {
+		cc.errors(fmt.Errorf("%v: cannot save session: %w", cc.RemoteAddr(), err))
+	}
+}
+
+// restoreSession resumes the session of the peer from the session store.
+func (cc *Conn) restoreSession() error {
+	address := cc.RemoteAddr().String()
+	sealed, err := cc.sessionStore.Load(address)
+	if errors.Is(err, sessionstore.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state, err := sessionstore.Open(cc.sessionMasterKey, address, sealed)
+	if err != nil {
+		return err
+	}
+	// the coder takes ownership of the key
+	key := coder.NewSecretKey(state.Key)
+	if !state.Established {
+		cc.setPendingTranscript(state.SessionID)
+		cc.session.Coder().SetPendingSecret(key, cc.activatePendingSession)
+		cc.msgID.Store(state.MessageID + messageIDGap)
+		cc.logger.Debugf("%v: resumed pending session %x", address, state.SessionID)
+		return nil
+	}
+	cc.session.Coder().SetSecret(key)
+	cc.setTranscript(state.SessionID)
+	cc.setPeerIdentity(PeerIdentity{
+		PSKIdentity: state.PeerPSKIdentity,
+		PublicKey:   state.PeerPublicKey,
+		TrustAnchor: state.PeerTrustAnchor,
+	})
+	cc.handshakeAttested.Store(state.PeerAttested)
+	// the established session is saved with the identity of the peer once the finished flight is verified
+	cc.handshakeFinished.Store(state.PeerAttested || cc.PeerIdentity().Authenticated())
+	cc.msgID.Store(state.MessageID + messageIDGap)
+	cc.sessionSaved.Store(state.Established)
+	cc.logger.Debugf("%v: resumed session %x", address, state.SessionID)
+	return nil
+}
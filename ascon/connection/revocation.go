@@ -0,0 +1,55 @@
+package connection
+
+import (
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+)
+
+// checkRevoked returns the error when the identity or the attestation result of the peer is revoked.
+func (cc *Conn) checkRevoked() error {
+	if cc.revocations == nil {
+		return nil
+	}
+	identity := cc.PeerIdentity()
+	if identity.PublicKey != nil {
+		if err := cc.revocations.CheckKey(identity.PublicKey); err != nil {
+			return err
+		}
+	}
+	if identity.PSKIdentity != "" {
+		if err := cc.revocations.CheckDeviceID(identity.PSKIdentity); err != nil {
+			return err
+		}
+	}
+	return cc.revocations.CheckResult(cc.AttestationResult())
+}
+
+// checkRevokedResult makes the result contraindicated when it, or the identity of the peer, is revoked.
+func (cc *Conn) checkRevokedResult(result *attestation.AttestationResult) {
+	if cc.revocations == nil {
+		return
+	}
+	err := cc.revocations.CheckResult(result)
+	if err == nil {
+		err = cc.checkRevoked()
+	}
+	if err != nil {
+		result.Status = attestation.StatusContraindicated
+		result.Reasons = append(result.Reasons, err.Error())
+	}
+}
+
+// isRevoked checks the peer again when the revocation list changed.
+func (cc *Conn) isRevoked() bool {
+	if cc.revocations == nil {
+		return false
+	}
+	version := cc.revocations.Version()
+	if cc.revocationVersion.Swap(version) == version {
+		return false
+	}
+	if err := cc.checkRevoked(); err != nil {
+		cc.logger.Debugf("%v: closing revoked session: %v", cc.RemoteAddr(), err)
+		return true
+	}
+	return false
+}
@@ -29,7 +29,8 @@ type ISession interface {
 	AddOnClose(f EventFunc)
 	SetContextValue(key interface{}, val interface{})
 	Done() <-chan struct{}
-	SetServerSecret(secret []byte)
+	// Coder returns the coder which holds the keys of the session.
+	Coder() *coder.Coder
 }
 
 type Session struct {
@@ -48,8 +49,7 @@ type Session struct {
 	maxMessageSize uint32
 	mtu            uint16
 
-	serverSecret  []byte
-	isSecretReady bool
+	coder *coder.Coder
 
 	closeSocket bool
 }
@@ -75,14 +75,14 @@ func NewSession(
 		closeSocket:    closeSocket,
 		doneCtx:        doneCtx,
 		doneCancel:     doneCancel,
-		isSecretReady:  false,
+		coder:          coder.NewCoder(),
 	}
 	s.ctx.Store(&ctx)
 	return s
 }
 
-func (s *Session) SetServerSecret(secret []byte) {
-	s.serverSecret = secret
+func (s *Session) Coder() *coder.Coder {
+	return s.coder
 }
 
 func (s *Session) popOnClose() []EventFunc {
@@ -106,8 +106,10 @@ func (s *Session) AddOnClose(f EventFunc) {
 	s.onClose = append(s.onClose, f)
 }
 
+// Close cancels the session and wipes its session keys.
 func (s *Session) Close() error {
 	s.cancel()
+	s.coder.Close()
 	if s.closeSocket {
 		return s.connection.Close()
 	}
@@ -169,23 +171,8 @@ func (s *Session) SetContextValue(key interface{}, val interface{}) {
 	s.ctx.Store(&ctx)
 }
 
-func (s *Session) resolveCoder() *coder.Coder {
-	cdr := coder.DefaultCoder
-	if s.serverSecret == nil {
-		return cdr
-	}
-
-	if s.isSecretReady {
-		cdr.SetSecret(s.serverSecret)
-	} else {
-		s.isSecretReady = true
-	}
-
-	return cdr
-}
-
 func (s *Session) WriteMessage(req *pool.Message) error {
-	data, err := req.MarshalWithEncoder(s.resolveCoder())
+	data, err := req.MarshalWithEncoder(s.coder)
 	if err != nil {
 		return fmt.Errorf("cannot marshal: %w", err)
 	}
@@ -196,8 +183,7 @@ func (s *Session) WriteMessage(req *pool.Message) error {
 // By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
 // Via opts you can specify the network interface, source IP address, and hop limit.
 func (s *Session) WriteMulticastMessage(req *pool.Message, address *net.UDPAddr, opts ...coapNet.MulticastOption) error {
-	fmt.Printf("WriteMulticastMessage: ")
-	data, err := req.MarshalWithEncoder(s.resolveCoder())
+	data, err := req.MarshalWithEncoder(s.coder)
 	if err != nil {
 		return fmt.Errorf("cannot marshal: %w", err)
 	}
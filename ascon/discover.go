@@ -0,0 +1,119 @@
+package ascon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/discovery"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+)
+
+// The ASCON server is still a fork of udp/server and isn't layered on its codec and interceptor hooks
+// (options.WithCodec, options.WithInterceptor) yet: they serve udp/client.Conn, which has no place for the state
+// of an ASCON session. Until the server migrates, the requests of Discover and their responses are handled by
+// the discovery package like udp/server does, and only the handshake with the devices which respond is its own.
+
+// Discover sends GET to multicast or unicast address and waits for responses until context timeouts or server shutdown.
+// The request and the responses are sent in plaintext, because the devices don't share a session key. The server
+// handshakes with every device which responds, as NewConn does, and passes the first response of the device
+// to receiverFunc only when the handshake succeeds; the failed handshakes are reported by the errors of the server.
+// By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
+// Via opts you can specify the network interface, source IP address, and hop limit.
+func (s *Server) Discover(ctx context.Context, address, path string, receiverFunc func(cc *connection.Conn, resp *pool.Message), opts ...coapNet.MulticastOption) error {
+	return discovery.Discover(ctx, s.cfg.MessagePool, s.cfg.GetToken, s.cfg.GetMID, path, func(req *pool.Message) error {
+		return s.DiscoveryRequest(req, address, receiverFunc, opts...)
+	})
+}
+
+// DiscoveryRequest sends request to multicast/unicast address and wait for responses until request timeouts or server shutdown.
+// Like Discover, it handshakes with every device which responds before the response is passed to receiverFunc.
+// By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
+// Via opts you can specify the network interface, source IP address, and hop limit.
+func (s *Server) DiscoveryRequest(req *pool.Message, address string, receiverFunc func(cc *connection.Conn, resp *pool.Message), opts ...coapNet.MulticastOption) error {
+	l := s.getListener()
+	if l == nil {
+		return errors.New("server doesn't serve connection")
+	}
+	addr, err := discovery.Resolve(req, l.Network(), address)
+	if err != nil {
+		return err
+	}
+
+	var mutex sync.Mutex
+	responded := make(map[string]struct{})
+	finished := false
+	// the handshakes which are in progress when the request timeouts finish before DiscoveryRequest returns
+	var wg sync.WaitGroup
+	defer func() {
+		mutex.Lock()
+		finished = true
+		mutex.Unlock()
+		wg.Wait()
+	}()
+	unregister, err := discovery.Register(s.multicastRequests, s.multicastHandler, req, func(w *responsewriter.ResponseWriter[*connection.Conn], r *pool.Message) {
+		cc := w.Conn()
+		key := cc.RemoteAddr().String()
+		mutex.Lock()
+		_, ok := responded[key]
+		if ok || finished {
+			// duplicate of the response or a late one
+			mutex.Unlock()
+			return
+		}
+		responded[key] = struct{}{}
+		wg.Add(1)
+		mutex.Unlock()
+		defer wg.Done()
+		if errH := s.handshake(cc); errH != nil {
+			s.cfg.Errors(fmt.Errorf("%v: discovery: %w", key, errH))
+			return
+		}
+		receiverFunc(cc, r)
+	})
+	if err != nil {
+		return err
+	}
+	defer unregister()
+
+	if err = s.writePlaintext(req.Context(), l, addr, req, opts...); err != nil {
+		return err
+	}
+	return discovery.Wait(s.ctx, req)
+}
+
+// NewConn creates the connection to the device at addr over the listener of the server and handshakes with it:
+// the server sends the client hello and authenticates by its keystore and attester as a client would.
+// When the connection to addr already has a session, it is returned without a new handshake.
+func (s *Server) NewConn(addr *net.UDPAddr) (*connection.Conn, error) {
+	l := s.getListener()
+	if l == nil {
+		// server is not started/stopped
+		return nil, errors.New("server is not running")
+	}
+	cc, err := s.getConn(l, addr, true)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.handshake(cc); err != nil {
+		return nil, err
+	}
+	return cc, nil
+}
+
+// handshake starts the session of the connection, unless it has one.
+func (s *Server) handshake(cc *connection.Conn) error {
+	if cc.Transcript() != nil {
+		return nil
+	}
+	if err := handshake(cc, s.cfg.HybridKeyExchange); err != nil {
+		s.closeConnection(cc)
+		return fmt.Errorf("cannot handshake: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDiscoverAndNewConn(t *testing.T) {
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	serveListener := func(l *coapNet.UDPConn, opts ...ServerOption) *Server {
+		s := NewServer(opts...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errS := s.Serve(l)
+			require.NoError(t, errS)
+		}()
+		t.Cleanup(func() {
+			s.Stop()
+			errC := l.Close()
+			require.NoError(t, errC)
+		})
+		<-s.serverStartedChan
+		return s
+	}
+	listen := func(addr string) *coapNet.UDPConn {
+		l, errL := coapNet.NewListenUDP("udp4", addr)
+		require.NoError(t, errL)
+		return l
+	}
+
+	m := mux.NewRouter()
+	err := m.Handle("/res", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("device")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+
+	multicastAddr := "224.0.1.187:9899"
+	ml := listen(multicastAddr)
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	a, err := net.ResolveUDPAddr("udp4", multicastAddr)
+	require.NoError(t, err)
+	for i := range ifaces {
+		iface := ifaces[i]
+		if errJ := ml.JoinGroup(&iface, a); errJ != nil {
+			t.Logf("cannot JoinGroup(%v, %v): %v", iface, a, errJ)
+		}
+	}
+	require.NoError(t, ml.SetMulticastLoopback(true))
+	serveListener(ml, options.WithMux(m))
+
+	s := serveListener(listen(""))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	get := func(cc *connection.Conn) {
+		resp, errG := cc.Get(ctx, "/res")
+		require.NoError(t, errG)
+		require.Equal(t, codes.Content, resp.Code())
+		body, errR := resp.ReadBody()
+		require.NoError(t, errR)
+		require.Equal(t, []byte("device"), body)
+	}
+
+	var mutex sync.Mutex
+	var discovered []*connection.Conn
+	discoverCtx, discoverCancel := context.WithTimeout(ctx, time.Millisecond*500)
+	defer discoverCancel()
+	err = s.Discover(discoverCtx, multicastAddr, "/res", func(cc *connection.Conn, resp *pool.Message) {
+		require.Equal(t, codes.Content, resp.Code())
+		mutex.Lock()
+		defer mutex.Unlock()
+		discovered = append(discovered, cc)
+	})
+	require.NoError(t, err)
+	require.Len(t, discovered, 1)
+	require.NotNil(t, discovered[0].Transcript())
+	get(discovered[0])
+
+	// the connection of the discovered device keeps its session
+	addr, err := net.ResolveUDPAddr("udp4", discovered[0].RemoteAddr().String())
+	require.NoError(t, err)
+	cc, err := s.NewConn(addr)
+	require.NoError(t, err)
+	require.Equal(t, discovered[0].Transcript(), cc.Transcript())
+
+	l := listen("127.0.0.1:")
+	serveListener(l, options.WithMux(m))
+	addr, err = net.ResolveUDPAddr("udp4", l.LocalAddr().String())
+	require.NoError(t, err)
+	cc, err = s.NewConn(addr)
+	require.NoError(t, err)
+	require.NotNil(t, cc.Transcript())
+	get(cc)
+
+	// nobody listens at the address, so the handshake fails
+	_, err = s.NewConn(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	require.Error(t, err)
+}
@@ -0,0 +1,359 @@
+package enrollment
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+)
+
+const (
+	pskBytes = 32
+	// DefaultCertificateLifetime is the validity of the certificates of the identity keys.
+	DefaultCertificateLifetime = 365 * 24 * time.Hour
+)
+
+// Enroller is the enrollment endpoint. It records the enrolled devices in the Store, adds their identity keys
+// as trust anchors and their pre-shared keys to the Keystore of the server, and their golden values to References.
+// The golden values and attestation keys are kept per device, selected by the fingerprint of its identity key, so one
+// device doesn't change the reference of its class and firmware version.
+type Enroller struct {
+	Store    Store
+	Keystore keystore.Keystore
+	// References receives the golden values of the enrolled devices, it is optional. Use Device to select them.
+	References *attestation.ReferenceValues
+	// Policy approves the devices, every device waits for Approve when it is nil.
+	Policy Policy
+	// IssuePSK provisions a pre-shared key which is named by the fingerprint of the identity key.
+	IssuePSK bool
+	// CACertificate and CAKey sign the certificates of the identity keys when they are set.
+	CACertificate       *x509.Certificate
+	CAKey               crypto.Signer
+	CertificateLifetime time.Duration
+
+	mutex sync.Mutex
+}
+
+// Approve approves the pending record of the fingerprint. The device enrolls on its next request, when it
+// presents the same measurements.
+func (e *Enroller) Approve(fingerprint string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	r, err := e.Store.Load(fingerprint)
+	if err != nil {
+		return err
+	}
+	if r.Status != StatusPending {
+		return fmt.Errorf("cannot approve %v record", r.Status)
+	}
+	r.Status = StatusApproved
+	return e.Store.Save(r)
+}
+
+func (e *Enroller) approve(r *Record, previous Record) error {
+	switch previous.Status {
+	case StatusEnrolled:
+		return ErrAlreadyEnrolled
+	case StatusApproved:
+		if previous.Reference == nil || r.Reference == nil || len(previous.Reference.PCRs) == 0 || len(r.Reference.PCRs) == 0 ||
+			!bytes.Equal(previous.Reference.PCRs[0], r.Reference.PCRs[0]) {
+			return errors.New("measurements differ from the approved ones")
+		}
+		return nil
+	}
+	if e.Policy == nil {
+		return ErrPending
+	}
+	return e.Policy(r)
+}
+
+// ServeCOAP enrolls the device. It answers 2.01 Created with the credentials, 5.03 Service Unavailable while
+// the enrollment is pending, 4.03 Forbidden when the device is rejected and 4.01 Unauthorized when the device
+// doesn't prove its identity key or its measurements.
+func (e *Enroller) ServeCOAP(w mux.ResponseWriter, r *mux.Message) {
+	cc, ok := w.Conn().(*connection.Conn)
+	if !ok {
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	if r.Code() != codes.POST {
+		_ = w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+		return
+	}
+	publicKey := cc.PeerIdentity().PublicKey
+	if publicKey == nil {
+		cc.Logger().Debugf("%v: cannot enroll: missing identity key", cc.RemoteAddr())
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		_ = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+		return
+	}
+	var req Request
+	if err = json.Unmarshal(body, &req); err != nil {
+		_ = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+		return
+	}
+	evidence, err := cc.Attest(r.Context())
+	if err != nil {
+		cc.Logger().Debugf("%v: cannot attest: %v", cc.RemoteAddr(), err)
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	reference, err := attestation.NewReference(req.Device, evidence, req.AttestationKey)
+	if err != nil {
+		cc.Logger().Debugf("%v: invalid evidence: %v", cc.RemoteAddr(), err)
+		_ = w.SetResponse(codes.Forbidden, message.TextPlain, bytes.NewReader([]byte(err.Error())))
+		return
+	}
+	fingerprint := keystore.Fingerprint(publicKey)
+	reference.Identity = fingerprint
+	req.Device.Identity = ""
+	record := Record{
+		Fingerprint: fingerprint,
+		Name:        req.Name,
+		PublicKey:   publicKey,
+		Device:      req.Device,
+		Reference:   reference,
+		Status:      StatusPending,
+		Time:        time.Now(),
+	}
+	credentials, err := e.enroll(&record)
+	switch {
+	case errors.Is(err, ErrPending):
+		_ = w.SetResponse(codes.ServiceUnavailable, message.TextPlain, bytes.NewReader([]byte(err.Error())))
+		return
+	case err != nil:
+		cc.Logger().Debugf("%v: cannot enroll %v: %v", cc.RemoteAddr(), record.Fingerprint, err)
+		_ = w.SetResponse(codes.Forbidden, message.TextPlain, bytes.NewReader([]byte(err.Error())))
+		return
+	}
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		_ = w.SetResponse(codes.InternalServerError, message.TextPlain, nil)
+		return
+	}
+	err = w.SetResponse(codes.Created, message.AppJSON, bytes.NewReader(data))
+	if err != nil {
+		cc.Logger().Debugf("%v: cannot send credentials: %v", cc.RemoteAddr(), err)
+	}
+}
+
+func (e *Enroller) enroll(r *Record) (*Credentials, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	previous, err := e.Store.Load(r.Fingerprint)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	err = e.approve(r, previous)
+	if errors.Is(err, ErrPending) {
+		if errS := e.Store.Save(*r); errS != nil {
+			return nil, errS
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := e.issueCredentials(r)
+	if err != nil {
+		return nil, err
+	}
+	rollback, err := e.provision(r, credentials)
+	if err != nil {
+		return nil, err
+	}
+	if credentials.PSK != nil {
+		r.PSKIdentity = credentials.PSKIdentity
+	}
+	r.Status = StatusEnrolled
+	if err = e.Store.Save(*r); err != nil {
+		// the device isn't enrolled, so the server must not trust its credentials
+		rollback()
+		return nil, err
+	}
+	if e.References != nil {
+		e.References.Add(*r.Reference)
+	}
+	return credentials, nil
+}
+
+// provision trusts the identity key of the record and stores its pre-shared key. It returns the function which
+// restores the keystore, or restores it itself on error.
+func (e *Enroller) provision(r *Record, c *Credentials) (func(), error) {
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	anchors, err := e.Keystore.TrustAnchors()
+	if err != nil {
+		return nil, err
+	}
+	var previousAnchor *keystore.TrustAnchor
+	for i := range anchors {
+		if anchors[i].Name == r.Fingerprint {
+			previousAnchor = &anchors[i]
+		}
+	}
+	if err = e.Keystore.AddTrustAnchor(keystore.TrustAnchor{Name: r.Fingerprint, PublicKey: r.PublicKey}); err != nil {
+		return nil, err
+	}
+	undo = append(undo, func() {
+		if previousAnchor != nil {
+			_ = e.Keystore.AddTrustAnchor(*previousAnchor)
+			return
+		}
+		_ = e.Keystore.RemoveTrustAnchor(r.Fingerprint)
+	})
+	if c.PSK == nil {
+		return rollback, nil
+	}
+	previousPSK, err := e.Keystore.PSK(c.PSKIdentity)
+	if err != nil && !errors.Is(err, keystore.ErrNotFound) {
+		rollback()
+		return nil, err
+	}
+	if err = e.Keystore.SetPSK(c.PSKIdentity, c.PSK); err != nil {
+		rollback()
+		return nil, err
+	}
+	undo = append(undo, func() {
+		if previousPSK != nil {
+			_ = e.Keystore.SetPSK(c.PSKIdentity, previousPSK)
+			return
+		}
+		_ = e.Keystore.DeletePSK(c.PSKIdentity)
+	})
+	return rollback, nil
+}
+
+// Device selects the golden values of the enrolled device of the peer identity key, it is meant for the Device of
+// the attestation policies. The device without an enrolled record has the zero Device, which has no reference.
+func (e *Enroller) Device(cc *connection.Conn) attestation.Device {
+	publicKey := cc.PeerIdentity().PublicKey
+	if publicKey == nil {
+		return attestation.Device{}
+	}
+	r, err := e.Store.Load(keystore.Fingerprint(publicKey))
+	if err != nil || r.Status != StatusEnrolled || r.Reference == nil {
+		return attestation.Device{}
+	}
+	return r.Reference.Device
+}
+
+func (e *Enroller) issueCredentials(r *Record) (*Credentials, error) {
+	var c Credentials
+	if e.IssuePSK {
+		c.PSKIdentity = r.Fingerprint
+		c.PSK = coder.RandomBytes(pskBytes)
+		if c.PSK == nil {
+			return nil, errors.New("cannot generate psk")
+		}
+	}
+	if e.CAKey != nil && e.CACertificate != nil {
+		certificate, err := e.issueCertificate(r)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificate = certificate
+	}
+	key, err := e.Keystore.IdentityKey()
+	switch {
+	case err == nil:
+		c.TrustAnchor = key.Public().(ed25519.PublicKey)
+	case !errors.Is(err, keystore.ErrNotFound):
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (e *Enroller) issueCertificate(r *Record) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	lifetime := e.CertificateLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultCertificateLifetime
+	}
+	name := r.Name
+	if name == "" {
+		name = r.Fingerprint
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    r.Time,
+		NotAfter:     r.Time.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, e.CACertificate, r.PublicKey, e.CAKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot issue certificate: %w", err)
+	}
+	return certificate, nil
+}
+
+// Enroll posts the request to the enrollment endpoint at the path. The identity key of the connection is enrolled,
+// and the connection must have an attester. The received pre-shared key and trust anchor are stored in the keystore.
+// It returns ErrPending while the enrollment waits for the approval of the operator.
+func Enroll(ctx context.Context, cc *connection.Conn, path string, req Request, ks keystore.Keystore) (*Credentials, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cc.Post(ctx, path, message.AppJSON, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("cannot send enrollment request: %w", err)
+	}
+	defer cc.ReleaseMessage(resp)
+	switch resp.Code() {
+	case codes.Created:
+	case codes.ServiceUnavailable:
+		return nil, ErrPending
+	default:
+		return nil, fmt.Errorf("enrollment failed: %v", resp.Code())
+	}
+	data, err := resp.ReadBody()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read credentials: %w", err)
+	}
+	var c Credentials
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse credentials: %w", err)
+	}
+	if c.PSK != nil {
+		if err = ks.SetPSK(c.PSKIdentity, c.PSK); err != nil {
+			return nil, err
+		}
+	}
+	if c.TrustAnchor != nil {
+		if err = ks.AddTrustAnchor(keystore.TrustAnchor{Name: keystore.Fingerprint(c.TrustAnchor), PublicKey: c.TrustAnchor}); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
@@ -0,0 +1,96 @@
+// Package enrollment provisions new devices over CoAP. The device proves its identity key in the ASCON
+// handshake and presents its initial measurements. When the policy of the operator approves it, the enroller
+// records the identity key as a trust anchor with its golden values and answers the long-term credentials
+// of the device: a pre-shared key, a certificate of the identity key and the trust anchor of the server.
+package enrollment
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+)
+
+// DefaultPath is the route of the enrollment endpoint.
+const DefaultPath = "/enroll"
+
+var (
+	ErrNotFound        = errors.New("enrollment record not found")
+	ErrPending         = errors.New("enrollment is pending approval")
+	ErrAlreadyEnrolled = errors.New("device is already enrolled")
+)
+
+// Status of the enrollment record.
+type Status string
+
+const (
+	// StatusPending waits for the approval of the operator.
+	StatusPending Status = "pending"
+	// StatusApproved is approved by the operator, the device enrolls on its next request.
+	StatusApproved Status = "approved"
+	StatusEnrolled Status = "enrolled"
+)
+
+// Request is the JSON body which the device posts to the enrollment endpoint.
+type Request struct {
+	Name   string             `json:"name,omitempty"`
+	Device attestation.Device `json:"device"`
+	// AttestationKey is the PKIX key which signs the evidence. It is required for EAT, quotes carry their key.
+	AttestationKey []byte `json:"attestationKey,omitempty"`
+}
+
+// Credentials is the JSON response to the enrolled device.
+type Credentials struct {
+	PSKIdentity string `json:"pskIdentity,omitempty"`
+	PSK         []byte `json:"psk,omitempty"`
+	// Certificate is the DER X.509 certificate of the identity key of the device.
+	Certificate []byte `json:"certificate,omitempty"`
+	// TrustAnchor is the identity key of the enrollment server.
+	TrustAnchor ed25519.PublicKey `json:"trustAnchor,omitempty"`
+}
+
+// Record is the enrollment of the identity key of the device.
+type Record struct {
+	Fingerprint string             `json:"fingerprint"`
+	Name        string             `json:"name,omitempty"`
+	PublicKey   ed25519.PublicKey  `json:"publicKey"`
+	Device      attestation.Device `json:"device"`
+	// Reference holds the golden values which are taken from the initial evidence.
+	Reference   *attestation.Reference `json:"reference,omitempty"`
+	PSKIdentity string                 `json:"pskIdentity,omitempty"`
+	Status      Status                 `json:"status"`
+	Time        time.Time              `json:"time"`
+}
+
+// Store persists the enrollment records.
+type Store interface {
+	// Load returns the record of the fingerprint of the identity key or ErrNotFound.
+	Load(fingerprint string) (Record, error)
+	// Save adds the record or replaces the record with the same fingerprint.
+	Save(r Record) error
+	Records() ([]Record, error)
+}
+
+// Policy approves the enrollment of the record. It returns ErrPending to wait for the approval of the operator
+// by Enroller.Approve, and any other error rejects the device.
+type Policy func(r *Record) error
+
+// AllowFingerprints approves the listed identity keys, the others wait for the approval of the operator.
+func AllowFingerprints(fingerprints ...string) Policy {
+	allowed := make(map[string]bool, len(fingerprints))
+	for _, f := range fingerprints {
+		allowed[f] = true
+	}
+	return func(r *Record) error {
+		if allowed[r.Fingerprint] {
+			return nil
+		}
+		return ErrPending
+	}
+}
+
+// RequireApproval makes every device wait for the approval of the operator.
+func RequireApproval(*Record) error {
+	return ErrPending
+}
@@ -0,0 +1,247 @@
+package enrollment_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/enrollment"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func newKeystore(t *testing.T) *keystore.MemoryStore {
+	key, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	ks := keystore.NewMemoryStore()
+	require.NoError(t, ks.SetIdentityKey(key))
+	return ks
+}
+
+// failingStore fails to save the enrolled records while fail is set.
+type failingStore struct {
+	enrollment.Store
+	fail atomic.Bool
+}
+
+func (s *failingStore) Save(r enrollment.Record) error {
+	if s.fail.Load() && r.Status == enrollment.StatusEnrolled {
+		return errors.New("cannot save record")
+	}
+	return s.Store.Save(r)
+}
+
+func TestEnroll(t *testing.T) {
+	serverKeystore := newKeystore(t)
+	caPublicKey, caKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	caDER, err := x509.CreateCertificate(nil, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "enrollment ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "enrollment ca"}}, caPublicKey, caKey)
+	require.NoError(t, err)
+	caCertificate, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	storePath := filepath.Join(t.TempDir(), "enrollment.json")
+	fileStore, err := enrollment.OpenFileStore(storePath)
+	require.NoError(t, err)
+	store := &failingStore{Store: fileStore}
+	references := &attestation.ReferenceValues{}
+	enroller := &enrollment.Enroller{
+		Store:         store,
+		Keystore:      serverKeystore,
+		References:    references,
+		Policy:        enrollment.RequireApproval,
+		IssuePSK:      true,
+		CACertificate: caCertificate,
+		CAKey:         caKey,
+	}
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	m := mux.NewRouter()
+	require.NoError(t, m.Handle(enrollment.DefaultPath, enroller))
+	require.NoError(t, m.Handle("/whoami", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		cc := w.Conn().(*connection.Conn)
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte(cc.PeerIdentity().PSKIdentity)))
+		require.NoError(t, errS)
+	})))
+	s := ascon.NewServer(options.WithMux(m), options.WithKeystore(serverKeystore))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	deviceKeystore := newKeystore(t)
+	deviceKey, err := deviceKeystore.IdentityKey()
+	require.NoError(t, err)
+	fingerprint := keystore.Fingerprint(deviceKey.Public().(ed25519.PublicKey))
+	device := attestation.Device{Class: "sensor", FirmwareVersion: "1.0"}
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	cc, err := ascon.Dial(l.LocalAddr().String(),
+		options.WithKeystore(deviceKeystore),
+		options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))}),
+	)
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+	}()
+	req := enrollment.Request{Name: "sensor-1", Device: device}
+
+	_, err = enrollment.Enroll(ctx, cc, enrollment.DefaultPath, req, deviceKeystore)
+	require.ErrorIs(t, err, enrollment.ErrPending)
+	record, err := store.Load(fingerprint)
+	require.NoError(t, err)
+	require.Equal(t, enrollment.StatusPending, record.Status)
+	require.NoError(t, enroller.Approve(fingerprint))
+
+	credentials, err := enrollment.Enroll(ctx, cc, enrollment.DefaultPath, req, deviceKeystore)
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, credentials.PSKIdentity)
+	certificate, err := x509.ParseCertificate(credentials.Certificate)
+	require.NoError(t, err)
+	require.NoError(t, certificate.CheckSignatureFrom(caCertificate))
+	require.Equal(t, "sensor-1", certificate.Subject.CommonName)
+	require.Equal(t, deviceKey.Public(), certificate.PublicKey)
+
+	anchor, err := keystore.FindTrustAnchor(serverKeystore, deviceKey.Public().(ed25519.PublicKey))
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, anchor.Name)
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	enrolledDevice := device
+	enrolledDevice.Identity = fingerprint
+	reference, err := references.Lookup(enrolledDevice)
+	require.NoError(t, err)
+	require.Equal(t, []attestation.Digest{golden.PCR}, reference.PCRs)
+	// the golden values of the device don't change the reference of its class
+	_, err = references.Lookup(device)
+	require.ErrorIs(t, err, attestation.ErrReferenceNotFound)
+
+	_, err = enrollment.Enroll(ctx, cc, enrollment.DefaultPath, req, deviceKeystore)
+	require.Error(t, err)
+
+	// the device authenticates by the provisioned credentials
+	enrolled, err := ascon.Dial(l.LocalAddr().String(),
+		options.WithKeystore(deviceKeystore),
+		options.WithPSKIdentity(credentials.PSKIdentity),
+		options.WithPeerAuthentication(),
+	)
+	require.NoError(t, err)
+	defer func() {
+		errC := enrolled.Close()
+		require.NoError(t, errC)
+	}()
+	resp, err := enrolled.Get(ctx, "/whoami")
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	require.Equal(t, fingerprint, string(body))
+
+	reopened, err := enrollment.OpenFileStore(storePath)
+	require.NoError(t, err)
+	record, err = reopened.Load(fingerprint)
+	require.NoError(t, err)
+	require.Equal(t, enrollment.StatusEnrolled, record.Status)
+	require.Equal(t, fingerprint, record.PSKIdentity)
+
+	// the credentials of the device whose record can't be saved are withdrawn
+	failedKeystore := newKeystore(t)
+	failedKey, err := failedKeystore.IdentityKey()
+	require.NoError(t, err)
+	failedFingerprint := keystore.Fingerprint(failedKey.Public().(ed25519.PublicKey))
+	failed, err := ascon.Dial(l.LocalAddr().String(),
+		options.WithKeystore(failedKeystore),
+		options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))}),
+	)
+	require.NoError(t, err)
+	defer func() {
+		errC := failed.Close()
+		require.NoError(t, errC)
+	}()
+	_, err = enrollment.Enroll(ctx, failed, enrollment.DefaultPath, req, failedKeystore)
+	require.ErrorIs(t, err, enrollment.ErrPending)
+	require.NoError(t, enroller.Approve(failedFingerprint))
+	store.fail.Store(true)
+	_, err = enrollment.Enroll(ctx, failed, enrollment.DefaultPath, req, failedKeystore)
+	require.Error(t, err)
+	store.fail.Store(false)
+	_, err = keystore.FindTrustAnchor(serverKeystore, failedKey.Public().(ed25519.PublicKey))
+	require.ErrorIs(t, err, keystore.ErrNotFound)
+	_, err = serverKeystore.PSK(failedFingerprint)
+	require.ErrorIs(t, err, keystore.ErrNotFound)
+	failedDevice := device
+	failedDevice.Identity = failedFingerprint
+	_, err = references.Lookup(failedDevice)
+	require.ErrorIs(t, err, attestation.ErrReferenceNotFound)
+	_, err = enrollment.Enroll(ctx, failed, enrollment.DefaultPath, req, failedKeystore)
+	require.NoError(t, err)
+
+	// the approved record without measurements is rejected
+	staleKeystore := newKeystore(t)
+	staleKey, err := staleKeystore.IdentityKey()
+	require.NoError(t, err)
+	require.NoError(t, store.Save(enrollment.Record{
+		Fingerprint: keystore.Fingerprint(staleKey.Public().(ed25519.PublicKey)),
+		PublicKey:   staleKey.Public().(ed25519.PublicKey),
+		Device:      device,
+		Reference:   &attestation.Reference{Device: device},
+		Status:      enrollment.StatusApproved,
+	}))
+	stale, err := ascon.Dial(l.LocalAddr().String(),
+		options.WithKeystore(staleKeystore),
+		options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))}),
+	)
+	require.NoError(t, err)
+	defer func() {
+		errC := stale.Close()
+		require.NoError(t, errC)
+	}()
+	_, err = enrollment.Enroll(ctx, stale, enrollment.DefaultPath, req, staleKeystore)
+	require.Error(t, err)
+
+	// the identity key is proven in the handshake
+	anonymous, err := ascon.Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer func() {
+		errC := anonymous.Close()
+		require.NoError(t, errC)
+	}()
+	_, err = enrollment.Enroll(ctx, anonymous, enrollment.DefaultPath, req, keystore.NewMemoryStore())
+	require.Error(t, err)
+}
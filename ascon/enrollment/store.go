@@ -0,0 +1,123 @@
+package enrollment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps the records in memory.
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Load(fingerprint string) (Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	r, ok := s.records[fingerprint]
+	if !ok {
+		return Record{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (s *MemoryStore) Save(r Record) error {
+	if r.Fingerprint == "" {
+		return errors.New("missing fingerprint")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records[r.Fingerprint] = r
+	return nil
+}
+
+// Records returns the records sorted by the fingerprint.
+func (s *MemoryStore) Records() ([]Record, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	records := make([]Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Fingerprint < records[j].Fingerprint
+	})
+	return records, nil
+}
+
+type fileContent struct {
+	Records []Record `json:"records"`
+}
+
+// FileStore keeps the records in a JSON file. Every change is written to the file immediately.
+type FileStore struct {
+	mutex  sync.Mutex
+	memory *MemoryStore
+	path   string
+}
+
+// OpenFileStore loads the records from the path. A missing file is created with the first change.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		memory: NewMemoryStore(),
+		path:   path,
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read enrollment store: %w", err)
+	}
+	var content fileContent
+	if err = json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("cannot load enrollment store %v: %w", path, err)
+	}
+	for _, r := range content.Records {
+		if err = s.memory.Save(r); err != nil {
+			return nil, fmt.Errorf("cannot load enrollment store %v: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Load(fingerprint string) (Record, error) {
+	return s.memory.Load(fingerprint)
+}
+
+func (s *FileStore) Save(r Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.memory.Save(r); err != nil {
+		return err
+	}
+	records, err := s.memory.Records()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fileContent{Records: records}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write enrollment store: %w", err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("cannot write enrollment store: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Records() ([]Record, error) {
+	return s.memory.Records()
+}
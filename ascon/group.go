@@ -0,0 +1,253 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/discovery"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+)
+
+// DefaultGroupAttestationTimeout is the time for which AttestGroup waits for the members.
+const DefaultGroupAttestationTimeout = 5 * time.Second
+
+var errNoResponse = errors.New("no response to prove")
+
+// GroupMember is the device which is expected to answer the group attestation.
+type GroupMember struct {
+	// Identity is the fingerprint of the identity key of the member, see keystore.Fingerprint. The member signs
+	// its evidence by the identity key.
+	Identity string
+	// Device selects the reference values of the member, whose attestation keys must sign its quote,
+	// e.g. the device of the enrolled identity.
+	Device attestation.Device
+}
+
+// GroupAttestation configures the collective attestation of a multicast group.
+type GroupAttestation struct {
+	// Verifier appraises the evidence of the members.
+	Verifier *attestation.Verifier
+	// Members are expected to answer, the missing ones are reported by the result. The devices which answer
+	// but aren't members are appraised against the zero Device and reported as unexpected. The responses which
+	// aren't signed by an identity key can't be attributed to a device, they are audited and ignored.
+	Members []GroupMember
+	// Timeout of the attestation, DefaultGroupAttestationTimeout when it is not set. AttestGroup returns
+	// earlier when all members have answered.
+	Timeout time.Duration
+	// AllowWarning accepts results with the warning status.
+	AllowWarning bool
+}
+
+func (g *GroupAttestation) accepts(result *attestation.AttestationResult) bool {
+	return result.Status == attestation.StatusAffirming || (g.AllowWarning && result.Status == attestation.StatusWarning)
+}
+
+// GroupVerdict is the appraisal of a device of the group.
+type GroupVerdict struct {
+	// Identity is the fingerprint of the identity key of the device.
+	Identity string
+	// Addr is the address from which the device answered, it is empty when the device didn't answer.
+	Addr   string
+	Device attestation.Device
+	// Result of the appraisal, it is nil when the device didn't answer by a proof.
+	Result *attestation.AttestationResult
+	// Err is set when the device didn't answer.
+	Err error
+}
+
+// GroupResult summarizes the group attestation. The identity lists are sorted.
+type GroupResult struct {
+	// Verdicts of the members and of the unexpected devices by their identities.
+	Verdicts map[string]*GroupVerdict
+	// Accepted members, whose results are affirming or, when it is allowed, warning.
+	Accepted []string
+	// Failed members, whose results aren't accepted.
+	Failed []string
+	// Missing members, which didn't answer within the timeout.
+	Missing []string
+	// Unexpected devices, which answered but aren't members.
+	Unexpected []string
+}
+
+// Complete reports whether all members were accepted.
+func (r *GroupResult) Complete() bool {
+	return len(r.Failed) == 0 && len(r.Missing) == 0
+}
+
+// AttestGroup challenges the devices of the multicast group by a single non-confirmable PROVE with a fresh nonce,
+// which is sent in plaintext over all network interfaces by default, and appraises the evidence of every device
+// which answers. The members must be configured by options.WithGroupAttestation. Their evidence is bound to their
+// identity keys instead of a session, so the results aren't stored as the attestation results of the connections.
+// Via opts you can specify the network interface, source IP address, and hop limit.
+func (s *Server) AttestGroup(ctx context.Context, address string, group GroupAttestation, opts ...coapNet.MulticastOption) (*GroupResult, error) {
+	if group.Verifier == nil {
+		return nil, errors.New("cannot attest group: missing verifier")
+	}
+	l := s.getListener()
+	if l == nil {
+		return nil, errors.New("server doesn't serve connection")
+	}
+	addr, err := net.ResolveUDPAddr(l.Network(), address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve address: %w", err)
+	}
+	members := make(map[string]attestation.Device, len(group.Members))
+	for _, m := range group.Members {
+		if m.Identity == "" {
+			return nil, errors.New("cannot attest group: missing member identity")
+		}
+		members[m.Identity] = m.Device
+	}
+	nonce, err := attestation.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	token, err := s.cfg.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token: %w", err)
+	}
+	timeout := group.Timeout
+	if timeout <= 0 {
+		timeout = DefaultGroupAttestationTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req := s.cfg.MessagePool.AcquireMessage(ctx)
+	defer s.cfg.MessagePool.ReleaseMessage(req)
+	req.SetCode(codes.PROVE)
+	req.SetToken(token)
+	req.SetMessageID(s.cfg.GetMID())
+	req.SetType(message.NonConfirmable)
+	req.SetContentFormat(message.AppOctets)
+	req.SetBody(bytes.NewReader(nonce))
+
+	var mutex sync.Mutex
+	verdicts := make(map[string]*GroupVerdict)
+	answered := 0
+	allAnswered := make(chan struct{})
+	if len(members) == 0 {
+		allAnswered = nil
+	}
+	unregister, err := discovery.Register(s.multicastRequests, s.multicastHandler, req, func(w *responsewriter.ResponseWriter[*connection.Conn], r *pool.Message) {
+		cc := w.Conn()
+		mutex.Lock()
+		defer mutex.Unlock()
+		var member bool
+		identity, result, errV := cc.VerifyGroupProof(group.Verifier, nonce, r, func(identity string) attestation.Device {
+			var device attestation.Device
+			device, member = members[identity]
+			return device
+		})
+		if errV != nil {
+			s.auditGroupFailure(cc.RemoteAddr().String(), "", attestation.Device{}, nonce, errV)
+			return
+		}
+		if _, ok := verdicts[identity]; ok {
+			// duplicate of the response
+			return
+		}
+		verdicts[identity] = &GroupVerdict{Identity: identity, Addr: cc.RemoteAddr().String(), Device: result.Device, Result: result}
+		if !member {
+			return
+		}
+		answered++
+		if answered == len(members) {
+			close(allAnswered)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer unregister()
+
+	if s.cfg.AuditLog != nil {
+		if _, err = s.cfg.AuditLog.Append(attestation.AuditEntry{Event: attestation.AuditRequest, Method: "group", Peer: addr.String(), Nonce: nonce}); err != nil {
+			s.cfg.Errors(fmt.Errorf("cannot append audit log: %w", err))
+		}
+	}
+	if err = s.writePlaintext(ctx, l, addr, req, opts...); err != nil {
+		return nil, fmt.Errorf("cannot send prove: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-allAnswered:
+	case <-s.ctx.Done():
+		return nil, fmt.Errorf("server was closed: %w", s.ctx.Err())
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return s.summarizeGroup(group, members, verdicts, nonce), nil
+}
+
+// writePlaintext sends the request to the multicast or unicast address by a plaintext session,
+// the devices which receive it don't share a session key.
+func (s *Server) writePlaintext(ctx context.Context, l *coapNet.UDPConn, addr *net.UDPAddr, req *pool.Message, opts ...coapNet.MulticastOption) error {
+	session := connection.NewSession(ctx, s.doneCtx, l, addr, s.cfg.MaxMessageSize, s.cfg.MTU, false)
+	defer func() {
+		_ = session.Close()
+	}()
+	if addr.IP.IsMulticast() {
+		return session.WriteMulticastMessage(req, addr, opts...)
+	}
+	return session.WriteMessage(req)
+}
+
+func (s *Server) summarizeGroup(group GroupAttestation, members map[string]attestation.Device, verdicts map[string]*GroupVerdict, nonce []byte) *GroupResult {
+	result := GroupResult{Verdicts: make(map[string]*GroupVerdict, len(verdicts)+len(members))}
+	for identity, v := range verdicts {
+		result.Verdicts[identity] = v
+		switch _, member := members[identity]; {
+		case !member:
+			result.Unexpected = append(result.Unexpected, identity)
+		case group.accepts(v.Result):
+			result.Accepted = append(result.Accepted, identity)
+		default:
+			result.Failed = append(result.Failed, identity)
+		}
+	}
+	for identity, device := range members {
+		if _, ok := verdicts[identity]; ok {
+			continue
+		}
+		result.Verdicts[identity] = &GroupVerdict{Identity: identity, Device: device, Err: errNoResponse}
+		result.Missing = append(result.Missing, identity)
+		s.auditGroupFailure("", identity, device, nonce, errNoResponse)
+	}
+	sort.Strings(result.Accepted)
+	sort.Strings(result.Failed)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Unexpected)
+	return &result
+}
+
+// auditGroupFailure records the verdict without status of the device which didn't prove.
+func (s *Server) auditGroupFailure(addr, identity string, device attestation.Device, nonce []byte, err error) {
+	if s.cfg.AuditLog == nil {
+		return
+	}
+	if _, errA := s.cfg.AuditLog.Append(attestation.AuditEntry{
+		Event:   attestation.AuditVerdict,
+		Method:  "group",
+		Peer:    addr,
+		Subject: identity,
+		Device:  device,
+		Nonce:   nonce,
+		Reasons: []string{err.Error()},
+	}); errA != nil {
+		s.cfg.Errors(fmt.Errorf("cannot append audit log: %w", errA))
+	}
+}
@@ -0,0 +1,174 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerAttestGroup(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	references := &attestation.ReferenceValues{}
+	references.Add(attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}})
+	verifier := attestation.NewVerifier(references, 0)
+	// newMember creates the identity of the member, whose reference trusts the attestation key
+	newMember := func(attestationKey []byte) (GroupMember, *keystore.MemoryStore) {
+		identityKey, errK := keystore.GenerateIdentityKey()
+		require.NoError(t, errK)
+		ks := keystore.NewMemoryStore()
+		require.NoError(t, ks.SetIdentityKey(identityKey))
+		identity := keystore.Fingerprint(identityKey.Public().(ed25519.PublicKey))
+		device := attestation.Device{Class: "sensor", Identity: identity}
+		references.Add(attestation.Reference{Device: device, AttestationKeys: [][]byte{attestationKey}, PCRs: []attestation.Digest{golden.PCR}})
+		return GroupMember{Identity: identity, Device: device}, ks
+	}
+
+	var wg sync.WaitGroup
+	t.Cleanup(wg.Wait)
+	serveListener := func(l *coapNet.UDPConn, opts ...ServerOption) *Server {
+		s := NewServer(opts...)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errS := s.Serve(l)
+			require.NoError(t, errS)
+		}()
+		t.Cleanup(func() {
+			s.Stop()
+			errC := l.Close()
+			require.NoError(t, errC)
+		})
+		<-s.serverStartedChan
+		return s
+	}
+	listen := func(addr string) *coapNet.UDPConn {
+		l, errL := coapNet.NewListenUDP("udp4", addr)
+		require.NoError(t, errL)
+		return l
+	}
+
+	multicastAddr := "224.0.1.187:9898"
+	ml := listen(multicastAddr)
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	a, err := net.ResolveUDPAddr("udp4", multicastAddr)
+	require.NoError(t, err)
+	for i := range ifaces {
+		iface := ifaces[i]
+		if errJ := ml.JoinGroup(&iface, a); errJ != nil {
+			t.Logf("cannot JoinGroup(%v, %v): %v", iface, a, errJ)
+		}
+	}
+	require.NoError(t, ml.SetMulticastLoopback(true))
+	member, memberKeystore := newMember(publicKey)
+	serveListener(ml, options.WithGroupAttestation(), options.WithKeystore(memberKeystore), options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key}))
+
+	auditLog := attestation.NewAuditLog()
+	s := serveListener(listen(""), options.WithAuditLog(auditLog))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	group := GroupAttestation{Verifier: verifier, Timeout: time.Millisecond * 500}
+	result, err := s.AttestGroup(ctx, multicastAddr, group)
+	require.NoError(t, err)
+	require.Equal(t, []string{member.Identity}, result.Unexpected)
+	require.Equal(t, attestation.StatusAffirming, result.Verdicts[member.Identity].Result.Status)
+
+	missing, _ := newMember(publicKey)
+	group.Members = []GroupMember{member, missing}
+	result, err = s.AttestGroup(ctx, multicastAddr, group)
+	require.NoError(t, err)
+	require.Equal(t, []string{member.Identity}, result.Accepted)
+	require.Equal(t, []string{missing.Identity}, result.Missing)
+	require.Empty(t, result.Failed)
+	require.Empty(t, result.Unexpected)
+	require.False(t, result.Complete())
+	require.Equal(t, member.Device, result.Verdicts[member.Identity].Result.Device)
+	require.ErrorIs(t, result.Verdicts[missing.Identity].Err, errNoResponse)
+
+	entries := auditLog.Entries()
+	require.NoError(t, attestation.VerifyAuditLog(entries, auditLog.Head()))
+	last := entries[len(entries)-1]
+	require.Equal(t, attestation.AuditVerdict, last.Event)
+	require.Equal(t, "group", last.Method)
+	require.Equal(t, missing.Identity, last.Subject)
+
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	otherPublicKey, err := x509.MarshalPKIXPublicKey(otherKey.Public())
+	require.NoError(t, err)
+	tests := []struct {
+		name           string
+		attestationKey []byte
+		memberOptions  []ServerOption
+		wantStatus     attestation.Status
+		wantMissing    bool
+	}{
+		{
+			name:           "compromised",
+			attestationKey: publicKey,
+			memberOptions:  []ServerOption{options.WithGroupAttestation(), options.WithAttester(&attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte("compromised")}, Key: key})},
+			wantStatus:     attestation.StatusContraindicated,
+		},
+		{
+			name:           "attestation key of another device",
+			attestationKey: publicKey,
+			memberOptions:  []ServerOption{options.WithGroupAttestation(), options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: otherKey})},
+			wantStatus:     attestation.StatusContraindicated,
+		},
+		{
+			name:           "accepted",
+			attestationKey: otherPublicKey,
+			memberOptions:  []ServerOption{options.WithGroupAttestation(), options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: otherKey})},
+			wantStatus:     attestation.StatusAffirming,
+		},
+		{
+			name:           "not a member",
+			attestationKey: publicKey,
+			memberOptions:  []ServerOption{options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key})},
+			wantMissing:    true,
+		},
+		{
+			name:           "no attester",
+			attestationKey: publicKey,
+			memberOptions:  []ServerOption{options.WithGroupAttestation()},
+			wantMissing:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ks := newMember(tt.attestationKey)
+			l := listen("127.0.0.1:")
+			serveListener(l, append(tt.memberOptions, options.WithKeystore(ks))...)
+			addr := l.LocalAddr().String()
+			result, errA := s.AttestGroup(ctx, addr, GroupAttestation{Verifier: verifier, Members: []GroupMember{m}, Timeout: time.Millisecond * 500})
+			require.NoError(t, errA)
+			verdict := result.Verdicts[m.Identity]
+			if tt.wantMissing {
+				// the refusal isn't signed by the member, so it is missing
+				require.Equal(t, []string{m.Identity}, result.Missing)
+				require.ErrorIs(t, verdict.Err, errNoResponse)
+				return
+			}
+			require.NoError(t, verdict.Err)
+			require.Equal(t, addr, verdict.Addr)
+			require.Equal(t, tt.wantStatus, verdict.Result.Status)
+			require.Equal(t, tt.wantStatus == attestation.StatusAffirming, result.Complete())
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package keylog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+)
+
+// Packet is a datagram of the capture decoded as CoAP message.
+type Packet struct {
+	Time time.Time
+	Src  netip.AddrPort
+	Dst  netip.AddrPort
+	// SessionID identifies the session key which decrypted the datagram, it is nil for plaintext datagrams.
+	SessionID []byte
+	Message   message.Message
+	// Err is set when the datagram can be neither decrypted nor decoded as plaintext CoAP message.
+	Err error
+}
+
+// Decode reads the UDP datagrams of a pcap capture and decrypts them with the session keys of the key log.
+// Handshake messages are sent in plaintext and they are decoded as they are.
+func Decode(capture io.Reader, entries []Entry) ([]Packet, error) {
+	for _, e := range entries {
+		if len(e.Key) != coder.KeyBytes {
+			return nil, fmt.Errorf("session %x: invalid key size %v", e.SessionID, len(e.Key))
+		}
+	}
+	r, err := newPcapReader(capture)
+	if err != nil {
+		return nil, err
+	}
+	// the session which decrypted the last datagram of the flow is tried first
+	flows := make(map[[2]string]int)
+	var packets []Packet
+	for {
+		d, err := r.next()
+		if errors.Is(err, io.EOF) {
+			return packets, nil
+		}
+		if err != nil {
+			return packets, err
+		}
+		packets = append(packets, decodeDatagram(d, entries, flows))
+	}
+}
+
+func decodeDatagram(d datagram, entries []Entry, flows map[[2]string]int) Packet {
+	p := Packet{
+		Time: d.time,
+		Src:  d.src,
+		Dst:  d.dst,
+	}
+	flow := flowKey(d.src, d.dst)
+	try := func(i int) bool {
+		plaintext, ok := coder.Open(entries[i].Key, d.payload)
+		if !ok {
+			return false
+		}
+		if _, err := coder.DefaultCoder.Decode(plaintext, &p.Message); err != nil {
+			return false
+		}
+		p.SessionID = entries[i].SessionID
+		flows[flow] = i
+		return true
+	}
+	if i, ok := flows[flow]; ok && try(i) {
+		return p
+	}
+	for i := range entries {
+		if try(i) {
+			return p
+		}
+	}
+	if _, err := coder.DefaultCoder.Decode(d.payload, &p.Message); err != nil {
+		p.Err = fmt.Errorf("cannot decode datagram: %w", err)
+	}
+	return p
+}
+
+func flowKey(a, b netip.AddrPort) [2]string {
+	x, y := a.String(), b.String()
+	if x > y {
+		x, y = y, x
+	}
+	return [2]string{x, y}
+}
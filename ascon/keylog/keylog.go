@@ -0,0 +1,70 @@
+// Package keylog writes and reads the session keys of ASCON sessions, so captured traffic can be decrypted
+// for debugging. The format follows the NSS key log format used by TLS:
+//
+//	ASCON_SESSION_KEY <session id hex> <session key hex>
+//
+// The session id is the transcript hash of the key exchange, which both endpoints compute.
+// Lines starting with # and empty lines are ignored.
+package keylog
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// SessionKeyLabel labels the session key of an ASCON session.
+const SessionKeyLabel = "ASCON_SESSION_KEY"
+
+// writerMutex serializes writes of all sessions, the writer is usually shared by the connections of a server.
+var writerMutex sync.Mutex
+
+// Entry is a session key from the key log.
+type Entry struct {
+	SessionID []byte
+	Key       []byte
+}
+
+// WriteSessionKey appends the session key to the key log.
+func WriteSessionKey(w io.Writer, sessionID []byte, key []byte) error {
+	line := fmt.Sprintf("%s %x %x\n", SessionKeyLabel, sessionID, key)
+	writerMutex.Lock()
+	defer writerMutex.Unlock()
+	_, err := io.WriteString(w, line)
+	return err
+}
+
+// Parse reads the session keys of the key log. Lines with other labels are skipped.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] != SessionKeyLabel {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %v: expected %v <session id> <key>", n, SessionKeyLabel)
+		}
+		sessionID, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid session id: %w", n, err)
+		}
+		key, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid key: %w", n, err)
+		}
+		entries = append(entries, Entry{SessionID: sessionID, Key: key})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
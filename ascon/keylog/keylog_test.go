@@ -0,0 +1,125 @@
+package keylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/stretchr/testify/require"
+)
+
+func encode(t *testing.T, c *coder.Coder, m message.Message) []byte {
+	size, err := c.Size(m)
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	n, err := c.Encode(m, buf)
+	require.NoError(t, err)
+	return buf[:n]
+}
+
+// writePcap writes the payloads as Ethernet/IPv4/UDP frames in the pcap format.
+func writePcap(src, dst netip.AddrPort, payloads ...[]byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeEthernet)
+	buf.Write(header)
+	for i, payload := range payloads {
+		frame := make([]byte, 14+20+8, 14+20+8+len(payload))
+		binary.BigEndian.PutUint16(frame[12:], etherTypeIPv4)
+		ip := frame[14:]
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+8+len(payload)))
+		ip[8] = 64
+		ip[9] = protocolUDP
+		copy(ip[12:16], src.Addr().AsSlice())
+		copy(ip[16:20], dst.Addr().AsSlice())
+		udp := ip[20:]
+		binary.BigEndian.PutUint16(udp[0:], src.Port())
+		binary.BigEndian.PutUint16(udp[2:], dst.Port())
+		binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+		frame = append(frame, payload...)
+
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:], uint32(1700000000+i))
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+		buf.Write(record)
+		buf.Write(frame)
+		src, dst = dst, src
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeCapture(t *testing.T) {
+	sessionID := coder.Transcript([]byte("client hello"), []byte("server hello"))
+	key := coder.RandomBytes(coder.KeyBytes)
+
+	var keyLog bytes.Buffer
+	keyLog.WriteString("# comment\nOTHER_LABEL 00 00\n")
+	require.NoError(t, WriteSessionKey(&keyLog, coder.Transcript([]byte("other"), nil), coder.RandomBytes(coder.KeyBytes)))
+	require.NoError(t, WriteSessionKey(&keyLog, sessionID, key))
+	entries, err := Parse(&keyLog)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, Entry{SessionID: sessionID, Key: key}, entries[1])
+
+	hello := message.Message{
+		Code:      codes.HANDSHAKE,
+		Type:      message.Confirmable,
+		MessageID: 1,
+		Token:     []byte{1},
+		Payload:   coder.RandomBytes(coder.X25519ShareSize),
+	}
+	request := message.Message{
+		Code:      codes.GET,
+		Type:      message.Confirmable,
+		MessageID: 2,
+		Token:     []byte{2},
+	}
+	response := message.Message{
+		Code:      codes.Content,
+		Type:      message.Acknowledgement,
+		MessageID: 2,
+		Token:     []byte{2},
+		Payload:   []byte("hello"),
+	}
+	session := coder.NewCoder().SetSecret(coder.NewSecretKey(append([]byte(nil), key...)))
+	client := netip.MustParseAddrPort("192.0.2.1:40000")
+	server := netip.MustParseAddrPort("192.0.2.2:5683")
+	capture := writePcap(client, server,
+		encode(t, coder.DefaultCoder, hello),
+		encode(t, session, response),
+		encode(t, session, request),
+		[]byte{0xff},
+	)
+
+	packets, err := Decode(bytes.NewReader(capture), entries)
+	require.NoError(t, err)
+	require.Len(t, packets, 4)
+
+	require.Nil(t, packets[0].SessionID)
+	require.NoError(t, packets[0].Err)
+	require.Equal(t, codes.HANDSHAKE, packets[0].Message.Code)
+	require.Equal(t, hello.Payload, packets[0].Message.Payload)
+	require.Equal(t, client, packets[0].Src)
+	require.Equal(t, time.Unix(1700000000, 0), packets[0].Time)
+
+	require.Equal(t, sessionID, packets[1].SessionID)
+	require.Equal(t, server, packets[1].Src)
+	require.Equal(t, codes.Content, packets[1].Message.Code)
+	require.Equal(t, []byte("hello"), packets[1].Message.Payload)
+
+	require.Equal(t, sessionID, packets[2].SessionID)
+	require.Equal(t, codes.GET, packets[2].Message.Code)
+
+	require.Error(t, packets[3].Err)
+}
@@ -0,0 +1,184 @@
+package keylog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// link types of the pcap format, see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeLinuxSLL = 113
+	linkTypeIPv4     = 228
+	linkTypeIPv6     = 229
+	linkTypeLoop     = 108
+
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	protocolUDP = 17
+)
+
+var ErrUnsupportedCapture = errors.New("unsupported capture")
+
+// datagram is an UDP datagram of the capture.
+type datagram struct {
+	time    time.Time
+	src     netip.AddrPort
+	dst     netip.AddrPort
+	payload []byte
+}
+
+// pcapReader reads the UDP datagrams of a capture in the classic pcap format.
+type pcapReader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	nanos     bool
+	linkType  uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("cannot read pcap header: %w", err)
+	}
+	p := &pcapReader{r: r}
+	switch magic := binary.LittleEndian.Uint32(header[:4]); magic {
+	case 0xa1b2c3d4:
+		p.byteOrder = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.byteOrder, p.nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.byteOrder = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.byteOrder, p.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("%w: magic %#x, only the pcap format is supported", ErrUnsupportedCapture, magic)
+	}
+	p.linkType = p.byteOrder.Uint32(header[20:24]) & 0x0fffffff
+	switch p.linkType {
+	case linkTypeNull, linkTypeLoop, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL, linkTypeIPv4, linkTypeIPv6:
+	default:
+		return nil, fmt.Errorf("%w: link type %v", ErrUnsupportedCapture, p.linkType)
+	}
+	return p, nil
+}
+
+// next returns the next UDP datagram, other packets are skipped. It returns io.EOF at the end of the capture.
+func (p *pcapReader) next() (datagram, error) {
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(p.r, header[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return datagram{}, fmt.Errorf("cannot read packet header: %w", err)
+			}
+			return datagram{}, err
+		}
+		sec := int64(p.byteOrder.Uint32(header[0:4]))
+		frac := int64(p.byteOrder.Uint32(header[4:8]))
+		if !p.nanos {
+			frac *= int64(time.Microsecond)
+		}
+		data := make([]byte, p.byteOrder.Uint32(header[8:12]))
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return datagram{}, fmt.Errorf("cannot read packet: %w", err)
+		}
+		d, ok := p.parseLink(data)
+		if !ok {
+			continue
+		}
+		d.time = time.Unix(sec, frac)
+		return d, nil
+	}
+}
+
+func (p *pcapReader) parseLink(data []byte) (datagram, bool) {
+	switch p.linkType {
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return datagram{}, false
+		}
+		return parseIP(data[4:])
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return datagram{}, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		if etherType == etherTypeVLAN {
+			if len(data) < 4 {
+				return datagram{}, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+			return datagram{}, false
+		}
+		return parseIP(data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return datagram{}, false
+		}
+		return parseIP(data[16:])
+	default:
+		return parseIP(data)
+	}
+}
+
+func parseIP(data []byte) (datagram, bool) {
+	if len(data) < 1 {
+		return datagram{}, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return datagram{}, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+		fragment := binary.BigEndian.Uint16(data[6:8])
+		// fragments are not reassembled
+		if data[9] != protocolUDP || fragment&0x3fff != 0 || headerLen < 20 || totalLen < headerLen || len(data) < totalLen {
+			return datagram{}, false
+		}
+		src, _ := netip.AddrFromSlice(data[12:16])
+		dst, _ := netip.AddrFromSlice(data[16:20])
+		return parseUDP(data[headerLen:totalLen], src, dst)
+	case 6:
+		if len(data) < 40 {
+			return datagram{}, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+		// extension headers are not supported
+		if data[6] != protocolUDP || len(data) < 40+payloadLen {
+			return datagram{}, false
+		}
+		src, _ := netip.AddrFromSlice(data[8:24])
+		dst, _ := netip.AddrFromSlice(data[24:40])
+		return parseUDP(data[40:40+payloadLen], src, dst)
+	}
+	return datagram{}, false
+}
+
+func parseUDP(data []byte, src, dst netip.Addr) (datagram, bool) {
+	if len(data) < 8 {
+		return datagram{}, false
+	}
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < 8 || len(data) < length {
+		return datagram{}, false
+	}
+	return datagram{
+		src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(data[0:2])),
+		dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(data[2:4])),
+		payload: data[8:length],
+	}, true
+}
@@ -0,0 +1,323 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	pemPrivateKey = "PRIVATE KEY"
+	pemPublicKey  = "PUBLIC KEY"
+
+	kdfScrypt = "scrypt"
+	saltBytes = 16
+)
+
+// scrypt parameters recommended for interactive logins
+var (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+type fileTrustAnchor struct {
+	Name      string `json:"name"`
+	PublicKey string `json:"publicKey"`
+}
+
+type fileContent struct {
+	IdentityKey  string            `json:"identityKey,omitempty"`
+	PSKs         map[string][]byte `json:"psks,omitempty"`
+	TrustAnchors []fileTrustAnchor `json:"trustAnchors,omitempty"`
+}
+
+// sealedFile is the content of a keystore file which is encrypted with a passphrase.
+type sealedFile struct {
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Tag        []byte `json:"tag"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// FileStore keeps the keys in a JSON file with PEM encoded keys. When a passphrase is set,
+// the file is encrypted at rest with ASCON under a key derived from the passphrase by scrypt.
+// Every change is written to the file immediately, and it takes effect only when the file is written.
+type FileStore struct {
+	// mutex serializes the changes
+	mutex      sync.Mutex
+	memory     atomic.Pointer[MemoryStore]
+	path       string
+	passphrase []byte
+}
+
+// OpenFileStore loads the keystore from the path. A missing file is created with the first change.
+// With a passphrase, a file which isn't encrypted is refused by ErrNotEncrypted.
+func OpenFileStore(path string, passphrase string) (*FileStore, error) {
+	s := &FileStore{
+		path:       path,
+		passphrase: []byte(passphrase),
+	}
+	s.memory.Store(NewMemoryStore())
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keystore: %w", err)
+	}
+	if err = s.load(data); err != nil {
+		return nil, fmt.Errorf("cannot load keystore %v: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) load(data []byte) error {
+	var sealed sealedFile
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return err
+	}
+	if len(sealed.Ciphertext) > 0 {
+		if len(s.passphrase) == 0 {
+			return ErrPassphraseMissing
+		}
+		plaintext, err := s.open(sealed)
+		if err != nil {
+			return err
+		}
+		data = plaintext
+	} else if len(s.passphrase) > 0 {
+		// the plaintext file could be planted by anyone who can write it, and the next change would seal it
+		return ErrNotEncrypted
+	}
+	var content fileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return err
+	}
+	return s.memory.Load().setContent(content)
+}
+
+func (s *FileStore) deriveKey(salt []byte) ([]byte, error) {
+	return scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, coder.KeyBytes)
+}
+
+func (s *FileStore) open(sealed sealedFile) ([]byte, error) {
+	if sealed.KDF != kdfScrypt {
+		return nil, fmt.Errorf("unsupported kdf %v", sealed.KDF)
+	}
+	if len(sealed.Nonce) != coder.NonceBytes || len(sealed.Tag) != coder.TagBytes {
+		return nil, errors.New("invalid sealed keystore")
+	}
+	key, err := s.deriveKey(sealed.Salt)
+	if err != nil {
+		return nil, err
+	}
+	defer coder.Wipe(key)
+	plaintext := coder.Decrypt(key, sealed.Nonce, sealed.Ciphertext, sealed.Tag)
+	if plaintext == nil {
+		return nil, ErrPassphrase
+	}
+	return plaintext, nil
+}
+
+func (s *FileStore) seal(plaintext []byte) ([]byte, error) {
+	salt := coder.RandomBytes(saltBytes)
+	nonce := coder.RandomBytes(coder.NonceBytes)
+	if salt == nil || nonce == nil {
+		return nil, errors.New("cannot generate salt")
+	}
+	key, err := s.deriveKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	defer coder.Wipe(key)
+	ciphertext, tag := coder.Encrypt(key, nonce, plaintext)
+	return json.MarshalIndent(sealedFile{
+		KDF:        kdfScrypt,
+		Salt:       salt,
+		Nonce:      nonce,
+		Tag:        tag,
+		Ciphertext: ciphertext,
+	}, "", "  ")
+}
+
+// save writes the keys to the file, it must be called with s.mutex locked.
+func (s *FileStore) save(memory *MemoryStore) error {
+	content, err := memory.getContent()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(s.passphrase) > 0 {
+		data, err = s.seal(data)
+		if err != nil {
+			return fmt.Errorf("cannot encrypt keystore: %w", err)
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("cannot write keystore: %w", err)
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("cannot write keystore: %w", err)
+	}
+	return nil
+}
+
+// update changes a copy of the keys and replaces the keys by it once the copy is written.
+func (s *FileStore) update(f func(memory *MemoryStore) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	memory, err := s.memory.Load().clone()
+	if err != nil {
+		return err
+	}
+	if err = f(memory); err != nil {
+		return err
+	}
+	if err = s.save(memory); err != nil {
+		return err
+	}
+	s.memory.Store(memory)
+	return nil
+}
+
+func (s *FileStore) IdentityKey() (ed25519.PrivateKey, error) {
+	return s.memory.Load().IdentityKey()
+}
+
+func (s *FileStore) SetIdentityKey(key ed25519.PrivateKey) error {
+	return s.update(func(memory *MemoryStore) error {
+		return memory.SetIdentityKey(key)
+	})
+}
+
+func (s *FileStore) PSK(identity string) ([]byte, error) {
+	return s.memory.Load().PSK(identity)
+}
+
+func (s *FileStore) SetPSK(identity string, key []byte) error {
+	return s.update(func(memory *MemoryStore) error {
+		return memory.SetPSK(identity, key)
+	})
+}
+
+func (s *FileStore) DeletePSK(identity string) error {
+	return s.update(func(memory *MemoryStore) error {
+		return memory.DeletePSK(identity)
+	})
+}
+
+func (s *FileStore) TrustAnchors() ([]TrustAnchor, error) {
+	return s.memory.Load().TrustAnchors()
+}
+
+func (s *FileStore) AddTrustAnchor(anchor TrustAnchor) error {
+	return s.update(func(memory *MemoryStore) error {
+		return memory.AddTrustAnchor(anchor)
+	})
+}
+
+func (s *FileStore) RemoveTrustAnchor(name string) error {
+	return s.update(func(memory *MemoryStore) error {
+		return memory.RemoveTrustAnchor(name)
+	})
+}
+
+func (s *MemoryStore) getContent() (fileContent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	content := fileContent{
+		PSKs: make(map[string][]byte, len(s.psks)),
+	}
+	for identity, key := range s.psks {
+		content.PSKs[identity] = append([]byte(nil), key...)
+	}
+	if s.identityKey != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(s.identityKey)
+		if err != nil {
+			return fileContent{}, err
+		}
+		content.IdentityKey = string(pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}))
+	}
+	for _, a := range s.trustAnchors {
+		der, err := x509.MarshalPKIXPublicKey(a.PublicKey)
+		if err != nil {
+			return fileContent{}, err
+		}
+		content.TrustAnchors = append(content.TrustAnchors, fileTrustAnchor{
+			Name:      a.Name,
+			PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der})),
+		})
+	}
+	return content, nil
+}
+
+func (s *MemoryStore) clone() (*MemoryStore, error) {
+	content, err := s.getContent()
+	if err != nil {
+		return nil, err
+	}
+	clone := NewMemoryStore()
+	if err = clone.setContent(content); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+func (s *MemoryStore) setContent(content fileContent) error {
+	if content.IdentityKey != "" {
+		key, err := parsePEM(content.IdentityKey, pemPrivateKey, x509.ParsePKCS8PrivateKey)
+		if err != nil {
+			return fmt.Errorf("identity key: %w", err)
+		}
+		identityKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("identity key: unsupported type %T", key)
+		}
+		if err = s.SetIdentityKey(identityKey); err != nil {
+			return err
+		}
+	}
+	for identity, key := range content.PSKs {
+		if err := s.SetPSK(identity, key); err != nil {
+			return fmt.Errorf("psk %v: %w", identity, err)
+		}
+	}
+	for _, a := range content.TrustAnchors {
+		key, err := parsePEM(a.PublicKey, pemPublicKey, x509.ParsePKIXPublicKey)
+		if err != nil {
+			return fmt.Errorf("trust anchor %v: %w", a.Name, err)
+		}
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("trust anchor %v: unsupported type %T", a.Name, key)
+		}
+		if err = s.AddTrustAnchor(TrustAnchor{Name: a.Name, PublicKey: publicKey}); err != nil {
+			return fmt.Errorf("trust anchor %v: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+func parsePEM(data string, typ string, parse func([]byte) (any, error)) (any, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil || block.Type != typ {
+		return nil, fmt.Errorf("expected PEM block %v", typ)
+	}
+	return parse(block.Bytes)
+}
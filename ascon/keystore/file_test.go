@@ -0,0 +1,104 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreReopen(t *testing.T) {
+	tests := []struct {
+		name           string
+		passphrase     string
+		openPassphrase string
+		wantErr        error
+	}{
+		{
+			name: "plain",
+		},
+		{
+			name:           "encrypted",
+			passphrase:     "correct horse",
+			openPassphrase: "correct horse",
+		},
+		{
+			name:           "wrong-passphrase",
+			passphrase:     "correct horse",
+			openPassphrase: "battery staple",
+			wantErr:        ErrPassphrase,
+		},
+		{
+			name:       "missing-passphrase",
+			passphrase: "correct horse",
+			wantErr:    ErrPassphraseMissing,
+		},
+		{
+			name:           "not-encrypted",
+			openPassphrase: "correct horse",
+			wantErr:        ErrNotEncrypted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keystore.json")
+			ks, err := OpenFileStore(path, tt.passphrase)
+			require.NoError(t, err)
+
+			identityKey, err := GenerateIdentityKey()
+			require.NoError(t, err)
+			anchorKey, err := GenerateIdentityKey()
+			require.NoError(t, err)
+			anchor := TrustAnchor{Name: "peer", PublicKey: anchorKey.Public().(ed25519.PublicKey)}
+			require.NoError(t, ks.SetIdentityKey(identityKey))
+			require.NoError(t, ks.SetPSK("device", []byte("secret")))
+			require.NoError(t, ks.SetPSK("removed", []byte("secret")))
+			require.NoError(t, ks.DeletePSK("removed"))
+			require.NoError(t, ks.AddTrustAnchor(anchor))
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			if tt.passphrase != "" {
+				require.NotContains(t, string(data), "PRIVATE KEY")
+			}
+
+			reopened, err := OpenFileStore(path, tt.openPassphrase)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			key, err := reopened.IdentityKey()
+			require.NoError(t, err)
+			require.Equal(t, identityKey, key)
+			psk, err := reopened.PSK("device")
+			require.NoError(t, err)
+			require.Equal(t, []byte("secret"), psk)
+			_, err = reopened.PSK("removed")
+			require.ErrorIs(t, err, ErrNotFound)
+			found, err := FindTrustAnchor(reopened, anchor.PublicKey)
+			require.NoError(t, err)
+			require.Equal(t, anchor, found)
+		})
+	}
+}
+
+func TestFileStoreFailedWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	ks, err := OpenFileStore(filepath.Join(dir, "keystore.json"), "")
+	require.NoError(t, err)
+	require.NoError(t, ks.SetPSK("device", []byte("secret")))
+
+	// the change which isn't written doesn't take effect
+	require.NoError(t, os.RemoveAll(dir))
+	require.Error(t, ks.SetPSK("other", []byte("secret")))
+	_, err = ks.PSK("other")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.Error(t, ks.DeletePSK("device"))
+	psk, err := ks.PSK("device")
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), psk)
+}
@@ -0,0 +1,90 @@
+// Package keystore provides storage for the long-term keys of ASCON endpoints: the identity key,
+// pre-shared keys and the trust anchors which are used to authenticate peers.
+package keystore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	ErrNotFound          = errors.New("key not found")
+	ErrInvalidKey        = errors.New("invalid key")
+	ErrPassphrase        = errors.New("invalid passphrase")
+	ErrPassphraseMissing = errors.New("keystore is encrypted, passphrase is required")
+	ErrNotEncrypted      = errors.New("keystore is not encrypted, but passphrase is set")
+)
+
+// TrustAnchor is a public key of a trusted peer.
+type TrustAnchor struct {
+	Name      string
+	PublicKey ed25519.PublicKey
+}
+
+// Keystore stores the long-term key material of an endpoint.
+type Keystore interface {
+	// IdentityKey returns the identity key of the local endpoint or ErrNotFound.
+	IdentityKey() (ed25519.PrivateKey, error)
+	SetIdentityKey(key ed25519.PrivateKey) error
+
+	// PSK returns the pre-shared key of the identity or ErrNotFound.
+	PSK(identity string) ([]byte, error)
+	SetPSK(identity string, key []byte) error
+	DeletePSK(identity string) error
+
+	TrustAnchors() ([]TrustAnchor, error)
+	// AddTrustAnchor adds the anchor or replaces the anchor with the same name.
+	AddTrustAnchor(anchor TrustAnchor) error
+	RemoveTrustAnchor(name string) error
+}
+
+// GenerateIdentityKey creates a new identity key.
+func GenerateIdentityKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// Fingerprint returns the hex encoded SHA-256 of the public key.
+func Fingerprint(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// FindTrustAnchor returns the trust anchor of the public key.
+func FindTrustAnchor(ks Keystore, publicKey ed25519.PublicKey) (TrustAnchor, error) {
+	anchors, err := ks.TrustAnchors()
+	if err != nil {
+		return TrustAnchor{}, err
+	}
+	for _, a := range anchors {
+		if bytes.Equal(a.PublicKey, publicKey) {
+			return a, nil
+		}
+	}
+	return TrustAnchor{}, ErrNotFound
+}
+
+func validateIdentityKey(key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func validatePSK(identity string, key []byte) error {
+	if identity == "" || len(key) == 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func validateTrustAnchor(anchor TrustAnchor) error {
+	if anchor.Name == "" || len(anchor.PublicKey) != ed25519.PublicKeySize {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package keystore
+
+import (
+	"crypto/ed25519"
+	"sync"
+)
+
+// MemoryStore keeps the keys in memory only.
+type MemoryStore struct {
+	mutex        sync.RWMutex
+	identityKey  ed25519.PrivateKey
+	psks         map[string][]byte
+	trustAnchors []TrustAnchor
+}
+
+// NewMemoryStore creates an empty keystore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		psks: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) IdentityKey() (ed25519.PrivateKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.identityKey == nil {
+		return nil, ErrNotFound
+	}
+	return s.identityKey, nil
+}
+
+func (s *MemoryStore) SetIdentityKey(key ed25519.PrivateKey) error {
+	if err := validateIdentityKey(key); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.identityKey = key
+	return nil
+}
+
+func (s *MemoryStore) PSK(identity string) ([]byte, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key, ok := s.psks[identity]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryStore) SetPSK(identity string, key []byte) error {
+	if err := validatePSK(identity, key); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.psks[identity] = append([]byte(nil), key...)
+	return nil
+}
+
+func (s *MemoryStore) DeletePSK(identity string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.psks[identity]; !ok {
+		return ErrNotFound
+	}
+	delete(s.psks, identity)
+	return nil
+}
+
+func (s *MemoryStore) TrustAnchors() ([]TrustAnchor, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]TrustAnchor(nil), s.trustAnchors...), nil
+}
+
+func (s *MemoryStore) AddTrustAnchor(anchor TrustAnchor) error {
+	if err := validateTrustAnchor(anchor); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, a := range s.trustAnchors {
+		if a.Name == anchor.Name {
+			s.trustAnchors[i] = anchor
+			return nil
+		}
+	}
+	s.trustAnchors = append(s.trustAnchors, anchor)
+	return nil
+}
+
+func (s *MemoryStore) RemoveTrustAnchor(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, a := range s.trustAnchors {
+		if a.Name == name {
+			s.trustAnchors = append(s.trustAnchors[:i], s.trustAnchors[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,183 @@
+package ascon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+)
+
+// DefaultAttestationMaxAge is the time for which the attestation result of a connection is fresh.
+const DefaultAttestationMaxAge = 5 * time.Minute
+
+// AttestationPolicy configures the routes which require a fresh, successful attestation of the calling device.
+type AttestationPolicy struct {
+	// Verifier appraises the evidence of the devices. When it is nil, the routes accept only the results of
+	// the passports posted to PassportHandler and answer 4.01 Unauthorized otherwise.
+	Verifier *attestation.Verifier
+	// Device selects the reference values of the connection. The zero Device is used when it is nil.
+	Device func(cc *connection.Conn) attestation.Device
+	// MaxAge of the attestation result, DefaultAttestationMaxAge when it is not set.
+	MaxAge time.Duration
+	// AllowWarning accepts results with the warning status.
+	AllowWarning bool
+	// Challenge answers 4.01 Unauthorized with a nonce of the verifier instead of sending PROVE to the device.
+	// The device answers by Conn.Prove to the same route and repeats the request.
+	Challenge bool
+	// Paths are the gated routes, a pattern ending with "/*" matches the subtree. All routes are gated when it is empty.
+	Paths []string
+}
+
+func (p *AttestationPolicy) gates(path string) bool {
+	if len(p.Paths) == 0 {
+		return true
+	}
+	path = "/" + strings.TrimPrefix(path, "/")
+	for _, pattern := range p.Paths {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(path, prefix) || path+"/" == prefix {
+				return true
+			}
+			continue
+		}
+		if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *AttestationPolicy) accepts(result *attestation.AttestationResult) bool {
+	if result == nil {
+		return false
+	}
+	switch result.Status {
+	case attestation.StatusAffirming:
+	case attestation.StatusWarning:
+		if !p.AllowWarning {
+			return false
+		}
+	default:
+		return false
+	}
+	if !result.Expires.IsZero() && !time.Now().Before(result.Expires) {
+		return false
+	}
+	maxAge := p.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultAttestationMaxAge
+	}
+	return time.Since(result.Time) < maxAge
+}
+
+func (p *AttestationPolicy) device(cc *connection.Conn) attestation.Device {
+	if p.Device == nil {
+		return attestation.Device{}
+	}
+	return p.Device(cc)
+}
+
+// AttestationResult returns the cached attestation result of the device which sent the request, or nil.
+func AttestationResult(w mux.ResponseWriter) *attestation.AttestationResult {
+	cc, ok := w.Conn().(*connection.Conn)
+	if !ok {
+		return nil
+	}
+	return cc.AttestationResult()
+}
+
+func setResultResponse(w mux.ResponseWriter, code codes.Code, result *attestation.AttestationResult) error {
+	if result == nil {
+		return w.SetResponse(code, message.TextPlain, nil)
+	}
+	return w.SetResponse(code, message.TextPlain, bytes.NewReader([]byte(result.Status.String()+": "+strings.Join(result.Reasons, "; "))))
+}
+
+// RequireAttestation returns the middleware which invokes the handler only when the attestation result of the
+// connection is fresh and accepted. Otherwise it attests the device by PROVE, or challenges it by 4.01 Unauthorized
+// with a nonce, and it appraises PROOF requests of the device to the gated routes.
+func RequireAttestation(policy AttestationPolicy) mux.MiddlewareFunc {
+	return func(next mux.Handler) mux.Handler {
+		return mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+			path, err := r.Path()
+			switch {
+			case errors.Is(err, message.ErrOptionNotFound):
+				// mux routes the request without Uri-Path to the root
+				path = "/"
+			case err != nil:
+				_ = w.SetResponse(codes.BadOption, message.TextPlain, nil)
+				return
+			}
+			if !policy.gates(path) {
+				next.ServeCOAP(w, r)
+				return
+			}
+			cc, ok := w.Conn().(*connection.Conn)
+			if !ok {
+				// only ASCON connections can be attested
+				_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+				return
+			}
+			if policy.Verifier == nil {
+				// the device presents its passport to the relying party
+				if r.Code() != codes.PROOF && policy.accepts(cc.AttestationResult()) {
+					next.ServeCOAP(w, r)
+					return
+				}
+				_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+				return
+			}
+			if r.Code() == codes.PROOF {
+				result, errV := cc.VerifyProof(policy.Verifier, policy.device(cc), r.Message)
+				if errV != nil {
+					cc.Logger().Debugf("%v: cannot verify proof: %v", cc.RemoteAddr(), errV)
+					errV = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+				} else if policy.accepts(result) {
+					errV = setResultResponse(w, codes.Changed, result)
+				} else {
+					errV = setResultResponse(w, codes.Forbidden, result)
+				}
+				if errV != nil {
+					cc.Logger().Debugf("%v: cannot send attestation result: %v", cc.RemoteAddr(), errV)
+				}
+				return
+			}
+			if policy.accepts(cc.AttestationResult()) {
+				next.ServeCOAP(w, r)
+				return
+			}
+			if policy.Challenge {
+				if errC := challenge(w, cc, policy.Verifier); errC != nil {
+					cc.Logger().Debugf("%v: cannot challenge: %v", cc.RemoteAddr(), errC)
+				}
+				return
+			}
+			result, err := cc.AttestWithVerifier(r.Context(), policy.Verifier, policy.device(cc))
+			if err != nil {
+				cc.Logger().Debugf("%v: cannot attest: %v", cc.RemoteAddr(), err)
+			}
+			if err != nil || !policy.accepts(result) {
+				if errS := setResultResponse(w, codes.Unauthorized, result); errS != nil {
+					cc.Logger().Debugf("%v: cannot send attestation result: %v", cc.RemoteAddr(), errS)
+				}
+				return
+			}
+			next.ServeCOAP(w, r)
+		})
+	}
+}
+
+func challenge(w mux.ResponseWriter, cc *connection.Conn, v *attestation.Verifier) error {
+	nonce, err := cc.Challenge(v)
+	if err != nil {
+		return fmt.Errorf("cannot create nonce: %w", err)
+	}
+	return w.SetResponse(codes.Unauthorized, message.AppOctets, bytes.NewReader(nonce))
+}
@@ -0,0 +1,296 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/runner/periodic"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+func TestRequireAttestation(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	deviceAttester := options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: key})
+	compromisedAttester := options.WithAttester(&attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte("compromised")}, Key: key})
+
+	tests := []struct {
+		name          string
+		challenge     bool
+		clientOptions []ClientOption
+		wantCode      codes.Code
+	}{
+		{
+			name:          "prove",
+			clientOptions: []ClientOption{deviceAttester},
+			wantCode:      codes.Content,
+		},
+		{
+			name:     "prove-without-attester",
+			wantCode: codes.Unauthorized,
+		},
+		{
+			name:          "prove-compromised",
+			clientOptions: []ClientOption{compromisedAttester},
+			wantCode:      codes.Unauthorized,
+		},
+		{
+			name:          "challenge",
+			challenge:     true,
+			clientOptions: []ClientOption{deviceAttester},
+			wantCode:      codes.Content,
+		},
+		{
+			name:          "challenge-compromised",
+			challenge:     true,
+			clientOptions: []ClientOption{compromisedAttester},
+			wantCode:      codes.Unauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			references := &attestation.ReferenceValues{}
+			references.Add(attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}})
+			m := mux.NewRouter()
+			m.Use(RequireAttestation(AttestationPolicy{
+				Verifier:  attestation.NewVerifier(references, 0),
+				Challenge: tt.challenge,
+				Paths:     []string{"/actuator/*"},
+			}))
+			for _, path := range []string{"/actuator/led", "/status"} {
+				err = m.Handle(path, mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+					errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+					require.NoError(t, errS)
+				}))
+				require.NoError(t, err)
+			}
+
+			auditLog := attestation.NewAuditLog()
+			s := NewServer(options.WithMux(m), options.WithAuditLog(auditLog))
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.LocalAddr().String(), tt.clientOptions...)
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/status")
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code())
+
+			resp, err = cc.Get(ctx, "/actuator/led")
+			require.NoError(t, err)
+			if tt.challenge {
+				require.Equal(t, codes.Unauthorized, resp.Code())
+				nonce, errR := resp.ReadBody()
+				require.NoError(t, errR)
+				resp, err = cc.Prove(ctx, "/actuator/led", nonce)
+				require.NoError(t, err)
+				if tt.wantCode != codes.Content {
+					require.Equal(t, codes.Forbidden, resp.Code())
+				} else {
+					require.Equal(t, codes.Changed, resp.Code())
+				}
+				resp, err = cc.Get(ctx, "/actuator/led")
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantCode, resp.Code())
+
+			entries := auditLog.Entries()
+			require.NoError(t, attestation.VerifyAuditLog(entries, auditLog.Head()))
+			require.GreaterOrEqual(t, len(entries), 2)
+			require.Equal(t, attestation.AuditRequest, entries[0].Event)
+			verdict := entries[1]
+			require.Equal(t, attestation.AuditVerdict, verdict.Event)
+			require.Equal(t, entries[0].Nonce, verdict.Nonce)
+			switch {
+			case tt.wantCode == codes.Content:
+				require.Equal(t, attestation.StatusAffirming.String(), verdict.Status)
+			case tt.clientOptions == nil:
+				require.Empty(t, verdict.Status)
+				require.NotEmpty(t, verdict.Reasons)
+			default:
+				require.Equal(t, attestation.StatusContraindicated.String(), verdict.Status)
+			}
+		})
+	}
+}
+
+// countingAttester counts the quotes of the attester.
+type countingAttester struct {
+	attestation.Attester
+	quotes atomic.Int32
+}
+
+func (a *countingAttester) Quote(nonce []byte) ([]byte, error) {
+	a.quotes.Inc()
+	return a.Attester.Quote(nonce)
+}
+
+func TestRequireAttestationCache(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	reference := attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}}
+	references := &attestation.ReferenceValues{}
+	references.Add(reference)
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	m := mux.NewRouter()
+	m.Use(RequireAttestation(AttestationPolicy{Verifier: attestation.NewVerifier(references, 0)}))
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		result := AttestationResult(w)
+		require.NotNil(t, result)
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte(result.Status.String())))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+
+	lifetime := time.Millisecond * 300
+	stop := make(chan struct{})
+	defer close(stop)
+	s := NewServer(options.WithMux(m),
+		options.WithPeriodicRunner(periodic.New(stop, time.Millisecond*10)),
+		options.WithAttestationCache(lifetime, references.Version))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	attester := &countingAttester{Attester: &attestation.SoftwareAttester{Measurer: measurer, Key: key}}
+	dial := func() *connection.Conn {
+		cc, errD := Dial(l.LocalAddr().String(), options.WithAttester(attester))
+		require.NoError(t, errD)
+		t.Cleanup(func() {
+			errC := cc.Close()
+			require.NoError(t, errC)
+		})
+		return cc
+	}
+	get := func(cc *connection.Conn) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		resp, errG := cc.Get(ctx, "/a")
+		require.NoError(t, errG)
+		require.Equal(t, codes.Content, resp.Code())
+		body, errR := resp.ReadBody()
+		require.NoError(t, errR)
+		require.Equal(t, attestation.StatusAffirming.String(), string(body))
+	}
+
+	cc := dial()
+	get(cc)
+	get(cc)
+	require.Equal(t, int32(1), attester.quotes.Load())
+
+	// the reference values changed
+	references.Add(reference)
+	get(cc)
+	require.Equal(t, int32(2), attester.quotes.Load())
+
+	// the result expired
+	time.Sleep(lifetime + time.Millisecond*50)
+	get(cc)
+	require.Equal(t, int32(3), attester.quotes.Load())
+
+	// the new session of the device
+	get(dial())
+	require.Equal(t, int32(4), attester.quotes.Load())
+}
+
+func TestRequireAttestationWithoutPath(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	m := mux.NewRouter()
+	m.Use(RequireAttestation(AttestationPolicy{Verifier: attestation.NewVerifier(&attestation.ReferenceValues{}, 0)}))
+	handler := mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	})
+	require.NoError(t, m.Handle("/", handler))
+	m.DefaultHandle(handler)
+
+	s := NewServer(options.WithMux(m))
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	cc, err := Dial(l.LocalAddr().String())
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+	}()
+
+	// the request without Uri-Path is routed to the root, which is gated as well
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	req := cc.AcquireMessage(ctx)
+	defer cc.ReleaseMessage(req)
+	token, err := cc.GetToken()
+	require.NoError(t, err)
+	req.SetCode(codes.GET)
+	req.SetToken(token)
+	resp, err := cc.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthorized, resp.Code())
+}
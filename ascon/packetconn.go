@@ -0,0 +1,837 @@
+package ascon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+)
+
+// The first byte of every datagram of PacketConn selects the record type.
+const (
+	recordClientHello    byte = 1 // client key share
+	recordServerHello    byte = 2 // server key share
+	recordData           byte = 3 // sealed payload
+	recordClientFinished byte = 4 // sealed client finished
+	recordServerFinished byte = 5 // sealed server finished
+)
+
+// The nonce of a sealed record carries the role of the sender in its first byte, the record type in the second one
+// and the sequence number of the record in the last 8 bytes. The client role is the side which sent the client hello
+// of the session.
+const (
+	clientRole byte = iota + 1
+	serverRole
+
+	// replayWindowSize is the number of sequence numbers below the highest received one which are accepted once.
+	replayWindowSize = 64
+)
+
+var (
+	// ErrHandshakeTimeout is returned by PacketConn.WriteTo when the peer doesn't finish the handshake in time.
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+	// ErrTooManyPeers is returned by PacketConn.WriteTo when the state of MaxPeers peers is kept.
+	ErrTooManyPeers = errors.New("too many peers")
+
+	errUnknownPeer = errors.New("record of unknown peer")
+)
+
+// PacketConnConfig configures PacketConn.
+type PacketConnConfig struct {
+	// HybridKeyExchange sends and requires the hybrid X25519 + ML-KEM-768 key shares.
+	HybridKeyExchange bool
+	// Authenticator proves the credentials of this side and verifies the ones of the peer by the finished flight
+	// of the handshake. Without credentials the finished flight only confirms the key. The client finished which
+	// isn't verified is dropped, so the handshake times out at the client.
+	Authenticator connection.Authenticator
+	// HandshakeTimeout bounds the handshake which WriteTo starts, and the wait for the peer whose simultaneous
+	// handshake won to prove its key. The peer which doesn't finish the handshake in time is removed.
+	HandshakeTimeout time.Duration
+	// RetransmitInterval is the interval of retransmitting the client hello and the client finished.
+	RetransmitInterval time.Duration
+	// MaxPeers bounds the peers whose state is kept, the client hellos of new peers are dropped when it's reached.
+	MaxPeers int
+	// PeerIdleTimeout removes the peer which neither sent an authenticated record nor was written for the duration.
+	PeerIdleTimeout time.Duration
+	// Errors reports the datagrams which are dropped.
+	Errors func(error)
+}
+
+var DefaultPacketConnConfig = PacketConnConfig{
+	HandshakeTimeout:   5 * time.Second,
+	RetransmitInterval: 500 * time.Millisecond,
+	MaxPeers:           1024,
+	PeerIdleTimeout:    5 * time.Minute,
+	Errors: func(error) {
+		// default no-op
+	},
+}
+
+type datagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// replayWindow accepts every sequence number once, when it isn't replayWindowSize or more below the highest one.
+type replayWindow struct {
+	highest uint64
+	// received has the bit i set when highest-i was received
+	received uint64
+}
+
+func (w *replayWindow) accept(sequence uint64) bool {
+	if w.received == 0 || sequence > w.highest {
+		shift := sequence - w.highest
+		if w.received == 0 || shift >= replayWindowSize {
+			w.received = 1
+		} else {
+			w.received = w.received<<shift | 1
+		}
+		w.highest = sequence
+		return true
+	}
+	age := w.highest - sequence
+	if age >= replayWindowSize || w.received&(1<<age) != 0 {
+		return false
+	}
+	w.received |= 1 << age
+	return true
+}
+
+// packetSession is the key of a handshake and the sequence numbers which are sealed and opened by it.
+type packetSession struct {
+	key        *coder.SecretKey
+	role       byte
+	transcript []byte
+	// identity is the identity which the peer proved by its finished
+	identity     connection.PeerIdentity
+	sendSequence uint64
+	window       replayWindow
+	// serverFinished answers the retransmitted client finished of the session, whose server this side is
+	serverFinished []byte
+}
+
+func newPacketSession(key *coder.SecretKey, role byte, transcript []byte) *packetSession {
+	return &packetSession{key: key, role: role, transcript: transcript}
+}
+
+func (s *packetSession) peerRole() byte {
+	if s.role == clientRole {
+		return serverRole
+	}
+	return clientRole
+}
+
+// nextNonce returns a copy of the session key, which the caller wipes after use, and the nonce of the next record.
+func (s *packetSession) nextNonce(typ byte) ([]byte, []byte, error) {
+	if s.key.Bytes() == nil {
+		return nil, nil, errors.New("session key was wiped")
+	}
+	if s.sendSequence == math.MaxUint64 {
+		return nil, nil, errors.New("sequence numbers of the session are exhausted")
+	}
+	nonce := make([]byte, coder.NonceBytes)
+	nonce[0] = s.role
+	nonce[1] = typ
+	binary.BigEndian.PutUint64(nonce[coder.NonceBytes-8:], s.sendSequence)
+	s.sendSequence++
+	return append([]byte(nil), s.key.Bytes()...), nonce, nil
+}
+
+// open opens the record of the type which the peer role sealed, when its sequence number wasn't opened before.
+// So a record can't be replayed, reflected to its sender or passed as another type.
+func (s *packetSession) open(typ byte, record []byte) ([]byte, error) {
+	if s.key.Bytes() == nil {
+		return nil, coder.ErrMessageAuthentication
+	}
+	plaintext, ok := coder.Open(s.key.Bytes(), record)
+	if !ok {
+		return nil, coder.ErrMessageAuthentication
+	}
+	nonce := coder.RecordNonce(record)
+	if nonce[0] != s.peerRole() || nonce[1] != typ || !bytes.Equal(nonce[2:coder.NonceBytes-8], make([]byte, coder.NonceBytes-10)) {
+		return nil, errors.New("record of unexpected role or type")
+	}
+	if !s.window.accept(binary.BigEndian.Uint64(nonce[coder.NonceBytes-8:])) {
+		return nil, errors.New("record was replayed")
+	}
+	return plaintext, nil
+}
+
+func (s *packetSession) wipe() {
+	if s != nil {
+		s.key.Wipe()
+	}
+}
+
+// peerHandshake is the handshake which this side started.
+type peerHandshake struct {
+	keyShare    coder.KeyShare
+	serverHello chan []byte
+	// session is set when the server hello arrives, the server finished is opened by it
+	session *packetSession
+	done    chan struct{}
+	err     error
+	// superseded is set when the handshake of the peer won, its key is pending until the peer proves it
+	superseded bool
+}
+
+type peer struct {
+	session *packetSession
+	// pending is the session of the client hello which this side answered, it becomes the session when the peer
+	// proves it by the client finished
+	pending *packetSession
+	// promoted is closed when the session is set after the pending one was answered
+	promoted chan struct{}
+	// handshake is in progress when it is set
+	handshake *peerHandshake
+	// clientShare and serverHello of the last answered handshake, the hello is repeated for the retransmitted client hello
+	clientShare []byte
+	serverHello []byte
+	// lastSeen is the time of the last authenticated record of the peer, or of the last WriteTo to it
+	lastSeen time.Time
+}
+
+func (p *peer) setSession(session *packetSession) {
+	p.wipe()
+	p.session = session
+	if p.promoted != nil {
+		close(p.promoted)
+		p.promoted = nil
+	}
+}
+
+// endHandshake ends the handshake of this side, its session is set when the server finished was verified.
+func (p *peer) endHandshake(identity connection.PeerIdentity, err error) {
+	h := p.handshake
+	p.handshake = nil
+	if err == nil {
+		h.session.identity = identity
+		p.setSession(h.session)
+	} else {
+		h.session.wipe()
+	}
+	h.err = err
+	close(h.done)
+}
+
+// supersede ends the handshake of this side, when the one of the peer provides the session.
+func (p *peer) supersede() {
+	h := p.handshake
+	p.handshake = nil
+	h.session.wipe()
+	h.superseded = true
+	close(h.done)
+}
+
+func (p *peer) setPending(session *packetSession) {
+	p.pending.wipe()
+	p.pending = session
+	if p.promoted == nil {
+		p.promoted = make(chan struct{})
+	}
+}
+
+func (p *peer) wipe() {
+	p.session.wipe()
+	p.pending.wipe()
+	p.session = nil
+	p.pending = nil
+}
+
+// PacketConn protects the datagrams of a net.PacketConn by ASCON. The first WriteTo to a peer exchanges
+// the session key by an X25519 (or hybrid) handshake, which the finished flight of the package confirms: the client
+// finished and the server finished are sealed by the new key and prove the pre-shared key and the identity key of the
+// Authenticator like the handshake of CoAP over TCP. Either side may start the handshake. The key which answers a
+// client hello is pending until the peer proves it by the client finished, so an unauthenticated hello doesn't
+// replace the key of the session. The nonce of a sealed record carries the role of its sender, its type and its
+// sequence number, so the records which don't authenticate, are reflected or are replayed are dropped.
+//
+// The state of a peer is created only by WriteTo and by a valid client hello. It is bounded by MaxPeers and removed
+// when the peer doesn't finish its handshake in HandshakeTimeout or is idle for PeerIdleTimeout. A peer which lost
+// its key, e.g. by a restart, handshakes again by its next WriteTo; the datagrams which are sent to it before are
+// dropped.
+//
+// The CoAP transport of the package doesn't run on PacketConn yet, it keeps its own handshake, which also attests
+// the peers.
+//
+// PacketConn reads the wrapped connection in the background, so the handshakes progress while the
+// application isn't reading.
+type PacketConn struct {
+	conn     net.PacketConn
+	cfg      PacketConnConfig
+	incoming chan datagram
+	done     chan struct{}
+	closeErr error
+	once     sync.Once
+
+	mutex     sync.Mutex
+	peers     map[string]*peer
+	nextSweep time.Time
+
+	readDeadline *deadline
+}
+
+// NewPacketConn wraps the connection and starts reading it.
+func NewPacketConn(conn net.PacketConn, cfg PacketConnConfig) *PacketConn {
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = DefaultPacketConnConfig.HandshakeTimeout
+	}
+	if cfg.RetransmitInterval <= 0 {
+		cfg.RetransmitInterval = DefaultPacketConnConfig.RetransmitInterval
+	}
+	if cfg.MaxPeers <= 0 {
+		cfg.MaxPeers = DefaultPacketConnConfig.MaxPeers
+	}
+	if cfg.PeerIdleTimeout <= 0 {
+		cfg.PeerIdleTimeout = DefaultPacketConnConfig.PeerIdleTimeout
+	}
+	if cfg.Errors == nil {
+		cfg.Errors = DefaultPacketConnConfig.Errors
+	}
+	c := &PacketConn{
+		conn:         conn,
+		cfg:          cfg,
+		incoming:     make(chan datagram, 64),
+		done:         make(chan struct{}),
+		peers:        make(map[string]*peer),
+		readDeadline: newDeadline(),
+	}
+	go c.run()
+	return c
+}
+
+// addPeer creates the state of a new peer. The caller holds the mutex.
+func (c *PacketConn) addPeer(key string) (*peer, error) {
+	now := time.Now()
+	c.expirePeers(now, len(c.peers) >= c.cfg.MaxPeers)
+	if len(c.peers) >= c.cfg.MaxPeers {
+		return nil, ErrTooManyPeers
+	}
+	p := &peer{lastSeen: now}
+	c.peers[key] = p
+	return p, nil
+}
+
+// expirePeers removes the peers which didn't finish the handshake in HandshakeTimeout and the ones which are idle
+// for PeerIdleTimeout. Unless forced, the peers are checked at most every HandshakeTimeout. The caller holds the mutex.
+func (c *PacketConn) expirePeers(now time.Time, force bool) {
+	if !force && now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.cfg.HandshakeTimeout)
+	for key, p := range c.peers {
+		if p.handshake != nil {
+			continue
+		}
+		timeout := c.cfg.PeerIdleTimeout
+		if p.session == nil {
+			timeout = c.cfg.HandshakeTimeout
+		}
+		if now.Sub(p.lastSeen) > timeout {
+			p.wipe()
+			delete(c.peers, key)
+		}
+	}
+}
+
+func (c *PacketConn) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			c.close(err)
+			return
+		}
+		c.mutex.Lock()
+		c.expirePeers(time.Now(), false)
+		c.mutex.Unlock()
+		if n == 0 {
+			continue
+		}
+		if err = c.process(buf[0], append([]byte(nil), buf[1:n]...), addr); err != nil {
+			c.cfg.Errors(fmt.Errorf("%v: %w", addr, err))
+		}
+	}
+}
+
+func (c *PacketConn) process(typ byte, data []byte, addr net.Addr) error {
+	switch typ {
+	case recordClientHello:
+		return c.handleClientHello(data, addr)
+	case recordServerHello:
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if p := c.peers[addr.String()]; p != nil && p.handshake != nil && p.handshake.session == nil {
+			select {
+			case p.handshake.serverHello <- data:
+			default:
+			}
+		}
+		return nil
+	case recordClientFinished:
+		return c.handleClientFinished(data, addr)
+	case recordServerFinished:
+		return c.handleServerFinished(data, addr)
+	case recordData:
+		plaintext, err := c.open(data, addr)
+		if err != nil {
+			return err
+		}
+		select {
+		case c.incoming <- datagram{data: plaintext, addr: addr}:
+			return nil
+		default:
+			// like a socket buffer, the datagrams which the application doesn't read in time are dropped
+			return errors.New("read queue is full, datagram was dropped")
+		}
+	}
+	return fmt.Errorf("unknown record type %v", typ)
+}
+
+func (c *PacketConn) handleClientHello(clientShare []byte, addr net.Addr) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p, ok := c.peers[addr.String()]
+	if ok && p.serverHello != nil && bytes.Equal(p.clientShare, clientShare) {
+		// the server hello was lost
+		return c.write(recordServerHello, p.serverHello, addr)
+	}
+	if ok && p.handshake != nil {
+		// the handshake of this side wins when the peer answered it already, otherwise both sides started the
+		// handshake and the lower key share wins
+		if p.handshake.session != nil || bytes.Compare(p.handshake.keyShare.Public(), clientShare) < 0 {
+			return nil
+		}
+		p.supersede()
+	}
+	serverShare, sessionKey, err := c.respond(clientShare)
+	if err != nil {
+		return fmt.Errorf("cannot compute server hello: %w", err)
+	}
+	if !ok {
+		if p, err = c.addPeer(addr.String()); err != nil {
+			sessionKey.Wipe()
+			return err
+		}
+	}
+	// the hello isn't authenticated, the current session stays until the peer proves the new key
+	p.setPending(newPacketSession(sessionKey, serverRole, coder.Transcript(clientShare, serverShare)))
+	p.clientShare = clientShare
+	p.serverHello = serverShare
+	return c.write(recordServerHello, serverShare, addr)
+}
+
+func (c *PacketConn) respond(clientShare []byte) ([]byte, *coder.SecretKey, error) {
+	switch len(clientShare) {
+	case coder.X25519ShareSize:
+		if c.cfg.HybridKeyExchange {
+			return nil, nil, errors.New("client hello without hybrid key share")
+		}
+		return coder.RespondX25519(clientShare)
+	case coder.HybridClientShareSize:
+		return coder.RespondHybrid(clientShare)
+	}
+	return nil, nil, coder.ErrInvalidKeyShare
+}
+
+// handleClientFinished verifies the client finished of the pending session, which replaces the session then, and
+// answers it by the server finished.
+func (c *PacketConn) handleClientFinished(record []byte, addr net.Addr) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.peers[addr.String()]
+	if p == nil {
+		return errUnknownPeer
+	}
+	if p.pending != nil {
+		if clientFinished, err := p.pending.open(recordClientFinished, record); err == nil {
+			pending := p.pending
+			p.pending = nil
+			identity, serverFinished, err := c.cfg.Authenticator.VerifyClientFinished(clientFinished, pending.transcript)
+			if err != nil {
+				pending.wipe()
+				return err
+			}
+			pending.identity = identity
+			pending.serverFinished = serverFinished
+			p.setSession(pending)
+			if p.handshake != nil {
+				// the handshake of this side started after the answered one, which provides the session now
+				p.supersede()
+			}
+			p.lastSeen = time.Now()
+			return c.writeSealed(pending, recordServerFinished, serverFinished, addr)
+		}
+	}
+	if p.session != nil && p.session.role == serverRole {
+		if _, err := p.session.open(recordClientFinished, record); err == nil {
+			// the server finished was lost
+			p.lastSeen = time.Now()
+			return c.writeSealed(p.session, recordServerFinished, p.session.serverFinished, addr)
+		}
+	}
+	return coder.ErrMessageAuthentication
+}
+
+// handleServerFinished verifies the server finished and ends the handshake by it. The session is set before the next
+// datagram of the peer is read.
+func (c *PacketConn) handleServerFinished(record []byte, addr net.Addr) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.peers[addr.String()]
+	if p == nil {
+		return errUnknownPeer
+	}
+	h := p.handshake
+	if h == nil || h.session == nil {
+		// the server finished of the retransmitted client finished
+		return nil
+	}
+	serverFinished, err := h.session.open(recordServerFinished, record)
+	if err != nil {
+		return err
+	}
+	p.lastSeen = time.Now()
+	identity, err := c.cfg.Authenticator.VerifyServerFinished(serverFinished, h.session.transcript)
+	p.endHandshake(identity, err)
+	return err
+}
+
+func (c *PacketConn) open(record []byte, addr net.Addr) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.peers[addr.String()]
+	if p == nil {
+		return nil, errUnknownPeer
+	}
+	if p.session == nil {
+		return nil, errors.New("datagram without session key")
+	}
+	plaintext, err := p.session.open(recordData, record)
+	if err != nil {
+		return nil, err
+	}
+	p.lastSeen = time.Now()
+	return plaintext, nil
+}
+
+func (c *PacketConn) write(typ byte, data []byte, addr net.Addr) error {
+	_, err := c.conn.WriteTo(append([]byte{typ}, data...), addr)
+	return err
+}
+
+// writeSealed seals the record by the next nonce of the session and sends it. The caller holds the mutex.
+func (c *PacketConn) writeSealed(s *packetSession, typ byte, plaintext []byte, addr net.Addr) error {
+	key, nonce, err := s.nextNonce(typ)
+	if err != nil {
+		return err
+	}
+	defer coder.Wipe(key)
+	return c.write(typ, coder.SealNonce(key, nonce, plaintext), addr)
+}
+
+// ReadFrom reads the next authenticated datagram. Like UDP, the rest of a datagram which is longer than p is discarded.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.incoming:
+		return copy(p, d.data), d.addr, nil
+	case <-c.done:
+		return 0, nil, c.closeErr
+	case <-c.readDeadline.done():
+		return 0, nil, &net.OpError{Op: "read", Net: c.conn.LocalAddr().Network(), Addr: c.conn.LocalAddr(), Err: errTimeout}
+	}
+}
+
+// WriteTo seals p by the session key of the peer and sends it. It handshakes with the peer first, when they don't
+// share a key yet.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	key, nonce, err := c.nextNonce(addr)
+	if err != nil {
+		return 0, err
+	}
+	defer coder.Wipe(key)
+	if err = c.write(recordData, coder.SealNonce(key, nonce, p), addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// PeerIdentity returns the identity which the peer proved by the finished flight of the current session.
+func (c *PacketConn) PeerIdentity(addr net.Addr) connection.PeerIdentity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.peers[addr.String()]
+	if p == nil || p.session == nil {
+		return connection.PeerIdentity{}
+	}
+	return p.session.identity
+}
+
+// nextNonce returns a copy of the session key of the peer, which the caller wipes after use, and the nonce of the
+// next datagram. It handshakes with the peer first, when they don't share a key yet.
+func (c *PacketConn) nextNonce(addr net.Addr) ([]byte, []byte, error) {
+	for {
+		c.mutex.Lock()
+		p, ok := c.peers[addr.String()]
+		if !ok {
+			var err error
+			if p, err = c.addPeer(addr.String()); err != nil {
+				c.mutex.Unlock()
+				return nil, nil, err
+			}
+		}
+		p.lastSeen = time.Now()
+		if p.session != nil {
+			key, nonce, err := p.session.nextNonce(recordData)
+			c.mutex.Unlock()
+			return key, nonce, err
+		}
+		h := p.handshake
+		start := h == nil
+		if start {
+			keyShare, err := c.newKeyShare()
+			if err != nil {
+				c.mutex.Unlock()
+				return nil, nil, fmt.Errorf("cannot create key share: %w", err)
+			}
+			h = &peerHandshake{keyShare: keyShare, serverHello: make(chan []byte, 1), done: make(chan struct{})}
+			p.handshake = h
+		}
+		c.mutex.Unlock()
+		if start {
+			c.handshake(h, addr)
+		}
+		select {
+		case <-h.done:
+		case <-c.done:
+			return nil, nil, c.closeErr
+		}
+		if h.err != nil {
+			return nil, nil, h.err
+		}
+		if h.superseded {
+			c.waitPromoted(addr)
+		}
+	}
+}
+
+// waitPromoted waits until the peer whose handshake won proves its key. The next handshake starts when it doesn't
+// in time.
+func (c *PacketConn) waitPromoted(addr net.Addr) {
+	c.mutex.Lock()
+	var promoted chan struct{}
+	if p := c.peers[addr.String()]; p != nil {
+		promoted = p.promoted
+	}
+	c.mutex.Unlock()
+	if promoted == nil {
+		return
+	}
+	timeout := time.NewTimer(c.cfg.HandshakeTimeout)
+	defer timeout.Stop()
+	select {
+	case <-promoted:
+	case <-timeout.C:
+	case <-c.done:
+	}
+}
+
+func (c *PacketConn) newKeyShare() (coder.KeyShare, error) {
+	if c.cfg.HybridKeyExchange {
+		return coder.NewHybridKeyShare()
+	}
+	return coder.NewX25519KeyShare()
+}
+
+// handshake sends the client hello until the server hello arrives, and then the client finished until the server
+// finished ends the handshake, the handshake of the peer wins or the timeout.
+func (c *PacketConn) handshake(h *peerHandshake, addr net.Addr) {
+	defer h.keyShare.Wipe()
+	timeout := time.NewTimer(c.cfg.HandshakeTimeout)
+	defer timeout.Stop()
+	retransmit := time.NewTicker(c.cfg.RetransmitInterval)
+	defer retransmit.Stop()
+	fail := func(err error) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		if p := c.peers[addr.String()]; p != nil && p.handshake == h {
+			p.endHandshake(connection.PeerIdentity{}, err)
+		}
+	}
+	var clientFinished []byte
+	for {
+		if err := c.writeHandshake(h, clientFinished, addr); err != nil {
+			fail(err)
+			return
+		}
+		select {
+		case serverHello := <-h.serverHello:
+			transcript, err := c.acceptServerHello(h, serverHello)
+			if err == nil {
+				clientFinished, err = c.cfg.Authenticator.ClientFinished(transcript)
+			}
+			if err != nil {
+				fail(err)
+				return
+			}
+			retransmit.Reset(c.cfg.RetransmitInterval)
+		case <-retransmit.C:
+		case <-timeout.C:
+			fail(fmt.Errorf("%v: %w", addr, ErrHandshakeTimeout))
+			return
+		case <-h.done:
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// acceptServerHello derives the session of the handshake from the server hello and returns its transcript.
+func (c *PacketConn) acceptServerHello(h *peerHandshake, serverHello []byte) ([]byte, error) {
+	secret, err := h.keyShare.SessionKey(serverHello)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server hello: %w", err)
+	}
+	transcript := coder.Transcript(h.keyShare.Public(), serverHello)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if h.superseded {
+		secret.Wipe()
+		return nil, errors.New("handshake was superseded")
+	}
+	h.session = newPacketSession(secret, clientRole, transcript)
+	return transcript, nil
+}
+
+// writeHandshake sends the client hello, or the client finished once the server hello arrived.
+func (c *PacketConn) writeHandshake(h *peerHandshake, clientFinished []byte, addr net.Addr) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if h.session == nil {
+		if err := c.write(recordClientHello, h.keyShare.Public(), addr); err != nil {
+			return fmt.Errorf("cannot send client hello: %w", err)
+		}
+		return nil
+	}
+	if h.superseded {
+		// the session was wiped, the handshake ends by its done channel
+		return nil
+	}
+	if err := c.writeSealed(h.session, recordClientFinished, clientFinished, addr); err != nil {
+		return fmt.Errorf("cannot send client finished: %w", err)
+	}
+	return nil
+}
+
+func (c *PacketConn) close(err error) {
+	c.once.Do(func() {
+		c.closeErr = err
+		close(c.done)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		for _, p := range c.peers {
+			if p.handshake != nil {
+				p.handshake.session.wipe()
+			}
+			p.wipe()
+		}
+	})
+}
+
+// Close closes the wrapped connection and wipes the session keys.
+func (c *PacketConn) Close() error {
+	err := c.conn.Close()
+	c.close(net.ErrClosed)
+	return err
+}
+
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of ReadFrom, the wrapped connection is read without a deadline.
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout net.Error = timeoutError{}
+
+// deadline closes its channel when the time passes.
+type deadline struct {
+	mutex sync.Mutex
+	timer *time.Timer
+	ch    chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{ch: make(chan struct{})}
+}
+
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	select {
+	case <-d.ch:
+		d.ch = make(chan struct{})
+	default:
+	}
+	if t.IsZero() {
+		return
+	}
+	ch := d.ch
+	wait := time.Until(t)
+	if wait <= 0 {
+		close(ch)
+		return
+	}
+	d.timer = time.AfterFunc(wait, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		if d.ch == ch {
+			closeOnce(ch)
+		}
+	})
+}
+
+func (d *deadline) done() <-chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.ch
+}
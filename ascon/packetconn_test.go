@@ -0,0 +1,397 @@
+package ascon
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingPacketConn records the datagrams which are written to the connection.
+type recordingPacketConn struct {
+	net.PacketConn
+	mutex     sync.Mutex
+	datagrams [][]byte
+}
+
+func (c *recordingPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	c.datagrams = append(c.datagrams, append([]byte(nil), b...))
+	c.mutex.Unlock()
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *recordingPacketConn) Written() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return bytes.Join(c.datagrams, nil)
+}
+
+// LastRecord returns the last datagram of the record type.
+func (c *recordingPacketConn) LastRecord(typ byte) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i := len(c.datagrams) - 1; i >= 0; i-- {
+		if c.datagrams[i][0] == typ {
+			return c.datagrams[i]
+		}
+	}
+	return nil
+}
+
+func newTestPacketConn(t *testing.T, cfg PacketConnConfig) (*PacketConn, *recordingPacketConn) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	rc := &recordingPacketConn{PacketConn: l}
+	c := NewPacketConn(rc, cfg)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return c, rc
+}
+
+func readPacket(t *testing.T, c *PacketConn) ([]byte, net.Addr) {
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(time.Second*5)))
+	buf := make([]byte, 1024)
+	n, addr, err := c.ReadFrom(buf)
+	require.NoError(t, err)
+	return buf[:n], addr
+}
+
+func TestPacketConnWriteToReadFrom(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  PacketConnConfig
+	}{
+		{
+			name: "x25519",
+			cfg:  DefaultPacketConnConfig,
+		},
+		{
+			name: "hybrid",
+			cfg: PacketConnConfig{
+				HybridKeyExchange: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, ra := newTestPacketConn(t, tt.cfg)
+			b, rb := newTestPacketConn(t, tt.cfg)
+
+			secret := []byte("secret datagram")
+			n, err := a.WriteTo(secret, b.LocalAddr())
+			require.NoError(t, err)
+			require.Equal(t, len(secret), n)
+			data, addr := readPacket(t, b)
+			require.Equal(t, secret, data)
+			require.Equal(t, a.LocalAddr().String(), addr.String())
+
+			// the responder of the handshake writes by the same session key
+			_, err = b.WriteTo(append(secret, '!'), addr)
+			require.NoError(t, err)
+			data, addr = readPacket(t, a)
+			require.Equal(t, append(secret, '!'), data)
+			require.Equal(t, b.LocalAddr().String(), addr.String())
+
+			require.False(t, bytes.Contains(ra.Written(), secret))
+			require.False(t, bytes.Contains(rb.Written(), secret))
+
+			_, err = a.WriteTo(nil, b.LocalAddr())
+			require.NoError(t, err)
+			data, _ = readPacket(t, b)
+			require.Empty(t, data)
+		})
+	}
+}
+
+func TestPacketConnReadDeadline(t *testing.T) {
+	c, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	require.NoError(t, c.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+	_, _, err := c.ReadFrom(make([]byte, 16))
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	require.True(t, netErr.Timeout())
+
+	require.NoError(t, c.Close())
+	require.NoError(t, c.SetReadDeadline(time.Time{}))
+	_, _, err = c.ReadFrom(make([]byte, 16))
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestPacketConnHandshakeTimeout(t *testing.T) {
+	c, _ := newTestPacketConn(t, PacketConnConfig{
+		HandshakeTimeout:   time.Millisecond * 200,
+		RetransmitInterval: time.Millisecond * 50,
+	})
+	// nobody answers the client hello
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		errC := silent.Close()
+		require.NoError(t, errC)
+	}()
+	_, err = c.WriteTo([]byte("hello"), silent.LocalAddr())
+	require.ErrorIs(t, err, ErrHandshakeTimeout)
+}
+
+func TestPacketConnPeerRestart(t *testing.T) {
+	a, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	b, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	_, err := a.WriteTo([]byte("first"), b.LocalAddr())
+	require.NoError(t, err)
+	data, _ := readPacket(t, b)
+	require.Equal(t, []byte("first"), data)
+
+	// b restarts at the same address without the session key
+	addr := b.LocalAddr().String()
+	require.NoError(t, b.Close())
+	l, err := net.ListenPacket("udp4", addr)
+	require.NoError(t, err)
+	b = NewPacketConn(l, DefaultPacketConnConfig)
+	defer func() {
+		errC := b.Close()
+		require.NoError(t, errC)
+	}()
+
+	// the datagrams of a are dropped until b handshakes again
+	_, err = a.WriteTo([]byte("lost"), b.LocalAddr())
+	require.NoError(t, err)
+	_, err = b.WriteTo([]byte("restarted"), a.LocalAddr())
+	require.NoError(t, err)
+	data, _ = readPacket(t, a)
+	require.Equal(t, []byte("restarted"), data)
+	_, err = a.WriteTo([]byte("second"), b.LocalAddr())
+	require.NoError(t, err)
+	data, _ = readPacket(t, b)
+	require.Equal(t, []byte("second"), data)
+}
+
+func TestPacketConnSpoofedHello(t *testing.T) {
+	a, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	b, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	spoof := func() {
+		keyShare, err := coder.NewX25519KeyShare()
+		require.NoError(t, err)
+		defer keyShare.Wipe()
+		// the hello is sent from the address of a, whose PacketConn doesn't know the key share
+		require.NoError(t, a.write(recordClientHello, keyShare.Public(), b.LocalAddr()))
+		require.Eventually(t, func() bool {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			p := b.peers[a.LocalAddr().String()]
+			return p != nil && bytes.Equal(p.clientShare, keyShare.Public())
+		}, time.Second, time.Millisecond*10)
+	}
+	exchange := func() {
+		_, err := b.WriteTo([]byte("reply"), a.LocalAddr())
+		require.NoError(t, err)
+		data, _ := readPacket(t, a)
+		require.Equal(t, []byte("reply"), data)
+		_, err = a.WriteTo([]byte("second"), b.LocalAddr())
+		require.NoError(t, err)
+		data, _ = readPacket(t, b)
+		require.Equal(t, []byte("second"), data)
+	}
+
+	// b doesn't seal by the key of the spoofed hello
+	spoof()
+	exchange()
+	// the spoofed hello doesn't replace the session key of b
+	spoof()
+	exchange()
+}
+
+func TestPacketConnSimultaneousHandshake(t *testing.T) {
+	a, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	b, _ := newTestPacketConn(t, DefaultPacketConnConfig)
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		from, to *PacketConn
+	}{{a, b}, {b, a}} {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errW := c.from.WriteTo([]byte("hello"), c.to.LocalAddr())
+			assert.NoError(t, errW)
+		}()
+	}
+	wg.Wait()
+	for _, c := range []*PacketConn{a, b} {
+		data, _ := readPacket(t, c)
+		require.Equal(t, []byte("hello"), data)
+	}
+}
+
+func TestPacketConnReplayAndReflection(t *testing.T) {
+	errs := make(chan error, 16)
+	cfg := DefaultPacketConnConfig
+	cfg.Errors = func(err error) {
+		errs <- err
+	}
+	a, ra := newTestPacketConn(t, cfg)
+	b, rb := newTestPacketConn(t, cfg)
+	_, err := a.WriteTo([]byte("once"), b.LocalAddr())
+	require.NoError(t, err)
+	data, _ := readPacket(t, b)
+	require.Equal(t, []byte("once"), data)
+	record := ra.LastRecord(recordData)
+	require.NotNil(t, record)
+
+	// replayed to b
+	_, err = ra.PacketConn.WriteTo(record, b.LocalAddr())
+	require.NoError(t, err)
+	require.ErrorContains(t, <-errs, "replayed")
+	// reflected to a, which shares the key with b
+	_, err = rb.PacketConn.WriteTo(record, a.LocalAddr())
+	require.NoError(t, err)
+	require.ErrorContains(t, <-errs, "unexpected role")
+
+	// the datagrams which are sealed later are still accepted
+	_, err = a.WriteTo([]byte("twice"), b.LocalAddr())
+	require.NoError(t, err)
+	data, _ = readPacket(t, b)
+	require.Equal(t, []byte("twice"), data)
+}
+
+func TestPacketConnUnknownPeer(t *testing.T) {
+	errs := make(chan error, 16)
+	cfg := DefaultPacketConnConfig
+	cfg.Errors = func(err error) {
+		errs <- err
+	}
+	c, _ := newTestPacketConn(t, cfg)
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	keyShare, err := coder.NewX25519KeyShare()
+	require.NoError(t, err)
+	defer keyShare.Wipe()
+
+	for _, datagram := range [][]byte{
+		append([]byte{recordClientHello}, []byte("garbage")...),
+		append([]byte{recordServerHello}, keyShare.Public()...),
+		append([]byte{recordClientFinished}, make([]byte, coder.Overhead)...),
+		append([]byte{recordServerFinished}, make([]byte, coder.Overhead)...),
+		append([]byte{recordData}, make([]byte, coder.Overhead)...),
+		{0xff},
+	} {
+		_, err = l.WriteTo(datagram, c.LocalAddr())
+		require.NoError(t, err)
+	}
+	// the server hello without a handshake is dropped silently
+	for i := 0; i < 5; i++ {
+		select {
+		case err = <-errs:
+			require.Error(t, err)
+		case <-time.After(time.Second * 5):
+			require.FailNow(t, "datagram wasn't dropped")
+		}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	require.Empty(t, c.peers)
+}
+
+func TestPacketConnMaxPeers(t *testing.T) {
+	cfg := PacketConnConfig{
+		HandshakeTimeout:   time.Millisecond * 200,
+		RetransmitInterval: time.Millisecond * 50,
+		MaxPeers:           1,
+		PeerIdleTimeout:    time.Second,
+	}
+	a, _ := newTestPacketConn(t, cfg)
+	b, _ := newTestPacketConn(t, cfg)
+	c, _ := newTestPacketConn(t, cfg)
+	_, err := a.WriteTo([]byte("first"), b.LocalAddr())
+	require.NoError(t, err)
+	data, _ := readPacket(t, b)
+	require.Equal(t, []byte("first"), data)
+
+	// b keeps the state of a only
+	_, err = c.WriteTo([]byte("second"), b.LocalAddr())
+	require.ErrorIs(t, err, ErrHandshakeTimeout)
+	_, err = b.WriteTo([]byte("second"), c.LocalAddr())
+	require.ErrorIs(t, err, ErrTooManyPeers)
+
+	// a is idle, so its state is removed for the new peer
+	time.Sleep(cfg.PeerIdleTimeout)
+	_, err = c.WriteTo([]byte("third"), b.LocalAddr())
+	require.NoError(t, err)
+	data, addr := readPacket(t, b)
+	require.Equal(t, []byte("third"), data)
+	require.Equal(t, c.LocalAddr().String(), addr.String())
+}
+
+func TestPacketConnAuthentication(t *testing.T) {
+	newKeystore := func(psk []byte) keystore.Keystore {
+		ks := keystore.NewMemoryStore()
+		require.NoError(t, ks.SetPSK("device", psk))
+		return ks
+	}
+	tests := []struct {
+		name     string
+		client   connection.Authenticator
+		server   connection.Authenticator
+		wantErr  bool
+		wantPeer connection.PeerIdentity
+	}{
+		{
+			name:     "psk",
+			client:   connection.Authenticator{Keystore: newKeystore([]byte("psk")), PSKIdentity: "device", RequirePeerAuthentication: true},
+			server:   connection.Authenticator{Keystore: newKeystore([]byte("psk")), RequirePeerAuthentication: true},
+			wantPeer: connection.PeerIdentity{PSKIdentity: "device"},
+		},
+		{
+			name:    "invalid psk",
+			client:  connection.Authenticator{Keystore: newKeystore([]byte("psk")), PSKIdentity: "device"},
+			server:  connection.Authenticator{Keystore: newKeystore([]byte("other")), RequirePeerAuthentication: true},
+			wantErr: true,
+		},
+		{
+			name:    "unauthenticated client",
+			server:  connection.Authenticator{Keystore: newKeystore([]byte("psk")), RequirePeerAuthentication: true},
+			wantErr: true,
+		},
+		{
+			name:    "unauthenticated server",
+			client:  connection.Authenticator{RequirePeerAuthentication: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCfg := DefaultPacketConnConfig
+			clientCfg.HandshakeTimeout = time.Millisecond * 500
+			clientCfg.Authenticator = tt.client
+			serverCfg := clientCfg
+			serverCfg.Authenticator = tt.server
+			client, _ := newTestPacketConn(t, clientCfg)
+			server, _ := newTestPacketConn(t, serverCfg)
+
+			_, err := client.WriteTo([]byte("hello"), server.LocalAddr())
+			if tt.wantErr {
+				require.Error(t, err)
+				require.Equal(t, connection.PeerIdentity{}, server.PeerIdentity(client.LocalAddr()))
+				return
+			}
+			require.NoError(t, err)
+			data, addr := readPacket(t, server)
+			require.Equal(t, []byte("hello"), data)
+			require.Equal(t, tt.wantPeer, server.PeerIdentity(addr))
+			require.Equal(t, tt.wantPeer, client.PeerIdentity(server.LocalAddr()))
+		})
+	}
+}
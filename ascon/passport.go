@@ -0,0 +1,120 @@
+package ascon
+
+import (
+	"bytes"
+	"crypto"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+)
+
+// DefaultPassportPath is the route at which the verifier issues the passports and the relying parties accept them.
+const DefaultPassportPath = "/passport"
+
+// VerifierService is the verifier of the passport model: it attests the calling device by PROVE and answers
+// a passport, the attestation result signed by Key. The device presents the passport to the relying parties,
+// which check the signature instead of appraising the evidence.
+type VerifierService struct {
+	Verifier *attestation.Verifier
+	// Device selects the reference values of the connection. The zero Device is used when it is nil.
+	Device func(cc *connection.Conn) attestation.Device
+	// Key is ed25519 or P-256 key which signs the passports.
+	Key    crypto.Signer
+	Issuer string
+	// Lifetime of the passports, attestation.DefaultPassportLifetime when it is not set.
+	Lifetime time.Duration
+	// AllowWarning issues passports for results with the warning status.
+	AllowWarning bool
+}
+
+// NewVerifierRouter creates the router of the standalone verifier which issues the passports at DefaultPassportPath.
+func NewVerifierRouter(s *VerifierService) (*mux.Router, error) {
+	router := mux.NewRouter()
+	if err := router.Handle(DefaultPassportPath, s); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+func (s *VerifierService) device(cc *connection.Conn) attestation.Device {
+	if s.Device == nil {
+		return attestation.Device{}
+	}
+	return s.Device(cc)
+}
+
+func (s *VerifierService) accepts(result *attestation.AttestationResult) bool {
+	return result.Status == attestation.StatusAffirming || (s.AllowWarning && result.Status == attestation.StatusWarning)
+}
+
+// ServeCOAP answers 2.05 Content with the passport in application/cwt, 4.03 Forbidden when the device fails
+// the appraisal and 4.01 Unauthorized when the device doesn't prove its identity or its measurements.
+func (s *VerifierService) ServeCOAP(w mux.ResponseWriter, r *mux.Message) {
+	cc, ok := w.Conn().(*connection.Conn)
+	if !ok {
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	subject := cc.PeerIdentity().PassportSubject()
+	if subject == "" {
+		cc.Logger().Debugf("%v: cannot issue passport: %v", cc.RemoteAddr(), connection.ErrPeerNotAuthenticated)
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	result, err := cc.AttestWithVerifier(r.Context(), s.Verifier, s.device(cc))
+	if err != nil {
+		cc.Logger().Debugf("%v: cannot attest: %v", cc.RemoteAddr(), err)
+		_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+	if !s.accepts(result) {
+		if errS := setResultResponse(w, codes.Forbidden, result); errS != nil {
+			cc.Logger().Debugf("%v: cannot send attestation result: %v", cc.RemoteAddr(), errS)
+		}
+		return
+	}
+	passport, err := attestation.IssuePassport(s.Key, s.Issuer, subject, result, s.Lifetime)
+	if err != nil {
+		cc.Logger().Debugf("%v: cannot issue passport: %v", cc.RemoteAddr(), err)
+		_ = w.SetResponse(codes.InternalServerError, message.TextPlain, nil)
+		return
+	}
+	if err = w.SetResponse(codes.Content, message.AppCWT, bytes.NewReader(passport)); err != nil {
+		cc.Logger().Debugf("%v: cannot send passport: %v", cc.RemoteAddr(), err)
+	}
+}
+
+// PassportHandler returns the handler of the relying party, to which the device posts its passport. The passport
+// must be signed by one of the PKIX public keys of the verifiers and issued to the identity of the device. Its
+// result becomes the attestation result of the connection, which RequireAttestation checks.
+func PassportHandler(verifierKeys [][]byte) mux.Handler {
+	return mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		cc, ok := w.Conn().(*connection.Conn)
+		if !ok {
+			_ = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+			return
+		}
+		if r.Code() != codes.POST {
+			_ = w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+			return
+		}
+		data, err := r.ReadBody()
+		if err != nil {
+			_ = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+			return
+		}
+		result, err := cc.VerifyPassport(verifierKeys, data)
+		if err != nil {
+			cc.Logger().Debugf("%v: cannot verify passport: %v", cc.RemoteAddr(), err)
+			_ = w.SetResponse(codes.Forbidden, message.TextPlain, bytes.NewReader([]byte(err.Error())))
+			return
+		}
+		if errS := setResultResponse(w, codes.Changed, result); errS != nil {
+			cc.Logger().Debugf("%v: cannot send attestation result: %v", cc.RemoteAddr(), errS)
+		}
+	})
+}
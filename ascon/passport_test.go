@@ -0,0 +1,134 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, router *mux.Router) (string, func()) {
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	s := NewServer(options.WithMux(router))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+	return l.LocalAddr().String(), func() {
+		s.Stop()
+		wg.Wait()
+		errC := l.Close()
+		require.NoError(t, errC)
+	}
+}
+
+func TestPassport(t *testing.T) {
+	attestationKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	attestationPublicKey, err := x509.MarshalPKIXPublicKey(attestationKey.Public())
+	require.NoError(t, err)
+	_, verifierKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	verifierPublicKey, err := x509.MarshalPKIXPublicKey(verifierKey.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+	references := &attestation.ReferenceValues{}
+	references.Add(attestation.Reference{AttestationKeys: [][]byte{attestationPublicKey}, PCRs: []attestation.Digest{golden.PCR}})
+
+	verifierRouter, err := NewVerifierRouter(&VerifierService{
+		Verifier: attestation.NewVerifier(references, 0),
+		Key:      verifierKey,
+		Issuer:   "verifier",
+	})
+	require.NoError(t, err)
+	verifierAddr, stopVerifier := serve(t, verifierRouter)
+	defer stopVerifier()
+
+	relyingRouter := mux.NewRouter()
+	relyingRouter.Use(RequireAttestation(AttestationPolicy{Paths: []string{"/actuator/*"}}))
+	err = relyingRouter.Handle(DefaultPassportPath, PassportHandler([][]byte{verifierPublicKey}))
+	require.NoError(t, err)
+	err = relyingRouter.Handle("/actuator/led", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	relyingAddr, stopRelying := serve(t, relyingRouter)
+	defer stopRelying()
+
+	newDevice := func(config string) []ClientOption {
+		identityKey, errK := keystore.GenerateIdentityKey()
+		require.NoError(t, errK)
+		ks := keystore.NewMemoryStore()
+		require.NoError(t, ks.SetIdentityKey(identityKey))
+		return []ClientOption{
+			options.WithKeystore(ks),
+			options.WithAttester(&attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte(config)}, Key: attestationKey}),
+		}
+	}
+	dial := func(addr string, opts []ClientOption) *connection.Conn {
+		cc, errD := Dial(addr, opts...)
+		require.NoError(t, errD)
+		t.Cleanup(func() {
+			errC := cc.Close()
+			require.NoError(t, errC)
+		})
+		return cc
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	device := newDevice("firmware 1.0")
+	resp, err := dial(verifierAddr, device).Get(ctx, DefaultPassportPath)
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	passport, err := resp.ReadBody()
+	require.NoError(t, err)
+
+	relying := dial(relyingAddr, device)
+	resp, err = relying.Get(ctx, "/actuator/led")
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthorized, resp.Code())
+	resp, err = relying.Post(ctx, DefaultPassportPath, message.AppCWT, bytes.NewReader(passport))
+	require.NoError(t, err)
+	require.Equal(t, codes.Changed, resp.Code())
+	resp, err = relying.Get(ctx, "/actuator/led")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+
+	// the passport is bound to the identity of the device
+	thief := dial(relyingAddr, newDevice("firmware 1.0"))
+	resp, err = thief.Post(ctx, DefaultPassportPath, message.AppCWT, bytes.NewReader(passport))
+	require.NoError(t, err)
+	require.Equal(t, codes.Forbidden, resp.Code())
+	resp, err = thief.Get(ctx, "/actuator/led")
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthorized, resp.Code())
+
+	resp, err = dial(verifierAddr, newDevice("compromised")).Get(ctx, DefaultPassportPath)
+	require.NoError(t, err)
+	require.Equal(t, codes.Forbidden, resp.Code())
+
+	// the verifier issues passports only to identified devices
+	resp, err = dial(verifierAddr, nil).Get(ctx, DefaultPassportPath)
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthorized, resp.Code())
+}
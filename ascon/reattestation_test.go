@@ -0,0 +1,168 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/runner/periodic"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+)
+
+// switchAttester quotes the compromised measurement once it is compromised.
+type switchAttester struct {
+	good, compromised attestation.Attester
+	isCompromised     atomic.Bool
+}
+
+func (a *switchAttester) Quote(nonce []byte) ([]byte, error) {
+	if a.isCompromised.Load() {
+		return a.compromised.Quote(nonce)
+	}
+	return a.good.Quote(nonce)
+}
+
+func TestServerReattestation(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	measurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	golden, err := measurer.Measure()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		action connection.ReattestationAction
+	}{
+		{name: "quarantine", action: connection.ReattestationQuarantine},
+		{name: "close", action: connection.ReattestationClose},
+		{name: "callback", action: connection.ReattestationCallback},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+
+			references := &attestation.ReferenceValues{}
+			references.Add(attestation.Reference{AttestationKeys: [][]byte{publicKey}, PCRs: []attestation.Digest{golden.PCR}})
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+
+			failed := make(chan *connection.Conn, 1)
+			stop := make(chan struct{})
+			defer close(stop)
+			s := NewServer(options.WithMux(m),
+				options.WithPeriodicRunner(periodic.New(stop, time.Millisecond*10)),
+				options.WithReattestation(connection.Reattestation{
+					Verifier: attestation.NewVerifier(references, 0),
+					Interval: time.Millisecond * 50,
+					Timeout:  time.Second,
+					Action:   tt.action,
+					OnFailure: func(cc *connection.Conn, result *attestation.AttestationResult, err error) {
+						require.NoError(t, err)
+						require.Equal(t, attestation.StatusContraindicated, result.Status)
+						select {
+						case failed <- cc:
+						default:
+						}
+					},
+				}))
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			attester := &switchAttester{
+				good:        &attestation.SoftwareAttester{Measurer: measurer, Key: key},
+				compromised: &attestation.SoftwareAttester{Measurer: &attestation.Measurer{Config: []byte("compromised")}, Key: key},
+			}
+			cc, err := Dial(l.LocalAddr().String(), options.WithAttester(attester))
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+			}()
+
+			post := func() codes.Code {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+				defer cancel()
+				resp, errP := cc.Post(ctx, "/a", message.TextPlain, bytes.NewReader([]byte("b")))
+				require.NoError(t, errP)
+				return resp.Code()
+			}
+			// the attested device isn't restricted
+			time.Sleep(time.Millisecond * 200)
+			require.Equal(t, codes.Content, post())
+
+			attester.isCompromised.Store(true)
+			var serverConn *connection.Conn
+			select {
+			case serverConn = <-failed:
+			case <-time.After(time.Second * 3):
+				require.FailNow(t, "re-attestation didn't fail")
+			}
+			switch tt.action {
+			case connection.ReattestationQuarantine:
+				require.True(t, serverConn.Quarantined())
+				require.Equal(t, codes.Forbidden, post())
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+				defer cancel()
+				resp, errG := cc.Get(ctx, "/a")
+				require.NoError(t, errG)
+				require.Equal(t, codes.Content, resp.Code())
+				// the codes which are unknown to the library are refused as well
+				req := cc.AcquireMessage(ctx)
+				defer cc.ReleaseMessage(req)
+				token, errT := cc.GetToken()
+				require.NoError(t, errT)
+				req.SetCode(codes.Code(7))
+				req.SetToken(token)
+				require.NoError(t, req.SetPath("/a"))
+				resp, errG = cc.Do(req)
+				require.NoError(t, errG)
+				require.Equal(t, codes.Forbidden, resp.Code())
+
+				// the accepted attestation lifts the quarantine
+				attester.isCompromised.Store(false)
+				require.Eventually(t, func() bool {
+					return !serverConn.Quarantined()
+				}, time.Second*3, time.Millisecond*10)
+				require.Equal(t, codes.Content, post())
+			case connection.ReattestationClose:
+				select {
+				case <-serverConn.Done():
+				case <-time.After(time.Second * 3):
+					require.FailNow(t, "connection wasn't closed")
+				}
+			case connection.ReattestationCallback:
+				require.False(t, serverConn.Quarantined())
+				require.Equal(t, codes.Content, post())
+			}
+		})
+	}
+}
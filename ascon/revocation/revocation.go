@@ -0,0 +1,174 @@
+// Package revocation blocks devices whose identity key leaked or whose firmware is known to be bad.
+// The server consults the list when the handshake completes and when the attestation is verified,
+// and it closes the sessions of the peers which become revoked.
+package revocation
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+)
+
+var ErrRevoked = errors.New("revoked")
+
+// Revoked is the JSON content of the revocation list.
+type Revoked struct {
+	// Fingerprints of the identity keys, see keystore.Fingerprint.
+	Fingerprints []string `json:"fingerprints,omitempty"`
+	// DeviceIDs are the pre-shared key identities and the hex encoded UEIDs of the devices.
+	DeviceIDs []string `json:"deviceIds,omitempty"`
+	// Measurements are the forbidden PCR values and digests of the measured objects.
+	Measurements []attestation.Digest `json:"measurements,omitempty"`
+}
+
+// Parse decodes the JSON revocation list.
+func Parse(data []byte) (Revoked, error) {
+	var r Revoked
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Revoked{}, fmt.Errorf("cannot parse revocation list: %w", err)
+	}
+	return r, nil
+}
+
+// List is the revocation list which is enforced by the connections. It is safe for concurrent use.
+type List struct {
+	mutex        sync.RWMutex
+	fingerprints map[string]bool
+	deviceIDs    map[string]bool
+	measurements map[string]bool
+	version      atomic.Uint64
+
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// NewList creates the list of the revoked values.
+func NewList(r Revoked) *List {
+	l := &List{}
+	l.Set(r)
+	return l
+}
+
+// OpenList loads the list from the JSON file, which is reloaded by Reload.
+func OpenList(path string) (*List, error) {
+	l := &List{path: path}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Set replaces the revoked values.
+func (l *List) Set(r Revoked) {
+	fingerprints := make(map[string]bool, len(r.Fingerprints))
+	for _, f := range r.Fingerprints {
+		fingerprints[f] = true
+	}
+	deviceIDs := make(map[string]bool, len(r.DeviceIDs))
+	for _, id := range r.DeviceIDs {
+		deviceIDs[id] = true
+	}
+	measurements := make(map[string]bool, len(r.Measurements))
+	for _, m := range r.Measurements {
+		measurements[string(m)] = true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.fingerprints = fingerprints
+	l.deviceIDs = deviceIDs
+	l.measurements = measurements
+	l.version.Add(1)
+}
+
+// Version is incremented by every change of the list.
+func (l *List) Version() uint64 {
+	return l.version.Load()
+}
+
+// Reload reads the file of the list again when it was modified since it was read.
+func (l *List) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return fmt.Errorf("cannot read revocation list: %w", err)
+	}
+	l.mutex.RLock()
+	unchanged := info.ModTime().Equal(l.modTime) && info.Size() == l.size
+	l.mutex.RUnlock()
+	if unchanged {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return fmt.Errorf("cannot read revocation list: %w", err)
+	}
+	r, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("%v: %w", l.path, err)
+	}
+	l.Set(r)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.modTime = info.ModTime()
+	l.size = info.Size()
+	return nil
+}
+
+// CheckKey returns ErrRevoked when the fingerprint of the identity key is revoked.
+func (l *List) CheckKey(publicKey ed25519.PublicKey) error {
+	fingerprint := keystore.Fingerprint(publicKey)
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.fingerprints[fingerprint] {
+		return fmt.Errorf("identity key %v: %w", fingerprint, ErrRevoked)
+	}
+	return nil
+}
+
+// CheckDeviceID returns ErrRevoked when the device ID is revoked.
+func (l *List) CheckDeviceID(id string) error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.deviceIDs[id] {
+		return fmt.Errorf("device %v: %w", id, ErrRevoked)
+	}
+	return nil
+}
+
+// CheckResult returns ErrRevoked when the UEID or a measurement of the attestation result is revoked.
+func (l *List) CheckResult(result *attestation.AttestationResult) error {
+	if result == nil {
+		return nil
+	}
+	if len(result.UEID) > 0 {
+		if err := l.CheckDeviceID(hex.EncodeToString(result.UEID)); err != nil {
+			return err
+		}
+	}
+	if result.Log == nil {
+		return nil
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.measurements[string(result.Log.PCR)] {
+		return fmt.Errorf("pcr %x: %w", result.Log.PCR, ErrRevoked)
+	}
+	for _, e := range result.Log.Entries {
+		if l.measurements[string(e.Digest)] {
+			return fmt.Errorf("%v %v %x: %w", e.Type, e.Name, e.Digest, ErrRevoked)
+		}
+	}
+	return nil
+}
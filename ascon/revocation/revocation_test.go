@@ -0,0 +1,65 @@
+package revocation
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	key, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	publicKey := key.Public().(ed25519.PublicKey)
+	log, err := (&attestation.Measurer{Config: []byte("firmware 0.9")}).Measure()
+	require.NoError(t, err)
+	result := &attestation.AttestationResult{UEID: []byte{0x01, 0xaa}, Log: log}
+
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o600))
+	l, err := OpenList(path)
+	require.NoError(t, err)
+	version := l.Version()
+	require.NoError(t, l.CheckKey(publicKey))
+	require.NoError(t, l.CheckDeviceID("device"))
+	require.NoError(t, l.CheckResult(result))
+
+	// the unchanged file isn't parsed again
+	require.NoError(t, l.Reload())
+	require.Equal(t, version, l.Version())
+
+	tests := []struct {
+		name    string
+		revoked string
+		check   func() error
+	}{
+		{name: "fingerprint", revoked: `{"fingerprints":["` + keystore.Fingerprint(publicKey) + `"]}`, check: func() error { return l.CheckKey(publicKey) }},
+		{name: "psk-identity", revoked: `{"deviceIds":["device"]}`, check: func() error { return l.CheckDeviceID("device") }},
+		{name: "ueid", revoked: `{"deviceIds":["` + hex.EncodeToString(result.UEID) + `"]}`, check: func() error { return l.CheckResult(result) }},
+		{name: "pcr", revoked: `{"measurements":["` + hex.EncodeToString(log.PCR) + `"]}`, check: func() error { return l.CheckResult(result) }},
+		{name: "entry", revoked: `{"measurements":["` + hex.EncodeToString(log.Entries[0].Digest) + `"]}`, check: func() error { return l.CheckResult(result) }},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(tt.revoked), 0o600))
+			// the modification time may not change within the resolution of the file system
+			require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Duration(i+1)*time.Second)))
+			require.NoError(t, l.Reload())
+			require.Greater(t, l.Version(), version)
+			version = l.Version()
+			require.ErrorIs(t, tt.check(), ErrRevoked)
+		})
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"fingerprints":`), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.Error(t, l.Reload())
+	// the last valid list stays in force
+	require.ErrorIs(t, l.CheckResult(result), ErrRevoked)
+}
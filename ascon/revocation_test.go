@@ -0,0 +1,125 @@
+package ascon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/revocation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/runner/periodic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerRevocations(t *testing.T) {
+	attestationKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	attestationPublicKey, err := x509.MarshalPKIXPublicKey(attestationKey.Public())
+	require.NoError(t, err)
+	goodMeasurer := &attestation.Measurer{Config: []byte("firmware 1.0")}
+	good, err := goodMeasurer.Measure()
+	require.NoError(t, err)
+	badMeasurer := &attestation.Measurer{Config: []byte("firmware 0.9")}
+	bad, err := badMeasurer.Measure()
+	require.NoError(t, err)
+	// the bad firmware was trusted before it was found vulnerable
+	references := &attestation.ReferenceValues{}
+	references.Add(attestation.Reference{AttestationKeys: [][]byte{attestationPublicKey}, PCRs: []attestation.Digest{good.PCR, bad.PCR}})
+
+	path := filepath.Join(t.TempDir(), "revoked.json")
+	writeList := func(revoked string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(revoked), 0o600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	writeList(`{"measurements":["`+hex.EncodeToString(bad.PCR)+`"]}`, time.Now().Add(-time.Minute))
+	revocations, err := revocation.OpenList(path)
+	require.NoError(t, err)
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	m := mux.NewRouter()
+	conns := make(chan *connection.Conn, 1)
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		select {
+		case conns <- w.Conn().(*connection.Conn):
+		default:
+		}
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	s := NewServer(options.WithMux(m),
+		options.WithPeriodicRunner(periodic.New(stop, time.Millisecond*10)),
+		options.WithRevocations(revocations),
+		options.WithHandshakeAttestation(connection.HandshakeAttestation{Verifier: attestation.NewVerifier(references, 0)}),
+	)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	dial := func(ks keystore.Keystore, measurer *attestation.Measurer) (*connection.Conn, error) {
+		return Dial(l.LocalAddr().String(),
+			options.WithKeystore(ks),
+			options.WithAttester(&attestation.SoftwareAttester{Measurer: measurer, Key: attestationKey}),
+			options.WithAttestInHandshake(),
+		)
+	}
+	identityKey, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	ks := keystore.NewMemoryStore()
+	require.NoError(t, ks.SetIdentityKey(identityKey))
+
+	// the forbidden measurement is refused at the attestation
+	_, err = dial(ks, badMeasurer)
+	require.Error(t, err)
+
+	cc, err := dial(ks, goodMeasurer)
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	resp, err := cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	serverConn := <-conns
+
+	// the connected session is closed when its identity key is revoked
+	writeList(`{"fingerprints":["`+keystore.Fingerprint(identityKey.Public().(ed25519.PublicKey))+`"]}`, time.Now())
+	select {
+	case <-serverConn.Done():
+	case <-time.After(time.Second * 3):
+		require.FailNow(t, "revoked session wasn't closed")
+	}
+
+	// the revoked identity key is refused at the handshake
+	_, err = dial(ks, goodMeasurer)
+	require.Error(t, err)
+}
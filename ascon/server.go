@@ -114,6 +114,24 @@ func (s *Server) checkAndSetListener(l *coapNet.UDPConn) error {
 	return nil
 }
 
+func (s *Server) getListener() *coapNet.UDPConn {
+	s.listenMutex.Lock()
+	defer s.listenMutex.Unlock()
+	return s.listen
+}
+
+// Stop stops server without wait of ends Serve function.
+func (s *Server) Stop() {
+	s.cancel()
+	l := s.getListener()
+	if l != nil {
+		if errC := l.Close(); errC != nil {
+			s.cfg.Errors(fmt.Errorf("cannot close listener: %w", errC))
+		}
+	}
+	s.closeSessions()
+}
+
 func (s *Server) closeConnection(cc *connection.Conn) {
 	if err := cc.Close(); err != nil {
 		s.cfg.Errors(fmt.Errorf("cannot close connection: %w", err))
@@ -140,6 +158,10 @@ func (s *Server) closeSessions() {
 	s.conns = make(map[string]*connection.Conn)
 	s.connsMutex.Unlock()
 	for _, cc := range conns {
+		// keep the latest state, so the sessions are resumed after a restart
+		if err := cc.SaveSession(); err != nil {
+			s.cfg.Errors(fmt.Errorf("%v: cannot save session: %w", cc.RemoteAddr(), err))
+		}
 		s.closeConnection(cc)
 		if closeFn := getClose(cc); closeFn != nil {
 			closeFn()
@@ -238,6 +260,22 @@ func (s *Server) getOrCreateConn(asconConn *coapNet.UDPConn, raddr *net.UDPAddr)
 	cfg.MessagePool = s.cfg.MessagePool
 	cfg.ProcessReceivedMessage = s.cfg.ProcessReceivedMessage
 	cfg.ReceivedMessageQueueSize = s.cfg.ReceivedMessageQueueSize
+	cfg.HybridKeyExchange = s.cfg.HybridKeyExchange
+	cfg.Logger = s.cfg.Logger
+	cfg.KeyLogWriter = s.cfg.KeyLogWriter
+	cfg.SessionStore = s.cfg.SessionStore
+	cfg.SessionMasterKey = s.cfg.SessionMasterKey
+	cfg.Attester = s.cfg.Attester
+	cfg.Reattestation = s.cfg.Reattestation
+	cfg.AttestInHandshake = s.cfg.AttestInHandshake
+	cfg.HandshakeAttestation = s.cfg.HandshakeAttestation
+	cfg.AuditLog = s.cfg.AuditLog
+	cfg.Revocations = s.cfg.Revocations
+	cfg.GroupAttestation = s.cfg.GroupAttestation
+	cfg.AttestationResultLifetime = s.cfg.AttestationResultLifetime
+	cfg.PolicyVersion = s.cfg.PolicyVersion
+	cfg.Keystore = s.cfg.Keystore
+	cfg.RequirePeerAuthentication = s.cfg.RequirePeerAuthentication
 
 	cc = connection.NewConn(
 		session,
@@ -316,6 +354,14 @@ func (s *Server) Serve(l *coapNet.UDPConn) error {
 	s.cfg.PeriodicRunner(func(now time.Time) bool {
 		s.handleInactivityMonitors(now)
 		s.responseMsgCache.CheckExpirations(now)
+		if store, ok := s.cfg.SessionStore.(interface{ CheckExpirations(time.Time) }); ok {
+			store.CheckExpirations(now)
+		}
+		if s.cfg.Revocations != nil {
+			if err := s.cfg.Revocations.Reload(); err != nil {
+				s.cfg.Errors(err)
+			}
+		}
 		return s.ctx.Err() == nil
 	})
 
@@ -340,7 +386,6 @@ func (s *Server) Serve(l *coapNet.UDPConn) error {
 		cc, err := s.getConn(l, raddr, true)
 		if err != nil {
 			s.cfg.Errors(fmt.Errorf("%v: cannot get client connection: %w", raddr, err))
-			fmt.Println("Could Not Connect")
 			continue
 		}
 
@@ -348,9 +393,6 @@ func (s *Server) Serve(l *coapNet.UDPConn) error {
 		if err != nil {
 			s.closeConnection(cc)
 			s.cfg.Errors(fmt.Errorf("%v: cannot process packet: %w", cc.RemoteAddr(), err))
-			fmt.Println("Could Not Process")
 		}
-
-		fmt.Printf("Finished with message\n\n")
 	}
 }
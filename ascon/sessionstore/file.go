@@ -0,0 +1,138 @@
+package sessionstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const fileSuffix = ".session"
+
+// DefaultSweepInterval is the interval in which the FileStore removes the files of the expired sessions.
+const DefaultSweepInterval = 10 * time.Minute
+
+type fileContent struct {
+	Expires time.Time `json:"expires"`
+	Sealed  []byte    `json:"sealed"`
+}
+
+// FileStore keeps every session in a file of the directory. The directory can be shared by
+// the replicas of a server. The modification time of a file is set to the expiration of its session,
+// so the expired files are found without reading them.
+type FileStore struct {
+	dir       string
+	mutex     sync.Mutex
+	nextSweep time.Time
+}
+
+// NewFileStore creates the directory if it doesn't exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create session store: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path hashes the key, so addresses can't escape the directory.
+func (s *FileStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+fileSuffix)
+}
+
+func (s *FileStore) Save(key string, sealed []byte, expires time.Time) error {
+	data, err := json.Marshal(fileContent{Expires: expires, Sealed: sealed})
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	_, err = tmp.Write(data)
+	if errC := tmp.Close(); err == nil {
+		err = errC
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), expires, expires)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) Load(key string) ([]byte, error) {
+	content, err := s.read(s.path(key))
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(content.Expires) {
+		_ = s.Delete(key)
+		return nil, ErrNotFound
+	}
+	return content.Sealed, nil
+}
+
+func (s *FileStore) read(path string) (fileContent, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileContent{}, ErrNotFound
+	}
+	if err != nil {
+		return fileContent{}, fmt.Errorf("cannot load session: %w", err)
+	}
+	var content fileContent
+	if err = json.Unmarshal(data, &content); err != nil {
+		return fileContent{}, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	return content, nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot delete session: %w", err)
+	}
+	return nil
+}
+
+// CheckExpirations removes the files of the expired sessions once per DefaultSweepInterval,
+// the expired sessions which are loaded in between are removed by Load.
+func (s *FileStore) CheckExpirations(now time.Time) {
+	s.mutex.Lock()
+	if now.Before(s.nextSweep) {
+		s.mutex.Unlock()
+		return
+	}
+	s.nextSweep = now.Add(DefaultSweepInterval)
+	s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), fileSuffix) {
+			continue
+		}
+		info, err := e.Info()
+		if err == nil && now.After(info.ModTime()) {
+			_ = os.Remove(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
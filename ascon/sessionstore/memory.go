@@ -0,0 +1,42 @@
+package sessionstore
+
+import (
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/cache"
+)
+
+// MemoryStore keeps the sessions in memory. It survives the restart of a server which is
+// created in the same process, e.g. after Stop.
+type MemoryStore struct {
+	cache *cache.Cache[string, []byte]
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		cache: cache.NewCache[string, []byte](),
+	}
+}
+
+func (s *MemoryStore) Save(key string, sealed []byte, expires time.Time) error {
+	s.cache.Store(key, cache.NewElement(append([]byte(nil), sealed...), expires, nil))
+	return nil
+}
+
+func (s *MemoryStore) Load(key string) ([]byte, error) {
+	e := s.cache.Load(key)
+	if e == nil {
+		return nil, ErrNotFound
+	}
+	return e.Data(), nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.cache.Delete(key)
+	return nil
+}
+
+// CheckExpirations removes the expired sessions.
+func (s *MemoryStore) CheckExpirations(now time.Time) {
+	s.cache.CheckExpirations(now)
+}
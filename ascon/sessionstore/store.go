@@ -0,0 +1,104 @@
+// Package sessionstore persists the state of ASCON sessions, so a restarted server, or another replica
+// behind the same address, resumes the sessions of its peers without a new handshake.
+//
+// The state is sealed with ASCON under a master key of the servers before it is passed to the store,
+// so a store never sees session keys in plaintext.
+package sessionstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+)
+
+// DefaultLifetime is the time a session is stored after its last update.
+const DefaultLifetime = 24 * time.Hour
+
+var (
+	ErrNotFound         = errors.New("session not found")
+	ErrInvalidMasterKey = fmt.Errorf("master key must have %v bytes", coder.KeyBytes)
+	ErrInvalidState     = errors.New("invalid session state")
+)
+
+// Store keeps the sealed session states by the address of the peer.
+type Store interface {
+	// Save stores the sealed state until it expires.
+	Save(key string, sealed []byte, expires time.Time) error
+	// Load returns the sealed state or ErrNotFound when it doesn't exist or it has expired.
+	Load(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// State is the state of a session.
+type State struct {
+	// Address of the peer, the key of the session in the store.
+	Address string `json:"address"`
+	// SessionID is the transcript hash of the key exchange.
+	SessionID []byte `json:"sessionId"`
+	Key       []byte `json:"key"`
+	// Established is set when the peer proved possession of the key, otherwise the key is pending.
+	Established bool `json:"established"`
+	// MessageID is the next message ID of the server.
+	MessageID       uint32 `json:"messageId"`
+	PeerPSKIdentity string `json:"peerPskIdentity,omitempty"`
+	PeerPublicKey   []byte `json:"peerPublicKey,omitempty"`
+	PeerTrustAnchor string `json:"peerTrustAnchor,omitempty"`
+	// PeerAttested is set when the peer passed the attestation in the handshake.
+	PeerAttested bool `json:"peerAttested,omitempty"`
+}
+
+// Wipe overwrites the key of the state.
+func (s *State) Wipe() {
+	coder.Wipe(s.Key)
+	s.Key = nil
+}
+
+// Seal encrypts the state under the master key: nonce || ciphertext || tag.
+func Seal(masterKey []byte, state State) ([]byte, error) {
+	if len(masterKey) != coder.KeyBytes {
+		return nil, ErrInvalidMasterKey
+	}
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	defer coder.Wipe(plaintext)
+	nonce := coder.RandomBytes(coder.NonceBytes)
+	if nonce == nil {
+		return nil, errors.New("cannot generate nonce")
+	}
+	ciphertext, tag := coder.Encrypt(masterKey, nonce, plaintext)
+	sealed := make([]byte, 0, len(nonce)+len(ciphertext)+len(tag))
+	sealed = append(sealed, nonce...)
+	sealed = append(sealed, ciphertext...)
+	return append(sealed, tag...), nil
+}
+
+// Open decrypts the state of the address which was sealed under the master key.
+func Open(masterKey []byte, address string, sealed []byte) (State, error) {
+	if len(masterKey) != coder.KeyBytes {
+		return State{}, ErrInvalidMasterKey
+	}
+	if len(sealed) < coder.NonceBytes+coder.TagBytes {
+		return State{}, ErrInvalidState
+	}
+	n := len(sealed) - coder.TagBytes
+	plaintext := coder.Decrypt(masterKey, sealed[:coder.NonceBytes], sealed[coder.NonceBytes:n], sealed[n:])
+	if plaintext == nil {
+		return State{}, fmt.Errorf("%w: authentication failed", ErrInvalidState)
+	}
+	defer coder.Wipe(plaintext)
+	var state State
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return State{}, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	// the address is sealed with the state, so the state of one peer can't be replayed for another one
+	if state.Address != address || len(state.Key) != coder.KeyBytes {
+		state.Wipe()
+		return State{}, ErrInvalidState
+	}
+	return state, nil
+}
@@ -0,0 +1,98 @@
+package sessionstore
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSealOpen(t *testing.T) {
+	masterKey := coder.RandomBytes(coder.KeyBytes)
+	state := State{
+		Address:         "192.0.2.1:5683",
+		SessionID:       coder.Transcript([]byte("client"), []byte("server")),
+		Key:             coder.RandomBytes(coder.KeyBytes),
+		Established:     true,
+		MessageID:       42,
+		PeerPSKIdentity: "device",
+	}
+	sealed, err := Seal(masterKey, state)
+	require.NoError(t, err)
+	require.NotContains(t, string(sealed), "device")
+
+	opened, err := Open(masterKey, state.Address, sealed)
+	require.NoError(t, err)
+	require.Equal(t, state, opened)
+
+	_, err = Open(coder.RandomBytes(coder.KeyBytes), state.Address, sealed)
+	require.ErrorIs(t, err, ErrInvalidState)
+	_, err = Open(masterKey, "192.0.2.2:5683", sealed)
+	require.ErrorIs(t, err, ErrInvalidState)
+	_, err = Seal(masterKey[1:], state)
+	require.ErrorIs(t, err, ErrInvalidMasterKey)
+}
+
+func TestStores(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	tests := []struct {
+		name  string
+		store interface {
+			Store
+			CheckExpirations(now time.Time)
+		}
+	}{
+		{name: "memory", store: NewMemoryStore()},
+		{name: "file", store: fileStore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			require.NoError(t, tt.store.Save("a", []byte("state a"), now.Add(time.Hour)))
+			require.NoError(t, tt.store.Save("b", []byte("state b"), now.Add(time.Hour)))
+			require.NoError(t, tt.store.Save("a", []byte("state a2"), now.Add(time.Minute)))
+
+			sealed, err := tt.store.Load("a")
+			require.NoError(t, err)
+			require.Equal(t, []byte("state a2"), sealed)
+			_, err = tt.store.Load("c")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			require.NoError(t, tt.store.Delete("b"))
+			require.NoError(t, tt.store.Delete("b"))
+			_, err = tt.store.Load("b")
+			require.ErrorIs(t, err, ErrNotFound)
+
+			tt.store.CheckExpirations(now.Add(2 * time.Minute))
+			_, err = tt.store.Load("a")
+			require.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
+
+func TestFileStoreSweep(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, store.Save("a", []byte("state a"), now.Add(time.Minute)))
+	require.NoError(t, store.Save("b", []byte("state b"), now.Add(time.Hour)))
+
+	store.CheckExpirations(now.Add(2 * time.Minute))
+	_, err = os.Stat(store.path("a"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = store.Load("b")
+	require.NoError(t, err)
+
+	// the directory isn't swept again before the interval passes
+	require.NoError(t, store.Save("c", []byte("state c"), now.Add(time.Minute)))
+	store.CheckExpirations(now.Add(3 * time.Minute))
+	_, err = os.Stat(store.path("c"))
+	require.NoError(t, err)
+	store.CheckExpirations(now.Add(2*time.Minute + DefaultSweepInterval))
+	_, err = os.Stat(store.path("c"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,180 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	coapTCP "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/client"
+	tcpCoder "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
+)
+
+// DefaultHandshakeTimeout is the time for which the client waits for the key exchange.
+const DefaultHandshakeTimeout = 3 * time.Second
+
+// Dial creates a client connection to the given target and runs the handshake.
+// The connection isn't wrapped by TLS, options.WithTLS is ignored.
+//
+// Besides the options of CoAP over TCP, it accepts the options of the ASCON handshake which apply to it:
+// options.WithHybridKeyExchange, options.WithKeystore, options.WithPSKIdentity and options.WithPeerAuthentication.
+func Dial(target string, opts ...any) (*client.Conn, error) {
+	tcpOpts, _, err := clientOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := client.DefaultConfig
+	for _, o := range tcpOpts {
+		o.TCPClientApply(&cfg)
+	}
+	conn, err := cfg.Dialer.DialContext(cfg.Ctx, cfg.Net, target)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, options.WithCloseSocket())
+	cc, err := Client(conn, opts...)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// Client creates the client over the tcp connection and runs the handshake: it sends the key share by the
+// KeyExchange signal and derives the session key from the answer of the server. Then the client finished is
+// sent under the session key by a HANDSHAKE request, which the server answers by the server finished.
+// The finished messages confirm the key and prove the credentials of the peers like the handshake over UDP.
+func Client(conn net.Conn, opts ...any) (*client.Conn, error) {
+	tcpOpts, cfg, err := clientOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	codec := NewClientCodec()
+	tcpOpts = append(tcpOpts, options.WithCodec(func() tcpCoder.Codec {
+		return codec
+	}))
+	cc := coapTCP.Client(conn, tcpOpts...)
+	cc.AddOnClose(codec.Close)
+	ctx, cancel := context.WithTimeout(cc.Context(), DefaultHandshakeTimeout)
+	defer cancel()
+	if err = handshake(ctx, cc, codec, cfg); err != nil {
+		_ = cc.Close()
+		return nil, fmt.Errorf("cannot handshake: %w", err)
+	}
+	return cc, nil
+}
+
+// clientOptions splits the options into the options of CoAP over TCP and the configuration of the handshake.
+// The options which don't apply to ASCON over TCP are refused, so they aren't silently ignored.
+func clientOptions(opts []any) ([]coapTCP.Option, connection.Config, error) {
+	var tcpOpts []coapTCP.Option
+	var cfg connection.Config
+	for _, o := range opts {
+		switch o := o.(type) {
+		case coapTCP.Option:
+			tcpOpts = append(tcpOpts, o)
+		case options.HybridKeyExchangeOpt:
+			o.ASCONClientApply(&cfg)
+		case options.KeystoreOpt:
+			o.ASCONClientApply(&cfg)
+		case options.PSKIdentityOpt:
+			o.ASCONClientApply(&cfg)
+		case options.PeerAuthenticationOpt:
+			o.ASCONClientApply(&cfg)
+		default:
+			return nil, connection.Config{}, fmt.Errorf("option %T doesn't apply to the ASCON client over TCP", o)
+		}
+	}
+	return tcpOpts, cfg, nil
+}
+
+// PeerIdentity returns the identity which the peer of the connection proved in the handshake.
+func PeerIdentity(cc *client.Conn) connection.PeerIdentity {
+	codec, ok := connCodec(cc)
+	if !ok {
+		return connection.PeerIdentity{}
+	}
+	return codec.PeerIdentity()
+}
+
+func handshake(ctx context.Context, cc *client.Conn, codec *Codec, cfg connection.Config) error {
+	newKeyShare := coder.NewX25519KeyShare
+	if cfg.HybridKeyExchange {
+		newKeyShare = coder.NewHybridKeyShare
+	}
+	keyShare, err := newKeyShare()
+	if err != nil {
+		return fmt.Errorf("cannot create key share: %w", err)
+	}
+	defer keyShare.Wipe()
+
+	resp, err := do(ctx, cc, codes.KeyExchange, keyShare.Public(), nil)
+	if err != nil {
+		return fmt.Errorf("cannot send key share: %w", err)
+	}
+	defer cc.ReleaseMessage(resp)
+	if resp.Code() != codes.KeyExchange {
+		return fmt.Errorf("server rejected key exchange: %v", resp.Code())
+	}
+	serverShare, err := resp.ReadBody()
+	if err != nil {
+		return fmt.Errorf("cannot read server key share: %w", err)
+	}
+	sessionKey, err := keyShare.SessionKey(serverShare)
+	if err != nil {
+		return fmt.Errorf("invalid server key share: %w", err)
+	}
+	transcript := coder.Transcript(keyShare.Public(), serverShare)
+	codec.setTranscript(transcript)
+	codec.SetSecret(sessionKey)
+
+	// the sealed client finished activates the key of the server
+	authenticator := connection.NewAuthenticator(cfg)
+	clientFinished, err := authenticator.ClientFinished(transcript)
+	if err != nil {
+		return err
+	}
+	contentFormat := message.AppOctets
+	resp, err = do(ctx, cc, codes.HANDSHAKE, clientFinished, &contentFormat)
+	if err != nil {
+		return fmt.Errorf("cannot send client finished: %w", err)
+	}
+	defer cc.ReleaseMessage(resp)
+	if resp.Code() != codes.Content {
+		return fmt.Errorf("server rejected client finished: %v", resp.Code())
+	}
+	serverFinished, err := resp.ReadBody()
+	if err != nil {
+		return fmt.Errorf("cannot read server finished: %w", err)
+	}
+	identity, err := authenticator.VerifyServerFinished(serverFinished, transcript)
+	if err != nil {
+		return err
+	}
+	codec.finish(identity)
+	return nil
+}
+
+func do(ctx context.Context, cc *client.Conn, code codes.Code, body []byte, contentFormat *message.MediaType) (*pool.Message, error) {
+	token, err := cc.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token: %w", err)
+	}
+	req := cc.AcquireMessage(ctx)
+	defer cc.ReleaseMessage(req)
+	req.SetCode(code)
+	req.SetToken(token)
+	if contentFormat != nil {
+		req.SetContentFormat(*contentFormat)
+	}
+	req.SetBody(bytes.NewReader(body))
+	return cc.Do(req)
+}
@@ -0,0 +1,372 @@
+package tcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	coapTCP "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/client"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingConn records the bytes which are written to the connection.
+type recordingConn struct {
+	net.Conn
+	mutex   sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	c.written.Write(b)
+	c.mutex.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *recordingConn) Written() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]byte(nil), c.written.Bytes()...)
+}
+
+func TestConnGet(t *testing.T) {
+	l, err := coapNet.NewTCPListener("tcp", "")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	secret := []byte("secret payload")
+	m := mux.NewRouter()
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body, errR := r.ReadBody()
+		require.NoError(t, errR)
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader(append(body, '!')))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	s, err := NewServer(options.WithMux(m))
+	require.NoError(t, err)
+	defer s.Stop()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		require.NoError(t, errS)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	rc := &recordingConn{Conn: conn}
+	cc, err := Client(rc, options.WithCloseSocket())
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+		<-cc.Done()
+	}()
+	codec, ok := connCodec(cc)
+	require.True(t, ok)
+	require.True(t, codec.IsEstablished())
+
+	resp, err := cc.Post(ctx, "/a", message.TextPlain, bytes.NewReader(secret))
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	require.Equal(t, append(secret, '!'), body)
+	require.NoError(t, cc.Ping(ctx))
+	require.False(t, bytes.Contains(rc.Written(), secret))
+	// neither the payload nor the Uri-Path option is sent in plaintext
+	require.False(t, bytes.Contains(rc.Written(), []byte{0xb1, 'a'}))
+
+	// the plain client isn't served before the key exchange
+	plain, err := coapTCP.Dial(l.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		errC := plain.Close()
+		require.NoError(t, errC)
+		<-plain.Done()
+	}()
+	resp, err = plain.Post(ctx, "/a", message.TextPlain, bytes.NewReader(secret))
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthorized, resp.Code())
+
+	// the second key exchange of a connection is refused
+	err = handshake(ctx, cc, NewClientCodec(), connection.Config{})
+	require.Error(t, err)
+}
+
+func TestCodecRejectsPlaintextAfterHandshake(t *testing.T) {
+	key := make([]byte, coder.KeyBytes)
+	sealing := NewClientCodec()
+	sealing.SetSecret(coder.NewSecretKey(key))
+	opening := NewServerCodec()
+	opening.SetPendingSecret(coder.NewSecretKey(key))
+
+	m := message.Message{Code: codes.GET, Token: []byte{1, 2}, Payload: []byte("hello")}
+	size, err := sealing.Size(m)
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	n, err := sealing.Encode(m, buf)
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+
+	var decoded message.Message
+	n, err = opening.Decode(append([]byte(nil), buf...), &decoded)
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+	require.True(t, opening.IsEstablished())
+	require.Equal(t, m.Code, decoded.Code)
+	require.Equal(t, m.Payload, decoded.Payload)
+
+	plain := NewClientCodec()
+	size, err = plain.Size(m)
+	require.NoError(t, err)
+	buf = make([]byte, size)
+	_, err = plain.Encode(m, buf)
+	require.NoError(t, err)
+	_, err = opening.Decode(buf, &decoded)
+	require.Error(t, err)
+
+	opening.Close()
+	_, err = opening.Size(m)
+	require.NoError(t, err)
+	_, err = opening.Encode(m, buf)
+	require.Error(t, err)
+}
+
+func encodeFrame(t *testing.T, c *Codec, m message.Message) []byte {
+	size, err := c.Size(m)
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	n, err := c.Encode(m, buf)
+	require.NoError(t, err)
+	return buf[:n]
+}
+
+func TestCodecRejectsReplayedFrames(t *testing.T) {
+	key := make([]byte, coder.KeyBytes)
+	client := NewClientCodec()
+	client.SetSecret(coder.NewSecretKey(key))
+	server := NewServerCodec()
+	server.SetSecret(coder.NewSecretKey(key))
+
+	first := encodeFrame(t, client, message.Message{Code: codes.GET, Token: []byte{1}})
+	second := encodeFrame(t, client, message.Message{Code: codes.GET, Token: []byte{2}})
+	third := encodeFrame(t, client, message.Message{Code: codes.GET, Token: []byte{3}})
+
+	var decoded message.Message
+	_, err := server.Decode(bytes.Clone(second), &decoded)
+	require.NoError(t, err)
+	require.Equal(t, message.Token{2}, decoded.Token)
+	// the frame which was sent before the last one is reordered
+	_, err = server.Decode(bytes.Clone(first), &decoded)
+	require.ErrorIs(t, err, coder.ErrMessageAuthentication)
+	// the frame is replayed
+	_, err = server.Decode(bytes.Clone(second), &decoded)
+	require.ErrorIs(t, err, coder.ErrMessageAuthentication)
+	_, err = server.Decode(bytes.Clone(third), &decoded)
+	require.NoError(t, err)
+	require.Equal(t, message.Token{3}, decoded.Token)
+
+	// the frame of the server is reflected back to it
+	reflected := encodeFrame(t, server, message.Message{Code: codes.Content, Token: []byte{4}})
+	_, err = server.Decode(reflected, &decoded)
+	require.ErrorIs(t, err, coder.ErrMessageAuthentication)
+}
+
+func TestCodecEncodesSizedFrame(t *testing.T) {
+	key := make([]byte, coder.KeyBytes)
+	client := NewClientCodec()
+	m := message.Message{Code: codes.GET, Token: []byte{1}, Payload: []byte("hello")}
+	size, err := client.Size(m)
+	require.NoError(t, err)
+	// the key is activated between sizing and encoding the frame
+	client.SetSecret(coder.NewSecretKey(key))
+	buf := make([]byte, size)
+	n, err := client.Encode(m, buf)
+	require.NoError(t, err)
+	require.Equal(t, size, n)
+
+	// the next frame is sealed under the first sequence number
+	server := NewServerCodec()
+	server.SetSecret(coder.NewSecretKey(key))
+	var decoded message.Message
+	_, err = server.Decode(encodeFrame(t, client, m), &decoded)
+	require.NoError(t, err)
+	require.Equal(t, m.Payload, decoded.Payload)
+}
+
+func TestConnHandshake(t *testing.T) {
+	clientKey, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	serverKey, err := keystore.GenerateIdentityKey()
+	require.NoError(t, err)
+	newKeystore := func(identityKey ed25519.PrivateKey, psk []byte, anchors ...keystore.TrustAnchor) keystore.Keystore {
+		ks := keystore.NewMemoryStore()
+		if identityKey != nil {
+			require.NoError(t, ks.SetIdentityKey(identityKey))
+		}
+		if psk != nil {
+			require.NoError(t, ks.SetPSK("device", psk))
+		}
+		for _, a := range anchors {
+			require.NoError(t, ks.AddTrustAnchor(a))
+		}
+		return ks
+	}
+	clientAnchor := keystore.TrustAnchor{Name: "client", PublicKey: clientKey.Public().(ed25519.PublicKey)}
+	serverAnchor := keystore.TrustAnchor{Name: "server", PublicKey: serverKey.Public().(ed25519.PublicKey)}
+
+	tests := []struct {
+		name          string
+		serverOptions []any
+		clientOptions []any
+		wantErr       bool
+		wantPeer      connection.PeerIdentity
+		wantClient    connection.PeerIdentity
+	}{
+		{
+			name: "hybrid-psk",
+			serverOptions: []any{
+				options.WithHybridKeyExchange(),
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+				options.WithPeerAuthentication(),
+			},
+			clientOptions: []any{
+				options.WithHybridKeyExchange(),
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+				options.WithPSKIdentity("device"),
+				options.WithPeerAuthentication(),
+			},
+			wantPeer:   connection.PeerIdentity{PSKIdentity: "device"},
+			wantClient: connection.PeerIdentity{PSKIdentity: "device"},
+		},
+		{
+			name: "identity-keys",
+			serverOptions: []any{
+				options.WithKeystore(newKeystore(serverKey, nil, clientAnchor)),
+				options.WithPeerAuthentication(),
+			},
+			clientOptions: []any{
+				options.WithKeystore(newKeystore(clientKey, nil, serverAnchor)),
+				options.WithPeerAuthentication(),
+			},
+			wantPeer:   connection.PeerIdentity{PublicKey: serverAnchor.PublicKey, TrustAnchor: "server"},
+			wantClient: connection.PeerIdentity{PublicKey: clientAnchor.PublicKey, TrustAnchor: "client"},
+		},
+		{
+			name: "classic-key-share",
+			serverOptions: []any{
+				options.WithHybridKeyExchange(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid-psk",
+			serverOptions: []any{
+				options.WithKeystore(newKeystore(nil, []byte("secret"))),
+			},
+			clientOptions: []any{
+				options.WithKeystore(newKeystore(nil, []byte("guess"))),
+				options.WithPSKIdentity("device"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "unauthenticated-client",
+			serverOptions: []any{
+				options.WithKeystore(newKeystore(serverKey, nil)),
+				options.WithPeerAuthentication(),
+			},
+			wantErr: true,
+		},
+		{
+			name: "untrusted-server",
+			serverOptions: []any{
+				options.WithKeystore(newKeystore(serverKey, nil)),
+			},
+			clientOptions: []any{
+				options.WithKeystore(newKeystore(clientKey, nil)),
+				options.WithPeerAuthentication(),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := coapNet.NewTCPListener("tcp", "")
+			require.NoError(t, err)
+			defer func() {
+				errC := l.Close()
+				require.NoError(t, errC)
+			}()
+			var wg sync.WaitGroup
+			defer wg.Wait()
+
+			var peer connection.PeerIdentity
+			m := mux.NewRouter()
+			err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+				peer = PeerIdentity(w.Conn().(*client.Conn))
+				errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+				require.NoError(t, errS)
+			}))
+			require.NoError(t, err)
+			s, err := NewServer(append(tt.serverOptions, options.WithMux(m))...)
+			require.NoError(t, err)
+			defer s.Stop()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errS := s.Serve(l)
+				require.NoError(t, errS)
+			}()
+
+			cc, err := Dial(l.Addr().String(), tt.clientOptions...)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer func() {
+				errC := cc.Close()
+				require.NoError(t, errC)
+				<-cc.Done()
+			}()
+			require.Equal(t, tt.wantPeer, PeerIdentity(cc))
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+			defer cancel()
+			resp, err := cc.Get(ctx, "/a")
+			require.NoError(t, err)
+			require.Equal(t, codes.Content, resp.Code())
+			require.Equal(t, tt.wantClient, peer)
+		})
+	}
+
+	// the options of ASCON which don't apply over TCP are refused
+	_, err = NewServer(options.WithPSKIdentity("device"))
+	require.Error(t, err)
+	_, err = Dial("127.0.0.1:1", options.WithAttestInHandshake())
+	require.Error(t, err)
+}
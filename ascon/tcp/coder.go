@@ -0,0 +1,307 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	tcpCoder "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
+)
+
+// The nonce of a sealed frame carries the role of the sender in its first byte and the sequence number of the frame
+// in the direction of the sender in its last 8 bytes, the other bytes are zero. Both peers count their frames from
+// zero when the key is set, so no nonce repeats under the session key.
+const (
+	clientRole byte = iota + 1
+	serverRole
+)
+
+// Codec encodes and decodes the RFC 8323 frames of one ASCON session over TCP.
+//
+// Until a secret is set, frames are passed in plaintext. Afterwards the whole frame of a message is sealed
+// by the session key and sent as the payload of an empty frame without token:
+//
+//	Len | TKL=0 | Extended Length | Code=Empty | 0xff | ciphertext | tag 16 | nonce 16
+//
+// so the stream is split into messages without the key, while their codes, tokens and options stay hidden.
+// The frames which aren't sent by the peer role or whose sequence number doesn't grow are rejected, so a frame
+// can't be replayed, reflected or reordered.
+// Like coder.Coder, the server keeps the key of the handshake pending until the first frame of the client
+// authenticates under it.
+type Codec struct {
+	mutex           sync.Mutex
+	role            byte
+	peerRole        byte
+	secret          *coder.SecretKey
+	pending         *coder.SecretKey
+	sendSequence    uint64
+	receiveSequence uint64
+	closed          bool
+	// sized and sealSized keep the choice of Size for the following Encode, so the frame fits the buffer
+	// when the key is activated in between. The session writes every frame by Size and Encode.
+	sized     bool
+	sealSized bool
+
+	transcript   []byte
+	peerIdentity connection.PeerIdentity
+	finished     bool
+}
+
+// NewClientCodec creates the codec for the client side of a new session.
+func NewClientCodec() *Codec {
+	return &Codec{role: clientRole, peerRole: serverRole}
+}
+
+// NewServerCodec creates the codec for the server side of a new session.
+func NewServerCodec() *Codec {
+	return &Codec{role: serverRole, peerRole: clientRole}
+}
+
+// SetSecret activates the session key, all following frames are sealed.
+// The codec takes ownership of the key.
+func (c *Codec) SetSecret(secret *coder.SecretKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(secret, nil)
+}
+
+// SetPendingSecret stores the key of the handshake, frames are sent in plaintext until the peer
+// proves possession of it.
+func (c *Codec) SetPendingSecret(secret *coder.SecretKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(nil, secret)
+}
+
+// Close wipes the session keys. Afterwards the codec refuses to encode and decode frames,
+// so nothing falls back to plaintext.
+func (c *Codec) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.replaceKeys(nil, nil)
+	c.closed = true
+}
+
+func (c *Codec) replaceKeys(secret, pending *coder.SecretKey) {
+	if c.secret != secret && c.secret != pending {
+		c.secret.Wipe()
+	}
+	if c.pending != secret && c.pending != pending {
+		c.pending.Wipe()
+	}
+	c.secret = secret
+	c.pending = pending
+	c.sendSequence = 0
+	c.receiveSequence = 0
+}
+
+// IsEstablished reports whether the session key is active.
+func (c *Codec) IsEstablished() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.secret != nil
+}
+
+// HasPendingSecret reports whether the handshake has started and its key isn't active yet.
+func (c *Codec) HasPendingSecret() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pending != nil
+}
+
+// PeerIdentity returns the identity which the peer proved by its finished message.
+func (c *Codec) PeerIdentity() connection.PeerIdentity {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.peerIdentity
+}
+
+func (c *Codec) setTranscript(transcript []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.transcript = transcript
+}
+
+func (c *Codec) getTranscript() []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.transcript
+}
+
+// finish stores the identity of the peer, whose finished message was verified.
+func (c *Codec) finish(identity connection.PeerIdentity) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.peerIdentity = identity
+	c.finished = true
+}
+
+// isFinished reports whether the finished message of the peer was verified.
+func (c *Codec) isFinished() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.finished
+}
+
+// nextNonce returns a copy of the session key, which the caller wipes after use, and the nonce of the next frame.
+func (c *Codec) nextNonce() ([]byte, []byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, nil, coder.ErrCoderClosed
+	}
+	seal := c.secret != nil
+	if c.sized {
+		seal = c.sealSized
+		c.sized = false
+	}
+	if !seal {
+		return nil, nil, nil
+	}
+	if c.secret == nil {
+		return nil, nil, errors.New("session key is missing")
+	}
+	if c.sendSequence == math.MaxUint64 {
+		return nil, nil, errors.New("sequence numbers of the session are exhausted")
+	}
+	nonce := make([]byte, coder.NonceBytes)
+	nonce[0] = c.role
+	binary.BigEndian.PutUint64(nonce[coder.NonceBytes-8:], c.sendSequence)
+	c.sendSequence++
+	return append([]byte(nil), c.secret.Bytes()...), nonce, nil
+}
+
+func sealedFrame(sealed []byte) message.Message {
+	return message.Message{Code: codes.Empty, Payload: sealed}
+}
+
+func (c *Codec) Size(m message.Message) (int, error) {
+	size, err := tcpCoder.DefaultCoder.Size(m)
+	if err != nil {
+		return -1, err
+	}
+	c.mutex.Lock()
+	seal := c.secret != nil
+	c.sized = true
+	c.sealSized = seal
+	c.mutex.Unlock()
+	if !seal {
+		return size, nil
+	}
+	return tcpCoder.DefaultCoder.Size(sealedFrame(make([]byte, size+coder.Overhead)))
+}
+
+// Encode seals the frame under the next sequence number, when the preceding Size sized a sealed frame. The frames
+// are written in the order in which they are encoded, which the session of the connection ensures.
+func (c *Codec) Encode(m message.Message, buf []byte) (int, error) {
+	size, err := tcpCoder.DefaultCoder.Size(m)
+	if err != nil {
+		return -1, err
+	}
+	secret, nonce, err := c.nextNonce()
+	if err != nil {
+		return -1, err
+	}
+	if secret == nil {
+		return tcpCoder.DefaultCoder.Encode(m, buf)
+	}
+	defer coder.Wipe(secret)
+	plaintext := make([]byte, size)
+	defer coder.Wipe(plaintext)
+	if _, err = tcpCoder.DefaultCoder.Encode(m, plaintext); err != nil {
+		return -1, err
+	}
+	return tcpCoder.DefaultCoder.Encode(sealedFrame(coder.SealNonce(secret, nonce, plaintext)), buf)
+}
+
+func (c *Codec) DecodeHeader(data []byte, h *tcpCoder.MessageHeader) (int, error) {
+	return tcpCoder.DefaultCoder.DecodeHeader(data, h)
+}
+
+func (c *Codec) Decode(data []byte, m *message.Message) (int, error) {
+	var header tcpCoder.MessageHeader
+	if _, err := tcpCoder.DefaultCoder.DecodeHeader(data, &header); err != nil {
+		return -1, err
+	}
+	if uint32(len(data)) < header.MessageLength {
+		return -1, message.ErrShortRead
+	}
+	body := data[header.Length:header.MessageLength]
+	// the sealed frame has no token and no options, its body starts by the payload marker
+	var sealed []byte
+	if header.Code == codes.Empty && len(header.Token) == 0 && len(body) > 0 && body[0] == 0xff {
+		sealed = body[1:]
+	}
+	plaintext, err := c.open(sealed)
+	if err != nil {
+		return -1, err
+	}
+	if plaintext == nil {
+		return tcpCoder.DefaultCoder.Decode(data, m)
+	}
+	// the sequence number of the frame is consumed, so the inner frame is decoded here until the options fit
+	for {
+		_, err = tcpCoder.DefaultCoder.Decode(plaintext, m)
+		if !errors.Is(err, message.ErrOptionsTooSmall) {
+			break
+		}
+		m.Options = make(message.Options, 0, 2*cap(m.Options)+1)
+	}
+	if err != nil {
+		return -1, err
+	}
+	return int(header.MessageLength), nil
+}
+
+// open authenticates and decrypts the sealed frame and returns the inner frame. It returns nil
+// for the frames which are accepted in plaintext.
+func (c *Codec) open(sealed []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil, coder.ErrCoderClosed
+	}
+	if c.secret != nil {
+		if plaintext, ok := c.unseal(c.secret.Bytes(), sealed); ok {
+			return plaintext, nil
+		}
+		return nil, coder.ErrMessageAuthentication
+	}
+	if c.pending != nil {
+		if plaintext, ok := c.unseal(c.pending.Bytes(), sealed); ok {
+			c.secret = c.pending
+			c.pending = nil
+			return plaintext, nil
+		}
+	}
+	return nil, nil
+}
+
+// unseal opens the frame of the peer role whose sequence number isn't lower than the expected one, and
+// expects the following frame after it. Gaps are tolerated, they are left by the frames which weren't written.
+func (c *Codec) unseal(secret []byte, data []byte) ([]byte, bool) {
+	// the inner frame has at least the first byte and the code
+	if len(data) < 2+coder.Overhead {
+		return nil, false
+	}
+	nonce := coder.RecordNonce(data)
+	if nonce[0] != c.peerRole || !bytes.Equal(nonce[1:coder.NonceBytes-8], make([]byte, coder.NonceBytes-9)) {
+		return nil, false
+	}
+	sequence := binary.BigEndian.Uint64(nonce[coder.NonceBytes-8:])
+	if sequence < c.receiveSequence || sequence == math.MaxUint64 {
+		return nil, false
+	}
+	plaintext, ok := coder.Open(secret, data)
+	if !ok {
+		return nil, false
+	}
+	c.receiveSequence = sequence + 1
+	return plaintext, true
+}
@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	coapTCP "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/client"
+	tcpCoder "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/server"
+)
+
+// NewServer creates the server of CoAP over TCP which is protected by ASCON. Every connection starts with the
+// handshake, the requests which are sent before the client finished is verified are answered by Unauthorized.
+// The codec and the interceptor of the connections are set by the server, so options.WithCodec and
+// options.WithInterceptor are overridden.
+//
+// Besides the options of CoAP over TCP, it accepts the options of the ASCON handshake which apply to it:
+// options.WithHybridKeyExchange, options.WithKeystore and options.WithPeerAuthentication.
+func NewServer(opts ...any) (*server.Server, error) {
+	var tcpOpts []server.Option
+	var cfg connection.Config
+	for _, o := range opts {
+		switch o := o.(type) {
+		case server.Option:
+			tcpOpts = append(tcpOpts, o)
+		case options.HybridKeyExchangeOpt:
+			o.ASCONServerApply(&cfg)
+		case options.KeystoreOpt:
+			o.ASCONServerApply(&cfg)
+		case options.PeerAuthenticationOpt:
+			o.ASCONServerApply(&cfg)
+		default:
+			return nil, fmt.Errorf("option %T doesn't apply to the ASCON server over TCP", o)
+		}
+	}
+	h := handshaker{
+		authenticator: connection.NewAuthenticator(cfg),
+		hybrid:        cfg.HybridKeyExchange,
+	}
+	tcpOpts = append(tcpOpts,
+		options.WithCodec(func() tcpCoder.Codec {
+			return NewServerCodec()
+		}),
+		options.WithInterceptor(h.intercept),
+	)
+	return coapTCP.NewServer(tcpOpts...), nil
+}
+
+func connCodec(cc *client.Conn) (*Codec, bool) {
+	codec, ok := cc.Session().Codec().(*Codec)
+	return codec, ok
+}
+
+// handshaker runs the server side of the handshake of the connections.
+type handshaker struct {
+	authenticator connection.Authenticator
+	// hybrid rejects the classic key shares.
+	hybrid bool
+}
+
+// intercept runs the handshake and passes the messages to the handler once the client finished is verified.
+func (h handshaker) intercept(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message) bool {
+	codec, ok := connCodec(w.Conn())
+	if !ok {
+		return false
+	}
+	switch {
+	case r.Code() == codes.KeyExchange:
+		h.handleKeyExchange(w, r, codec)
+	case r.Code() == codes.HANDSHAKE:
+		h.handleClientFinished(w, r, codec)
+	case codec.isFinished():
+		return false
+	case isRequest(r.Code()):
+		rejectHandshake(w, codes.Unauthorized)
+	case codec.IsEstablished():
+		// the signals which keep the connection alive are served during the handshake
+		return false
+	}
+	return true
+}
+
+// handleKeyExchange answers the key share of the client by the share of the server and keeps the session key
+// pending until the first sealed frame of the client.
+func (h handshaker) handleKeyExchange(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message, codec *Codec) {
+	if codec.IsEstablished() || codec.HasPendingSecret() {
+		// the key is exchanged once per connection
+		rejectHandshake(w, codes.BadRequest)
+		return
+	}
+	clientShare, err := r.ReadBody()
+	if err != nil {
+		rejectHandshake(w, codes.BadRequest)
+		return
+	}
+	if h.hybrid && len(clientShare) != coder.HybridClientShareSize {
+		rejectHandshake(w, codes.Unauthorized)
+		return
+	}
+	serverShare, sessionKey, err := respond(clientShare)
+	if err != nil {
+		rejectHandshake(w, codes.BadRequest)
+		return
+	}
+	// the share of the server is still sent in plaintext, the key is activated by the first sealed frame of the client
+	codec.setTranscript(coder.Transcript(clientShare, serverShare))
+	codec.SetPendingSecret(sessionKey)
+	w.Conn().AddOnClose(codec.Close)
+	w.Message().SetCode(codes.KeyExchange)
+	w.Message().SetBody(bytes.NewReader(serverShare))
+}
+
+// handleClientFinished verifies the client finished, which is sealed by the session key, and answers it by the
+// server finished.
+func (h handshaker) handleClientFinished(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message, codec *Codec) {
+	transcript := codec.getTranscript()
+	if transcript == nil || !codec.IsEstablished() || codec.isFinished() {
+		rejectHandshake(w, codes.BadRequest)
+		return
+	}
+	clientFinished, err := r.ReadBody()
+	if err != nil {
+		rejectHandshake(w, codes.BadRequest)
+		return
+	}
+	identity, serverFinished, err := h.authenticator.VerifyClientFinished(clientFinished, transcript)
+	if err != nil {
+		rejectHandshake(w, codes.Unauthorized)
+		return
+	}
+	// the requests of the peer are passed to the handler from now on
+	codec.finish(identity)
+	// the connection reports the error of writing the response
+	_ = w.SetResponse(codes.Content, message.AppOctets, bytes.NewReader(serverFinished))
+}
+
+func respond(clientShare []byte) ([]byte, *coder.SecretKey, error) {
+	switch len(clientShare) {
+	case coder.X25519ShareSize:
+		return coder.RespondX25519(clientShare)
+	case coder.HybridClientShareSize:
+		return coder.RespondHybrid(clientShare)
+	}
+	return nil, nil, fmt.Errorf("client key share: %w", coder.ErrInvalidKeyShare)
+}
+
+func isRequest(code codes.Code) bool {
+	return code != codes.Empty && code < 32
+}
+
+func rejectHandshake(w *responsewriter.ResponseWriter[*client.Conn], code codes.Code) {
+	// the connection reports the error of writing the response
+	_ = w.SetResponse(code, message.TextPlain, nil)
+}
@@ -1,9 +1,35 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"log"
+
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/coder"
 )
 
 func main() {
-	coder.Printer()
+	alice, err := coder.NewX25519KeyShare()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer alice.Wipe()
+
+	bobPublic, bobKey, err := coder.RespondX25519(alice.Public())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer bobKey.Wipe()
+
+	aliceKey, err := alice.SessionKey(bobPublic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer aliceKey.Wipe()
+
+	fmt.Printf("Alice public:	%X\n", alice.Public())
+	fmt.Printf("Bob public:	%X\n", bobPublic)
+	// session keys are printed as redacted
+	fmt.Printf("Shared key:	%X\n", aliceKey)
+	fmt.Printf("Keys match:	%v\n", bytes.Equal(aliceKey.Bytes(), bobKey.Bytes()))
 }
@@ -0,0 +1,53 @@
+// Decodes an ASCON capture with the session keys written by options.WithKeyLogWriter.
+//
+//	go run ./examples/ascon/keylog -pcap capture.pcap -keylog keys.log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keylog"
+)
+
+func main() {
+	pcapPath := flag.String("pcap", "", "capture in the pcap format")
+	keyLogPath := flag.String("keylog", "", "key log of the ASCON sessions")
+	flag.Parse()
+	if *pcapPath == "" || *keyLogPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keyLogFile, err := os.Open(*keyLogPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer keyLogFile.Close()
+	entries, err := keylog.Parse(keyLogFile)
+	if err != nil {
+		log.Fatalf("cannot parse key log: %v", err)
+	}
+
+	capture, err := os.Open(*pcapPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer capture.Close()
+	packets, err := keylog.Decode(capture, entries)
+	if err != nil {
+		log.Printf("cannot decode capture: %v", err)
+	}
+	for _, p := range packets {
+		switch {
+		case p.Err != nil:
+			fmt.Printf("%v %v -> %v: %v\n", p.Time.Format("15:04:05.000000"), p.Src, p.Dst, p.Err)
+		case p.SessionID == nil:
+			fmt.Printf("%v %v -> %v plaintext: %v\n", p.Time.Format("15:04:05.000000"), p.Src, p.Dst, p.Message.String())
+		default:
+			fmt.Printf("%v %v -> %v session %x: %v\n", p.Time.Format("15:04:05.000000"), p.Src, p.Dst, p.SessionID, p.Message.String())
+		}
+	}
+}
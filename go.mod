@@ -4,6 +4,9 @@ go 1.20
 
 require (
 	github.com/dsnet/golib/memfile v1.0.0
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/google/go-tpm v0.9.0
+	github.com/google/go-tpm-tools v0.4.4
 	github.com/pion/dtls/v2 v2.2.8-0.20240701035148-45e16a098c47
 	github.com/pion/transport/v3 v3.0.7
 	github.com/stretchr/testify v1.9.0
@@ -18,6 +21,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pion/logging v0.2.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
 	golang.org/x/sys v0.23.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
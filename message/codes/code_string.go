@@ -38,6 +38,7 @@ var codeToString = map[Code]string{
 	Pong:                  "Pong",
 	Release:               "Release",
 	Abort:                 "Abort",
+	KeyExchange:           "KeyExchange",
 }
 
 func (c Code) String() string {
@@ -58,6 +58,9 @@ const (
 	Pong    Code = 227
 	Release Code = 228
 	Abort   Code = 229
+	// KeyExchange carries the key shares of the ASCON handshake over TCP. 7.06 is not assigned by RFC 8323,
+	// the code is used privately between ASCON peers and other CoAP over TCP peers don't understand it.
+	KeyExchange Code = 230
 )
 
 const _maxCode = 255
@@ -96,6 +99,7 @@ var strToCode = map[string]Code{
 	`"Pong"`:                               Pong,
 	`"Release"`:                            Release,
 	`"Abort"`:                              Abort,
+	`"KeyExchange"`:                        KeyExchange,
 }
 
 func getMaxCodeLen() int {
@@ -231,10 +231,7 @@ func (b *BlockWise[C]) Do(r *pool.Message, maxSzx SZX, maxMessageSize uint32, do
 		return do(r)
 	}
 
-	switch r.Code() {
-	case codes.POST, codes.PUT:
-		break
-	default:
+	if !hasRequestPayload(r.Code()) {
 		return nil, fmt.Errorf("unsupported command(%v)", r.Code())
 	}
 	req := b.cloneMessage(r)
@@ -317,14 +314,23 @@ func (b *BlockWise[C]) sendEntityIncomplete(w *responsewriter.ResponseWriter[C],
 	w.SetMessage(sendMessage)
 }
 
+// hasRequestPayload returns true for requests whose payload is transferred via Block1.
+func hasRequestPayload(code codes.Code) bool {
+	return code == codes.POST || code == codes.PUT || code == codes.PROOF || code == codes.PROVE || code == codes.HANDSHAKE
+}
+
+func isRequest(code codes.Code) bool {
+	return (code >= codes.GET && code <= codes.PROVE) || code == codes.HANDSHAKE
+}
+
 func wantsToBeReceived(r *pool.Message) bool {
 	hasBlock1 := r.HasOption(message.Block1)
 	hasBlock2 := r.HasOption(message.Block2)
-	if hasBlock1 && (r.Code() == codes.POST || r.Code() == codes.PUT) {
+	if hasBlock1 && hasRequestPayload(r.Code()) {
 		// r contains payload which we received
 		return true
 	}
-	if hasBlock2 && (r.Code() >= codes.GET && r.Code() <= codes.DELETE) {
+	if hasBlock2 && isRequest(r.Code()) {
 		// r is command to get next block
 		return false
 	}
@@ -375,7 +381,7 @@ func (b *BlockWise[C]) Handle(w *responsewriter.ResponseWriter[C], r *pool.Messa
 		return
 	}
 	// For codes GET,POST,PUT,DELETE, we want them to wait for pairing response and then delete them when the full response comes in or when timeout occurs.
-	if !more && sendingMessageCode > codes.DELETE {
+	if !more && !isRequest(sendingMessageCode) {
 		b.sendingMessagesCache.Delete(tokenStr)
 	}
 }
@@ -396,7 +402,7 @@ func (b *BlockWise[C]) handleReceivedMessage(w *responsewriter.ResponseWriter[C]
 		if w.Message().Code() == codes.Content && errG == nil {
 			startSendingMessageBlock = block
 		}
-	case codes.POST, codes.PUT:
+	case codes.POST, codes.PUT, codes.PROOF, codes.PROVE, codes.HANDSHAKE:
 		maxSZX = fitSZX(r, message.Block1, maxSZX)
 		errP := b.processReceivedMessage(w, r, maxSZX, next, message.Block1, message.Size1)
 		if errP != nil {
@@ -416,8 +422,7 @@ func (b *BlockWise[C]) createSendingMessage(sendingMessage *pool.Message, maxSZX
 	blockType := message.Block2
 	sizeType := message.Size2
 	token := sendingMessage.Token()
-	switch sendingMessage.Code() {
-	case codes.POST, codes.PUT:
+	if hasRequestPayload(sendingMessage.Code()) {
 		blockType = message.Block1
 		sizeType = message.Size1
 	}
@@ -491,8 +496,7 @@ func (b *BlockWise[C]) createSendingMessage(sendingMessage *pool.Message, maxSZX
 
 func (b *BlockWise[C]) continueSendingMessage(w *responsewriter.ResponseWriter[C], r *pool.Message, maxSZX SZX, maxMessageSize uint32, sendingMessageCode codes.Code /* msg *pool.Message*/) (bool, error) {
 	blockType := message.Block2
-	switch sendingMessageCode {
-	case codes.POST, codes.PUT:
+	if hasRequestPayload(sendingMessageCode) {
 		blockType = message.Block1
 	}
 
@@ -0,0 +1,68 @@
+// Package discovery sends the requests of the udp servers to multicast or unicast addresses and routes the responses,
+// which can come from any address, to their handlers. It is shared by udp/server and the ASCON server.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
+	pkgErrors "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/errors"
+	coapSync "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/sync"
+)
+
+// Discover creates the non-confirmable GET request of the path and passes it to request.
+func Discover(ctx context.Context, messagePool *pool.Pool, getToken func() (message.Token, error), getMID func() int32, path string, request func(req *pool.Message) error) error {
+	token, err := getToken()
+	if err != nil {
+		return fmt.Errorf("cannot get token: %w", err)
+	}
+	req := messagePool.AcquireMessage(ctx)
+	defer messagePool.ReleaseMessage(req)
+	err = req.SetupGet(path, token)
+	if err != nil {
+		return fmt.Errorf("cannot create discover request: %w", err)
+	}
+	req.SetMessageID(getMID())
+	req.SetType(message.NonConfirmable)
+	return request(req)
+}
+
+// Resolve checks the token of the request and resolves the address on the network of the listener.
+func Resolve(req *pool.Message, network, address string) (*net.UDPAddr, error) {
+	if len(req.Token()) == 0 {
+		return nil, errors.New("invalid token")
+	}
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve address: %w", err)
+	}
+	return addr, nil
+}
+
+// Register stores the request for the blockwise transfers of its responses and the handler of the responses.
+// The returned function unregisters them.
+func Register[H any](requests *coapSync.Map[uint64, *pool.Message], handlers *coapSync.Map[uint64, H], req *pool.Message, h H) (func(), error) {
+	hash := req.Token().Hash()
+	if _, loaded := handlers.LoadOrStore(hash, h); loaded {
+		return nil, pkgErrors.ErrKeyAlreadyExists
+	}
+	requests.Store(hash, req)
+	return func() {
+		requests.Delete(hash)
+		_, _ = handlers.LoadAndDelete(hash)
+	}, nil
+}
+
+// Wait waits for the responses until the request timeouts or the server of the context is closed.
+func Wait(ctx context.Context, req *pool.Message) error {
+	select {
+	case <-req.Context().Done():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("server was closed: %w", ctx.Err())
+	}
+}
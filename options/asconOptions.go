@@ -0,0 +1,282 @@
+package options
+
+import (
+	"io"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/attestation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/keystore"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/revocation"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/sessionstore"
+)
+
+// HybridKeyExchangeOpt hybrid key exchange option.
+type HybridKeyExchangeOpt struct{}
+
+func (o HybridKeyExchangeOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.HybridKeyExchange = true
+}
+
+func (o HybridKeyExchangeOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.HybridKeyExchange = true
+}
+
+// WithHybridKeyExchange combines X25519 with ML-KEM-768 encapsulation in the ASCON handshake.
+// The client sends a hybrid key share; the server refuses clients which offer only X25519.
+func WithHybridKeyExchange() HybridKeyExchangeOpt {
+	return HybridKeyExchangeOpt{}
+}
+
+// KeystoreOpt keystore option.
+type KeystoreOpt struct {
+	keystore keystore.Keystore
+}
+
+func (o KeystoreOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.Keystore = o.keystore
+}
+
+func (o KeystoreOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.Keystore = o.keystore
+}
+
+// WithKeystore sets the keystore with the identity key, pre-shared keys and trust anchors
+// which are used to authenticate the handshake.
+func WithKeystore(ks keystore.Keystore) KeystoreOpt {
+	return KeystoreOpt{keystore: ks}
+}
+
+// PSKIdentityOpt pre-shared key identity option.
+type PSKIdentityOpt struct {
+	identity string
+}
+
+func (o PSKIdentityOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.PSKIdentity = o.identity
+}
+
+// WithPSKIdentity makes the client prove the pre-shared key of the identity from its keystore.
+func WithPSKIdentity(identity string) PSKIdentityOpt {
+	return PSKIdentityOpt{identity: identity}
+}
+
+// PeerAuthenticationOpt peer authentication option.
+type PeerAuthenticationOpt struct{}
+
+func (o PeerAuthenticationOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.RequirePeerAuthentication = true
+}
+
+func (o PeerAuthenticationOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.RequirePeerAuthentication = true
+}
+
+// WithPeerAuthentication requires the peer to prove a pre-shared key or an identity key
+// which matches a trust anchor of the keystore.
+func WithPeerAuthentication() PeerAuthenticationOpt {
+	return PeerAuthenticationOpt{}
+}
+
+// LoggerOpt logger option.
+type LoggerOpt struct {
+	logger connection.Logger
+}
+
+func (o LoggerOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.Logger = o.logger
+}
+
+func (o LoggerOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.Logger = o.logger
+}
+
+// WithLogger sets the logger which receives the diagnostics of the ASCON transport.
+func WithLogger(logger connection.Logger) LoggerOpt {
+	return LoggerOpt{logger: logger}
+}
+
+// KeyLogWriterOpt key log writer option.
+type KeyLogWriterOpt struct {
+	w io.Writer
+}
+
+func (o KeyLogWriterOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.KeyLogWriter = o.w
+}
+
+func (o KeyLogWriterOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.KeyLogWriter = o.w
+}
+
+// WithKeyLogWriter writes the session keys to w, so captured traffic can be decrypted by the keylog package.
+// It compromises the security of the sessions and should only be used for debugging.
+func WithKeyLogWriter(w io.Writer) KeyLogWriterOpt {
+	return KeyLogWriterOpt{w: w}
+}
+
+// SessionStoreOpt session store option.
+type SessionStoreOpt struct {
+	store     sessionstore.Store
+	masterKey []byte
+}
+
+func (o SessionStoreOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.SessionStore = o.store
+	cfg.SessionMasterKey = o.masterKey
+}
+
+// WithSessionStore persists the sessions of the server in the store, sealed under the master key
+// of 16 bytes. Servers which share the store and the master key resume the sessions of each other.
+func WithSessionStore(store sessionstore.Store, masterKey []byte) SessionStoreOpt {
+	return SessionStoreOpt{store: store, masterKey: masterKey}
+}
+
+// AttesterOpt attester option.
+type AttesterOpt struct {
+	attester attestation.Attester
+}
+
+func (o AttesterOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.Attester = o.attester
+}
+
+func (o AttesterOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.Attester = o.attester
+}
+
+// WithAttester sets the attester which quotes the measurement when the peer sends PROVE.
+func WithAttester(attester attestation.Attester) AttesterOpt {
+	return AttesterOpt{attester: attester}
+}
+
+// WithMeasurement sets the measurement which is proved when the peer sends PROVE.
+func WithMeasurement(measurement attestation.MeasurementFunc) AttesterOpt {
+	return AttesterOpt{attester: measurement}
+}
+
+// ReattestationOpt re-attestation option.
+type ReattestationOpt struct {
+	reattestation *connection.Reattestation
+}
+
+func (o ReattestationOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.Reattestation = o.reattestation
+}
+
+func (o ReattestationOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.Reattestation = o.reattestation
+}
+
+// WithReattestation attests the peer on the interval of the re-attestation and runs its action when it fails.
+func WithReattestation(reattestation connection.Reattestation) ReattestationOpt {
+	return ReattestationOpt{reattestation: &reattestation}
+}
+
+// AttestInHandshakeOpt attest in handshake option.
+type AttestInHandshakeOpt struct{}
+
+func (o AttestInHandshakeOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.AttestInHandshake = true
+}
+
+func (o AttestInHandshakeOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.AttestInHandshake = true
+}
+
+// WithAttestInHandshake sends the evidence of the attester in the handshake, bound to its transcript.
+func WithAttestInHandshake() AttestInHandshakeOpt {
+	return AttestInHandshakeOpt{}
+}
+
+// HandshakeAttestationOpt handshake attestation option.
+type HandshakeAttestationOpt struct {
+	handshakeAttestation *connection.HandshakeAttestation
+}
+
+func (o HandshakeAttestationOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.HandshakeAttestation = o.handshakeAttestation
+}
+
+func (o HandshakeAttestationOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.HandshakeAttestation = o.handshakeAttestation
+}
+
+// WithHandshakeAttestation requires the evidence of the peer in the handshake. The server refuses the handshake
+// of a client whose evidence fails the verification, and Dial fails when the evidence of the server fails.
+func WithHandshakeAttestation(handshakeAttestation connection.HandshakeAttestation) HandshakeAttestationOpt {
+	return HandshakeAttestationOpt{handshakeAttestation: &handshakeAttestation}
+}
+
+// AuditLogOpt audit log option.
+type AuditLogOpt struct {
+	auditLog *attestation.AuditLog
+}
+
+func (o AuditLogOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.AuditLog = o.auditLog
+}
+
+func (o AuditLogOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.AuditLog = o.auditLog
+}
+
+// WithAuditLog appends the attestation requests, the evidence digests and the verdicts to the hash-chained log.
+func WithAuditLog(auditLog *attestation.AuditLog) AuditLogOpt {
+	return AuditLogOpt{auditLog: auditLog}
+}
+
+// RevocationsOpt revocation list option.
+type RevocationsOpt struct {
+	revocations *revocation.List
+}
+
+func (o RevocationsOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.Revocations = o.revocations
+}
+
+// WithRevocations refuses the handshakes and the attestations of the revoked devices and closes their sessions.
+// The list which is opened from a file is reloaded periodically.
+func WithRevocations(revocations *revocation.List) RevocationsOpt {
+	return RevocationsOpt{revocations: revocations}
+}
+
+// GroupAttestationOpt group attestation option.
+type GroupAttestationOpt struct{}
+
+func (o GroupAttestationOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.GroupAttestation = true
+}
+
+func (o GroupAttestationOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.GroupAttestation = true
+}
+
+// WithGroupAttestation answers the multicast PROVE of ascon.Server.AttestGroup by the evidence which is bound to
+// the identity key of the keystore instead of a session. Enable it on the members of the multicast group.
+func WithGroupAttestation() GroupAttestationOpt {
+	return GroupAttestationOpt{}
+}
+
+// AttestationCacheOpt attestation result cache option.
+type AttestationCacheOpt struct {
+	lifetime      time.Duration
+	policyVersion func() uint64
+}
+
+func (o AttestationCacheOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.AttestationResultLifetime = o.lifetime
+	cfg.PolicyVersion = o.policyVersion
+}
+
+func (o AttestationCacheOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.AttestationResultLifetime = o.lifetime
+	cfg.PolicyVersion = o.policyVersion
+}
+
+// WithAttestationCache caches the attestation result of the peer for the lifetime. The result is dropped when
+// the session is rekeyed or when policyVersion, e.g. attestation.ReferenceValues.Version, changes.
+// The policyVersion can be nil.
+func WithAttestationCache(lifetime time.Duration, policyVersion func() uint64) AttestationCacheOpt {
+	return AttestationCacheOpt{lifetime: lifetime, policyVersion: policyVersion}
+}
@@ -811,6 +811,132 @@ func WithRequestMonitor[F WithRequestMonitorFunc](requestMonitor F) WithRequestM
 	}
 }
 
+// CodecFunc creates the codec of a connection.
+type CodecFunc interface {
+	tcpClient.CreateCodecFunc | udpClient.CreateCodecFunc
+}
+
+// CodecOpt codec option.
+type CodecOpt[F CodecFunc] struct {
+	f F
+}
+
+func panicForInvalidCodecFunc(t, exp any) {
+	panic(fmt.Errorf("invalid CodecFunc type %T, expected %T", t, exp))
+}
+
+func (o CodecOpt[F]) UDPServerApply(cfg *udpServer.Config) {
+	switch v := any(o.f).(type) {
+	case udpClient.CreateCodecFunc:
+		cfg.CreateCodec = v
+	default:
+		var exp udpClient.CreateCodecFunc
+		panicForInvalidCodecFunc(v, exp)
+	}
+}
+
+func (o CodecOpt[F]) UDPClientApply(cfg *udpClient.Config) {
+	switch v := any(o.f).(type) {
+	case udpClient.CreateCodecFunc:
+		cfg.CreateCodec = v
+	default:
+		var exp udpClient.CreateCodecFunc
+		panicForInvalidCodecFunc(v, exp)
+	}
+}
+
+func (o CodecOpt[F]) TCPServerApply(cfg *tcpServer.Config) {
+	switch v := any(o.f).(type) {
+	case tcpClient.CreateCodecFunc:
+		cfg.CreateCodec = v
+	default:
+		var exp tcpClient.CreateCodecFunc
+		panicForInvalidCodecFunc(v, exp)
+	}
+}
+
+func (o CodecOpt[F]) TCPClientApply(cfg *tcpClient.Config) {
+	switch v := any(o.f).(type) {
+	case tcpClient.CreateCodecFunc:
+		cfg.CreateCodec = v
+	default:
+		var exp tcpClient.CreateCodecFunc
+		panicForInvalidCodecFunc(v, exp)
+	}
+}
+
+// WithCodec encodes and decodes the messages of every connection by the codec which createCodec returns,
+// e.g. to protect or to compress them. The multicast requests of Discover are encoded by the plain CoAP coder,
+// and over TCP the codec must keep the RFC 8323 framing.
+func WithCodec[F CodecFunc](createCodec F) CodecOpt[F] {
+	return CodecOpt[F]{
+		f: createCodec,
+	}
+}
+
+// InterceptorFunc intercepts the received messages.
+type InterceptorFunc interface {
+	tcpClient.InterceptorFunc | udpClient.InterceptorFunc
+}
+
+// InterceptorOpt interceptor option.
+type InterceptorOpt[F InterceptorFunc] struct {
+	f F
+}
+
+func panicForInvalidInterceptorFunc(t, exp any) {
+	panic(fmt.Errorf("invalid InterceptorFunc type %T, expected %T", t, exp))
+}
+
+func (o InterceptorOpt[F]) UDPServerApply(cfg *udpServer.Config) {
+	switch v := any(o.f).(type) {
+	case udpClient.InterceptorFunc:
+		cfg.Interceptor = v
+	default:
+		var exp udpClient.InterceptorFunc
+		panicForInvalidInterceptorFunc(v, exp)
+	}
+}
+
+func (o InterceptorOpt[F]) UDPClientApply(cfg *udpClient.Config) {
+	switch v := any(o.f).(type) {
+	case udpClient.InterceptorFunc:
+		cfg.Interceptor = v
+	default:
+		var exp udpClient.InterceptorFunc
+		panicForInvalidInterceptorFunc(v, exp)
+	}
+}
+
+func (o InterceptorOpt[F]) TCPServerApply(cfg *tcpServer.Config) {
+	switch v := any(o.f).(type) {
+	case tcpClient.InterceptorFunc:
+		cfg.Interceptor = v
+	default:
+		var exp tcpClient.InterceptorFunc
+		panicForInvalidInterceptorFunc(v, exp)
+	}
+}
+
+func (o InterceptorOpt[F]) TCPClientApply(cfg *tcpClient.Config) {
+	switch v := any(o.f).(type) {
+	case tcpClient.InterceptorFunc:
+		cfg.Interceptor = v
+	default:
+		var exp tcpClient.InterceptorFunc
+		panicForInvalidInterceptorFunc(v, exp)
+	}
+}
+
+// WithInterceptor passes the received messages to the interceptor before the token and the observation handlers.
+// The messages for which it returns true are not processed further, e.g. the handshake of a protocol
+// which is layered on UDP or TCP.
+func WithInterceptor[F InterceptorFunc](interceptor F) InterceptorOpt[F] {
+	return InterceptorOpt[F]{
+		f: interceptor,
+	}
+}
+
 // CloseSocketOpt close socket option.
 type CloseSocketOpt struct{}
 
@@ -3,6 +3,7 @@ package options
 import (
 	"time"
 
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
 	dtlsServer "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/dtls/server"
 	udpClient "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/client"
 	udpServer "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/server"
@@ -62,6 +63,14 @@ func (o MTUOpt) UDPClientApply(cfg *udpClient.Config) {
 	cfg.MTU = o.mtu
 }
 
+func (o MTUOpt) ASCONServerApply(cfg *connection.Config) {
+	cfg.MTU = o.mtu
+}
+
+func (o MTUOpt) ASCONClientApply(cfg *connection.Config) {
+	cfg.MTU = o.mtu
+}
+
 // Setup MTU unit
 func WithMTU(mtu uint16) MTUOpt {
 	return MTUOpt{
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/dtls"
@@ -48,11 +49,18 @@ func ListenAndServe(network string, addr string, handler mux.Handler) (err error
 		}()
 		s := tcp.NewServer(options.WithMux(handler))
 		return s.Serve(l)
+	case "ascon", "ascon4", "ascon6":
+		return ListenAndServeASCONWithOptions(network, addr, options.WithMux(handler))
 	default:
 		return fmt.Errorf("invalid network (%v)", network)
 	}
 }
 
+// asconUDPNetwork maps the ascon network to the udp network which carries it.
+func asconUDPNetwork(network string) string {
+	return strings.Replace(network, "ascon", "udp", 1)
+}
+
 // ListenAndServeTCPTLS Starts a server on address and network over TLS specified Invoke handler
 // for incoming queries.
 func ListenAndServeTCPTLS(network, addr string, config *tls.Config, handler mux.Handler) (err error) {
@@ -85,38 +93,58 @@ func ListenAndServeDTLS(network string, addr string, config *piondtls.Config, ha
 	return s.Serve(l)
 }
 
+// ListenAndServeASCON Starts a server on address and network over ASCON specified Invoke handler
+// for incoming queries.
+func ListenAndServeASCON(network string, addr string, handler mux.Handler) (err error) {
+	return ListenAndServeASCONWithOptions(network, addr, options.WithMux(handler))
+}
+
+// ListenAndServerASCON Starts a server on address and network over ASCON specified Invoke handler
+// for incoming queries.
+//
+// Deprecated: use ListenAndServeASCON.
 func ListenAndServerASCON(network string, addr string, handler mux.Handler) (err error) {
-	l, err := net.NewListenUDP(network, addr)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if errC := l.Close(); errC != nil && err == nil {
-			err = errC
-		}
-	}()
-	s := ascon.NewServer(options.WithMux(handler))
-	return s.Serve(l)
+	return ListenAndServeASCON(network, addr, handler)
 }
 
 // ListenAndServeWithOption Starts a server on address and network specified Invoke options
-// for incoming queries. The options is only support tcpServer.Option and udpServer.Option
+// for incoming queries. The options is only support tcpServer.Option, udpServer.Option and ascon.ServerOption,
+// the "ascon", "ascon4" and "ascon6" networks serve ASCON over udp. An ascon.ServerOption which the udp or tcp
+// server of the network doesn't apply is refused.
 func ListenAndServeWithOptions(network, addr string, opts ...any) (err error) {
 	tcpOptions := []tcpServer.Option{}
 	udpOptions := []udpServer.Option{}
+	asconOptions := []ascon.ServerOption{}
 	for _, opt := range opts {
-		switch o := opt.(type) {
-		case tcpServer.Option:
-			tcpOptions = append(tcpOptions, o)
-
-			// Duplicate the option for UDP if needed.
-			if udpOpt, ok := o.(udpServer.Option); ok {
-				udpOptions = append(udpOptions, udpOpt)
+		// Most of the options apply to several servers, so they are duplicated for each of them.
+		supported := false
+		tcpOpt, isTCPOpt := opt.(tcpServer.Option)
+		if isTCPOpt {
+			tcpOptions = append(tcpOptions, tcpOpt)
+			supported = true
+		}
+		udpOpt, isUDPOpt := opt.(udpServer.Option)
+		if isUDPOpt {
+			udpOptions = append(udpOptions, udpOpt)
+			supported = true
+		}
+		if o, ok := opt.(ascon.ServerOption); ok {
+			asconOptions = append(asconOptions, o)
+			supported = true
+			// the server of the network would drop the option silently
+			switch network {
+			case "udp", "udp4", "udp6", "":
+				if !isUDPOpt {
+					return fmt.Errorf("option %T doesn't apply to network %v", opt, network)
+				}
+			case "tcp", "tcp4", "tcp6":
+				if !isTCPOpt {
+					return fmt.Errorf("option %T doesn't apply to network %v", opt, network)
+				}
 			}
-		case udpServer.Option:
-			udpOptions = append(udpOptions, o)
-		default:
-			return errors.New("only support tcpServer.Option and udpServer.Option")
+		}
+		if !supported {
+			return errors.New("only support tcpServer.Option, udpServer.Option and ascon.ServerOption")
 		}
 	}
 
@@ -145,6 +173,8 @@ func ListenAndServeWithOptions(network, addr string, opts ...any) (err error) {
 		}()
 		s := tcp.NewServer(tcpOptions...)
 		return s.Serve(l)
+	case "ascon", "ascon4", "ascon6":
+		return ListenAndServeASCONWithOptions(network, addr, asconOptions...)
 	default:
 		return fmt.Errorf("invalid network (%v)", network)
 	}
@@ -166,6 +196,22 @@ func ListenAndServeTCPTLSWithOptions(network, addr string, config *tls.Config, o
 	return s.Serve(l)
 }
 
+// ListenAndServeASCONWithOptions Starts a server on address and network over ASCON specified Invoke options
+// for incoming queries. The network is "udp", "udp4", "udp6" or the equal "ascon", "ascon4", "ascon6".
+func ListenAndServeASCONWithOptions(network string, addr string, opts ...ascon.ServerOption) (err error) {
+	l, err := net.NewListenUDP(asconUDPNetwork(network), addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errC := l.Close(); errC != nil && err == nil {
+			err = errC
+		}
+	}()
+	s := ascon.NewServer(opts...)
+	return s.Serve(l)
+}
+
 // ListenAndServeDTLSWithOptions Starts a server on address and network over DTLS specified Invoke options
 // for incoming queries.
 func ListenAndServeDTLSWithOptions(network string, addr string, config *piondtls.Config, opts ...dtlsServer.Option) (err error) {
@@ -0,0 +1,77 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	gonet "net"
+	"testing"
+	"time"
+
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/ascon/connection"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/codes"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/mux"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndServeWithOptions(t *testing.T) {
+	// the options of another network are refused instead of being dropped
+	err := ListenAndServeWithOptions("udp", "", options.WithHybridKeyExchange())
+	require.Error(t, err)
+	err = ListenAndServeWithOptions("tcp", "", options.WithHybridKeyExchange())
+	require.Error(t, err)
+	// the options of the udp and tcp servers are shared, the invalid address fails after they are accepted
+	err = ListenAndServeWithOptions("udp", "invalid address", options.WithConnectionCacheSize(1))
+	require.Error(t, err)
+	require.NotContains(t, err.Error(), "doesn't apply")
+
+	l, err := net.NewListenUDP("udp", "127.0.0.1:")
+	require.NoError(t, err)
+	addr := l.LocalAddr().String()
+	require.NoError(t, l.Close())
+
+	m := mux.NewRouter()
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ListenAndServeWithOptions("ascon", addr, options.WithMux(m), options.WithContext(ctx), options.WithHybridKeyExchange())
+	}()
+	defer func() {
+		cancel()
+		// the blocked read of the listener notices the cancellation with the next datagram
+		wake, errD := gonet.Dial("udp", addr)
+		require.NoError(t, errD)
+		_, errW := wake.Write([]byte{0})
+		require.NoError(t, errW)
+		require.NoError(t, wake.Close())
+		require.NoError(t, <-served)
+	}()
+
+	var cc *connection.Conn
+	require.Eventually(t, func() bool {
+		cc, err = ascon.Dial(addr, options.WithHybridKeyExchange())
+		return err == nil
+	}, time.Second*5, time.Millisecond*50)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+		<-cc.Done()
+	}()
+
+	reqCtx, reqCancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer reqCancel()
+	resp, err := cc.Get(reqCtx, "/a")
+	require.NoError(t, err)
+	require.Equal(t, codes.Content, resp.Code())
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	require.Equal(t, []byte("a"), body)
+}
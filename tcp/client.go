@@ -13,6 +13,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/monitor/inactivity"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
 	client "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/client"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
 )
 
 // A Option sets options such as credentials, keepalive parameters, etc.
@@ -86,6 +87,11 @@ func Client(conn net.Conn, opts ...Option) *client.Conn {
 		}
 	}
 
+	var codec coder.Codec
+	if cfg.CreateCodec != nil {
+		codec = cfg.CreateCodec()
+	}
+
 	l := coapNet.NewConn(conn)
 	monitor := cfg.CreateInactivityMonitor()
 	cc := client.NewConnWithOpts(l,
@@ -93,6 +99,8 @@ func Client(conn net.Conn, opts ...Option) *client.Conn {
 		client.WithBlockWise(createBlockWise),
 		client.WithInactivityMonitor(monitor),
 		client.WithRequestMonitor(cfg.RequestMonitor),
+		client.WithCodec(codec),
+		client.WithInterceptor(cfg.Interceptor),
 	)
 
 	cfg.PeriodicRunner(func(now time.Time) bool {
@@ -42,6 +42,8 @@ type Config struct {
 	config.Common[*Conn]
 	CreateInactivityMonitor         CreateInactivityMonitorFunc
 	RequestMonitor                  RequestMonitorFunc
+	CreateCodec                     CreateCodecFunc
+	Interceptor                     InterceptorFunc
 	Net                             string
 	Dialer                          *net.Dialer
 	TLSCfg                          *tls.Config
@@ -19,6 +19,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
 	coapErrors "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/errors"
 	coapSync "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/sync"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
 
 	"go.uber.org/atomic"
 )
@@ -35,6 +36,8 @@ type (
 	GetMIDFunc                  = func() int32
 	CreateInactivityMonitorFunc = func() InactivityMonitor
 	RequestMonitorFunc          = func(cc *Conn, req *pool.Message) (drop bool, err error)
+	CreateCodecFunc             = func() coder.Codec
+	InterceptorFunc             = func(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) (handled bool)
 )
 
 type Notifier interface {
@@ -53,6 +56,7 @@ type Conn struct {
 	peerMaxMessageSize              atomic.Uint32
 	disablePeerTCPSignalMessageCSMs bool
 	peerBlockWiseTranferEnabled     atomic.Bool
+	interceptor                     InterceptorFunc
 
 	receivedMessageReader *client.ReceivedMessageReader[*Conn]
 }
@@ -61,6 +65,8 @@ type ConnOptions struct {
 	CreateBlockWise   func(cc *Conn) *blockwise.BlockWise[*Conn]
 	InactivityMonitor InactivityMonitor
 	RequestMonitor    RequestMonitorFunc
+	Codec             coder.Codec
+	Interceptor       InterceptorFunc
 }
 
 type Option = func(opts *ConnOptions)
@@ -89,6 +95,22 @@ func WithRequestMonitor(requestMonitor RequestMonitorFunc) Option {
 	}
 }
 
+// WithCodec encodes and decodes the framed messages of the connection by the codec.
+func WithCodec(codec coder.Codec) Option {
+	return func(opts *ConnOptions) {
+		opts.Codec = codec
+	}
+}
+
+// WithInterceptor passes the received messages to the interceptor before the token and the observation handlers.
+// The messages for which it returns true are not processed further, e.g. the handshake of a protocol
+// which is layered on the connection. The signal messages of RFC 8323 are handled before the interceptor.
+func WithInterceptor(interceptor InterceptorFunc) Option {
+	return func(opts *ConnOptions) {
+		opts.Interceptor = interceptor
+	}
+}
+
 // NewConn creates connection over session and observation.
 func NewConn(
 	connection *coapNet.Conn,
@@ -119,6 +141,7 @@ func NewConnWithOpts(connection *coapNet.Conn, cfg *Config, opts ...Option) *Con
 		tokenHandlerContainer:           coapSync.NewMap[uint64, HandlerFunc](),
 		blockwiseSZX:                    cfg.BlockwiseSZX,
 		disablePeerTCPSignalMessageCSMs: cfg.DisablePeerTCPSignalMessageCSMs,
+		interceptor:                     cfgOpts.Interceptor,
 	}
 	limitParallelRequests := limitparallelrequests.New(cfg.LimitClientParallelRequests, cfg.LimitClientEndpointParallelRequests, cc.do, cc.doObserve)
 	cc.observationHandler = observation.NewHandler(&cc, cfg.Handler, limitParallelRequests.Do)
@@ -135,6 +158,9 @@ func NewConnWithOpts(connection *coapNet.Conn, cfg *Config, opts ...Option) *Con
 		cfg.ConnectionCacheSize,
 		cfg.MessagePool,
 	)
+	if cfgOpts.Codec != nil {
+		session.SetCodec(cfgOpts.Codec)
+	}
 	cc.session = session
 	if cc.processReceivedMessage == nil {
 		cc.processReceivedMessage = processReceivedMessage
@@ -344,6 +370,9 @@ func (cc *Conn) ProcessReceivedMessageWithHandler(req *pool.Message, handler Han
 }
 
 func (cc *Conn) blockwiseHandle(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) {
+	if cc.interceptor != nil && cc.interceptor(w, r) {
+		return
+	}
 	if h, ok := cc.tokenHandlerContainer.Load(r.Token().Hash()); ok {
 		h(w, r)
 		return
@@ -356,6 +385,9 @@ func (cc *Conn) handle(w *responsewriter.ResponseWriter[*Conn], r *pool.Message)
 		cc.blockWise.Handle(w, r, cc.blockwiseSZX, cc.Session().maxMessageSize, cc.blockwiseHandle)
 		return
 	}
+	if cc.interceptor != nil && cc.interceptor(w, r) {
+		return
+	}
 	if h, ok := cc.tokenHandlerContainer.LoadAndDelete(r.Token().Hash()); ok {
 		h(w, r)
 		return
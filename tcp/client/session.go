@@ -40,6 +40,9 @@ type Session struct {
 	connectionCacheSize        uint16
 	disableTCPSignalMessageCSM bool
 	closeSocket                bool
+	codec                      coder.Codec
+	// writeMutex keeps the frames in the order in which they are encoded, the codec can depend on it.
+	writeMutex sync.Mutex
 }
 
 func NewSession(
@@ -81,6 +84,7 @@ func NewSession(
 		done:                       make(chan struct{}),
 		connectionCacheSize:        connectionCacheSize,
 		messagePool:                messagePool,
+		codec:                      coder.DefaultCoder,
 	}
 	s.ctx.Store(&ctx)
 
@@ -100,6 +104,17 @@ func (s *Session) SetContextValue(key interface{}, val interface{}) {
 	s.ctx.Store(&ctx)
 }
 
+// SetCodec sets the codec which encodes and decodes the messages of the session. It must be set before the session runs,
+// the CSM which NewSession sends is encoded by the plain coder.
+func (s *Session) SetCodec(codec coder.Codec) {
+	s.codec = codec
+}
+
+// Codec returns the codec of the session.
+func (s *Session) Codec() coder.Codec {
+	return s.codec
+}
+
 // Done signalizes that connection is not more processed.
 func (s *Session) Done() <-chan struct{} {
 	return s.done
@@ -165,7 +180,7 @@ func seekBufferToNextMessage(buffer *bytes.Buffer, msgSize int) *bytes.Buffer {
 func (s *Session) processBuffer(buffer *bytes.Buffer, cc *Conn) error {
 	for buffer.Len() > 0 {
 		var header coder.MessageHeader
-		_, err := coder.DefaultCoder.DecodeHeader(buffer.Bytes(), &header)
+		_, err := s.codec.DecodeHeader(buffer.Bytes(), &header)
 		if errors.Is(err, message.ErrShortRead) {
 			return nil
 		}
@@ -176,7 +191,7 @@ func (s *Session) processBuffer(buffer *bytes.Buffer, cc *Conn) error {
 			return nil
 		}
 		req := s.messagePool.AcquireMessage(s.Context())
-		read, err := req.UnmarshalWithDecoder(coder.DefaultCoder, buffer.Bytes()[:header.MessageLength])
+		read, err := req.UnmarshalWithDecoder(s.codec, buffer.Bytes()[:header.MessageLength])
 		if err != nil {
 			s.messagePool.ReleaseMessage(req)
 			return fmt.Errorf("cannot unmarshal with header: %w", err)
@@ -200,7 +215,9 @@ func (s *Session) processBuffer(buffer *bytes.Buffer, cc *Conn) error {
 }
 
 func (s *Session) WriteMessage(req *pool.Message) error {
-	data, err := req.MarshalWithEncoder(coder.DefaultCoder)
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	data, err := req.MarshalWithEncoder(s.codec)
 	if err != nil {
 		return fmt.Errorf("cannot marshal: %w", err)
 	}
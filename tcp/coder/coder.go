@@ -10,6 +10,15 @@ import (
 
 var DefaultCoder = new(Coder)
 
+// Codec encodes and decodes the framed messages of a connection, e.g. to protect or to compress them.
+// DecodeHeader must parse the frame header of RFC 8323, so the stream can be split into messages.
+type Codec interface {
+	Size(m message.Message) (int, error)
+	Encode(m message.Message, buf []byte) (int, error)
+	Decode(data []byte, m *message.Message) (int, error)
+	DecodeHeader(data []byte, h *MessageHeader) (int, error)
+}
+
 const (
 	MessageLength13Base = 13
 	MessageLength14Base = 269
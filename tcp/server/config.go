@@ -60,6 +60,8 @@ type Config struct {
 	Handler                         HandlerFunc
 	OnNewConn                       OnNewConnFunc
 	RequestMonitor                  client.RequestMonitorFunc
+	CreateCodec                     client.CreateCodecFunc
+	Interceptor                     client.InterceptorFunc
 	ConnectionCacheSize             uint16
 	DisablePeerTCPSignalMessageCSMs bool
 	DisableTCPSignalMessageCSM      bool
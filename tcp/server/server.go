@@ -15,6 +15,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/monitor/inactivity"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/connections"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/client"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/tcp/coder"
 )
 
 // A Option sets options such as credentials, codec and keepalive parameters, etc.
@@ -199,6 +200,10 @@ func (s *Server) createConn(connection *coapNet.Conn, inactivityMonitor client.I
 			)
 		}
 	}
+	var codec coder.Codec
+	if s.cfg.CreateCodec != nil {
+		codec = s.cfg.CreateCodec()
+	}
 	cfg := client.DefaultConfig
 	cfg.Ctx = s.ctx
 	cfg.Handler = s.cfg.Handler
@@ -219,6 +224,8 @@ func (s *Server) createConn(connection *coapNet.Conn, inactivityMonitor client.I
 		client.WithBlockWise(createBlockWise),
 		client.WithInactivityMonitor(inactivityMonitor),
 		client.WithRequestMonitor(requestMonitor),
+		client.WithCodec(codec),
+		client.WithInterceptor(s.cfg.Interceptor),
 	)
 
 	return cc
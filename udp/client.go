@@ -13,6 +13,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/monitor/inactivity"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/client"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/coder"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/server"
 )
 
@@ -95,10 +96,17 @@ func Client(conn *net.UDPConn, opts ...Option) *client.Conn {
 		cfg.MTU,
 		cfg.CloseSocket,
 	)
+	codec := coder.Codec(coder.DefaultCoder)
+	if cfg.CreateCodec != nil {
+		codec = cfg.CreateCodec()
+		session.SetCodec(codec)
+	}
 	cc := client.NewConnWithOpts(session, &cfg,
 		client.WithBlockWise(createBlockWise),
 		client.WithInactivityMonitor(monitor),
 		client.WithRequestMonitor(cfg.RequestMonitor),
+		client.WithCodec(codec),
+		client.WithInterceptor(cfg.Interceptor),
 	)
 	cfg.PeriodicRunner(func(now time.Time) bool {
 		cc.CheckExpirations(now)
@@ -56,4 +56,8 @@ type Config struct {
 	TransmissionMaxRetransmit      uint32
 	CloseSocket                    bool
 	MTU                            uint16
+	// CreateCodec creates the codec of the datagrams of the connection, the plain CoAP coder is used when it is nil.
+	CreateCodec CreateCodecFunc
+	// Interceptor handles the special messages before the token and the observation handlers.
+	Interceptor InterceptorFunc
 }
@@ -40,6 +40,8 @@ type (
 	GetMIDFunc                  = func() int32
 	CreateInactivityMonitorFunc = func() InactivityMonitor
 	RequestMonitorFunc          = func(cc *Conn, req *pool.Message) (drop bool, err error)
+	CreateCodecFunc             = func() coder.Codec
+	InterceptorFunc             = func(w *responsewriter.ResponseWriter[*Conn], r *pool.Message) (handled bool)
 )
 
 type InactivityMonitor interface {
@@ -137,6 +139,8 @@ type Conn struct {
 	*client.Client[*Conn]
 	inactivityMonitor InactivityMonitor
 	requestMonitor    RequestMonitorFunc
+	codec             coder.Codec
+	interceptor       InterceptorFunc
 
 	blockWise          *blockwise.BlockWise[*Conn]
 	observationHandler *observation.Handler[*Conn]
@@ -192,6 +196,8 @@ type ConnOptions struct {
 	createBlockWise   func(cc *Conn) *blockwise.BlockWise[*Conn]
 	inactivityMonitor InactivityMonitor
 	requestMonitor    RequestMonitorFunc
+	codec             coder.Codec
+	interceptor       InterceptorFunc
 }
 
 type Option = func(opts *ConnOptions)
@@ -220,6 +226,22 @@ func WithRequestMonitor(requestMonitor RequestMonitorFunc) Option {
 	}
 }
 
+// WithCodec decodes the datagrams of the connection by the codec. The session must encode by the same codec.
+func WithCodec(codec coder.Codec) Option {
+	return func(opts *ConnOptions) {
+		opts.codec = codec
+	}
+}
+
+// WithInterceptor passes the received messages to the interceptor before the token and the observation handlers.
+// The messages for which it returns true are not processed further, e.g. the handshake of a protocol
+// which is layered on the connection.
+func WithInterceptor(interceptor InterceptorFunc) Option {
+	return func(opts *ConnOptions) {
+		opts.interceptor = interceptor
+	}
+}
+
 func NewConnWithOpts(session Session, cfg *Config, opts ...Option) *Conn {
 	if cfg.Errors == nil {
 		cfg.Errors = func(error) {
@@ -248,6 +270,9 @@ func NewConnWithOpts(session Session, cfg *Config, opts ...Option) *Conn {
 	for _, o := range opts {
 		o(&cfgOpts)
 	}
+	if cfgOpts.codec == nil {
+		cfgOpts.codec = coder.DefaultCoder
+	}
 	cc := Conn{
 		session: session,
 		transmission: &Transmission{
@@ -265,6 +290,8 @@ func NewConnWithOpts(session Session, cfg *Config, opts ...Option) *Conn {
 		responseMsgCache:          cache.NewCache[string, []byte](),
 		inactivityMonitor:         cfgOpts.inactivityMonitor,
 		requestMonitor:            cfgOpts.requestMonitor,
+		codec:                     cfgOpts.codec,
+		interceptor:               cfgOpts.interceptor,
 		messagePool:               cfg.MessagePool,
 		numOutstandingInteraction: semaphore.NewWeighted(math.MaxInt64),
 	}
@@ -298,6 +325,12 @@ func (cc *Conn) ProcessReceivedMessage(req *pool.Message) {
 	cc.processReceivedMessage(req, cc, cc.handleReq)
 }
 
+// Codec returns the codec which decodes the datagrams of the connection, so that an interceptor can install
+// the keys of a protocol which is layered on the connection. The session encodes by the same codec.
+func (cc *Conn) Codec() coder.Codec {
+	return cc.codec
+}
+
 func (cc *Conn) Session() Session {
 	return cc.session
 }
@@ -589,6 +622,9 @@ func (cc *Conn) handle(w *responsewriter.ResponseWriter[*Conn], m *pool.Message)
 	}
 	if cc.blockWise != nil {
 		cc.blockWise.Handle(w, m, cc.blockwiseSZX, cc.session.MaxMessageSize(), func(rw *responsewriter.ResponseWriter[*Conn], rm *pool.Message) {
+			if cc.interceptor != nil && cc.interceptor(rw, rm) {
+				return
+			}
 			if h, ok := cc.tokenHandlerContainer.LoadAndDelete(rm.Token().Hash()); ok {
 				h(rw, rm)
 				return
@@ -597,6 +633,9 @@ func (cc *Conn) handle(w *responsewriter.ResponseWriter[*Conn], m *pool.Message)
 		})
 		return
 	}
+	if cc.interceptor != nil && cc.interceptor(w, m) {
+		return
+	}
 	if h, ok := cc.tokenHandlerContainer.LoadAndDelete(m.Token().Hash()); ok {
 		h(w, m)
 		return
@@ -840,7 +879,7 @@ func (cc *Conn) Process(cm *coapNet.ControlMessage, datagram []byte) error {
 		return fmt.Errorf("max message size(%v) was exceeded %v", cc.session.MaxMessageSize(), len(datagram))
 	}
 	req := cc.AcquireMessage(cc.Context())
-	_, err := req.UnmarshalWithDecoder(coder.DefaultCoder, datagram)
+	_, err := req.UnmarshalWithDecoder(cc.codec, datagram)
 	if err != nil {
 		cc.ReleaseMessage(req)
 		return err
@@ -21,6 +21,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/options/config"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/runner/periodic"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/client"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/coder"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -670,6 +671,244 @@ func TestConnPing(t *testing.T) {
 	require.NoError(t, err)
 }
 
+// xorCodec obfuscates the datagrams of the plain CoAP coder.
+type xorCodec struct {
+	key byte
+}
+
+func (c xorCodec) Size(m message.Message) (int, error) {
+	return coder.DefaultCoder.Size(m)
+}
+
+func (c xorCodec) Encode(m message.Message, buf []byte) (int, error) {
+	n, err := coder.DefaultCoder.Encode(m, buf)
+	for i := 0; i < n; i++ {
+		buf[i] ^= c.key
+	}
+	return n, err
+}
+
+func (c xorCodec) Decode(data []byte, m *message.Message) (int, error) {
+	plain := make([]byte, len(data))
+	for i := range data {
+		plain[i] = data[i] ^ c.key
+	}
+	return coder.DefaultCoder.Decode(plain, m)
+}
+
+func TestConnCodecAndInterceptor(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	createCodec := func() coder.Codec {
+		return xorCodec{key: 0x5a}
+	}
+	m := mux.NewRouter()
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	s := NewServer(options.WithMux(m), options.WithCodec(createCodec),
+		options.WithInterceptor(func(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message) bool {
+			if path, errP := r.Path(); errP != nil || path != "/intercepted" {
+				return false
+			}
+			errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("intercepted")))
+			require.NoError(t, errS)
+			return true
+		}))
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		assert.NoError(t, errS)
+	}()
+
+	dial := func(opts ...Option) *client.Conn {
+		cc, errD := Dial(l.LocalAddr().String(), opts...)
+		require.NoError(t, errD)
+		t.Cleanup(func() {
+			errC := cc.Close()
+			require.NoError(t, errC)
+			<-cc.Done()
+		})
+		return cc
+	}
+	get := func(cc *client.Conn, path string, timeout time.Duration) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		resp, errG := cc.Get(ctx, path)
+		if errG != nil {
+			return "", errG
+		}
+		body, errR := resp.ReadBody()
+		require.NoError(t, errR)
+		return string(body), nil
+	}
+
+	cc := dial(options.WithCodec(createCodec))
+	body, err := get(cc, "/a", Timeout)
+	require.NoError(t, err)
+	require.Equal(t, "a", body)
+	body, err = get(cc, "/intercepted", Timeout)
+	require.NoError(t, err)
+	require.Equal(t, "intercepted", body)
+
+	// the plain client cannot talk to the server
+	_, err = get(dial(), "/a", time.Millisecond*500)
+	require.Error(t, err)
+}
+
+// keyedCodec prefixes the datagrams by 0 in plaintext and by 1 when they are obfuscated by the key. The pending
+// key is set by the handshake and it becomes the key when the peer sends the first datagram by it.
+type keyedCodec struct {
+	mutex   sync.Mutex
+	key     byte
+	pending byte
+}
+
+func (c *keyedCodec) SetKey(key byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.key = key
+}
+
+func (c *keyedCodec) SetPendingKey(key byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pending = key
+}
+
+func (c *keyedCodec) Size(m message.Message) (int, error) {
+	n, err := coder.DefaultCoder.Size(m)
+	return n + 1, err
+}
+
+func (c *keyedCodec) Encode(m message.Message, buf []byte) (int, error) {
+	if len(buf) == 0 {
+		n, err := c.Size(m)
+		if err != nil {
+			return -1, err
+		}
+		return n, message.ErrTooSmall
+	}
+	c.mutex.Lock()
+	key := c.key
+	c.mutex.Unlock()
+	n, err := coder.DefaultCoder.Encode(m, buf[1:])
+	if err != nil {
+		return n + 1, err
+	}
+	buf[0] = 0
+	if key != 0 {
+		buf[0] = 1
+		for i := 1; i <= n; i++ {
+			buf[i] ^= key
+		}
+	}
+	return n + 1, nil
+}
+
+func (c *keyedCodec) Decode(data []byte, m *message.Message) (int, error) {
+	if len(data) == 0 {
+		return -1, message.ErrShortRead
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if data[0] == 0 {
+		if c.key != 0 {
+			return -1, errors.New("plaintext after the handshake")
+		}
+		n, err := coder.DefaultCoder.Decode(data[1:], m)
+		return n + 1, err
+	}
+	if c.key == 0 {
+		if c.pending == 0 {
+			return -1, errors.New("no key")
+		}
+		c.key, c.pending = c.pending, 0
+	}
+	plain := make([]byte, len(data)-1)
+	for i := range plain {
+		plain[i] = data[i+1] ^ c.key
+	}
+	n, err := coder.DefaultCoder.Decode(plain, m)
+	return n + 1, err
+}
+
+func TestConnInterceptorHandshake(t *testing.T) {
+	l, err := coapNet.NewListenUDP("udp", "")
+	require.NoError(t, err)
+	defer func() {
+		errC := l.Close()
+		require.NoError(t, errC)
+	}()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	createCodec := func() coder.Codec {
+		return &keyedCodec{}
+	}
+	m := mux.NewRouter()
+	err = m.Handle("/a", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		errS := w.SetResponse(codes.Content, message.TextPlain, bytes.NewReader([]byte("a")))
+		require.NoError(t, errS)
+	}))
+	require.NoError(t, err)
+	s := NewServer(options.WithMux(m), options.WithCodec(createCodec),
+		options.WithInterceptor(func(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message) bool {
+			if path, errP := r.Path(); errP != nil || path != "/handshake" {
+				return false
+			}
+			body, errR := r.ReadBody()
+			if errR != nil || len(body) != 1 {
+				return false
+			}
+			// the response of the handshake is sent in plaintext, the key is used when the client proves it
+			w.Conn().Codec().(*keyedCodec).SetPendingKey(body[0])
+			errS := w.SetResponse(codes.Created, message.TextPlain, nil)
+			require.NoError(t, errS)
+			return true
+		}))
+	defer s.Stop()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errS := s.Serve(l)
+		assert.NoError(t, errS)
+	}()
+
+	cc, err := Dial(l.LocalAddr().String(), options.WithCodec(createCodec))
+	require.NoError(t, err)
+	defer func() {
+		errC := cc.Close()
+		require.NoError(t, errC)
+		<-cc.Done()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	resp, err := cc.Post(ctx, "/handshake", message.AppOctets, bytes.NewReader([]byte{0x5a}))
+	require.NoError(t, err)
+	require.Equal(t, codes.Created, resp.Code())
+	cc.Codec().(*keyedCodec).SetKey(0x5a)
+
+	resp, err = cc.Get(ctx, "/a")
+	require.NoError(t, err)
+	body, err := resp.ReadBody()
+	require.NoError(t, err)
+	require.Equal(t, "a", string(body))
+}
+
 func TestClientInactiveMonitor(t *testing.T) {
 	var inactivityDetected atomic.Bool
 
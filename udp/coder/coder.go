@@ -11,6 +11,13 @@ import (
 
 var DefaultCoder = new(Coder)
 
+// Codec encodes and decodes the messages of a connection, e.g. to protect or to compress the datagrams.
+type Codec interface {
+	Size(m message.Message) (int, error)
+	Encode(m message.Message, buf []byte) (int, error)
+	Decode(data []byte, m *message.Message) (int, error)
+}
+
 type Coder struct{}
 
 func (c *Coder) Size(m message.Message) (int, error) {
@@ -65,4 +65,9 @@ type Config struct {
 	TransmissionAcknowledgeTimeout time.Duration
 	TransmissionMaxRetransmit      uint32
 	MTU                            uint16
+	// CreateCodec creates the codec of the datagrams of every connection, the plain CoAP coder is used when it is nil.
+	// The multicast requests of Discover are encoded by the plain CoAP coder.
+	CreateCodec udpClient.CreateCodecFunc
+	// Interceptor handles the special messages before the token and the observation handlers.
+	Interceptor udpClient.InterceptorFunc
 }
@@ -4,13 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net"
 
-	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/message/pool"
 	coapNet "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/discovery"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/net/responsewriter"
-	pkgErrors "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/errors"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/client"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/coder"
 )
@@ -21,19 +19,9 @@ import (
 // By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
 // Via opts you can specify the network interface, source IP address, and hop limit.
 func (s *Server) Discover(ctx context.Context, address, path string, receiverFunc func(cc *client.Conn, resp *pool.Message), opts ...coapNet.MulticastOption) error {
-	token, err := s.cfg.GetToken()
-	if err != nil {
-		return fmt.Errorf("cannot get token: %w", err)
-	}
-	req := s.cfg.MessagePool.AcquireMessage(ctx)
-	defer s.cfg.MessagePool.ReleaseMessage(req)
-	err = req.SetupGet(path, token)
-	if err != nil {
-		return fmt.Errorf("cannot create discover request: %w", err)
-	}
-	req.SetMessageID(s.cfg.GetMID())
-	req.SetType(message.NonConfirmable)
-	return s.DiscoveryRequest(req, address, receiverFunc, opts...)
+	return discovery.Discover(ctx, s.cfg.MessagePool, s.cfg.GetToken, s.cfg.GetMID, path, func(req *pool.Message) error {
+		return s.DiscoveryRequest(req, address, receiverFunc, opts...)
+	})
 }
 
 // DiscoveryRequest sends request to multicast/unicast address and wait for responses until request timeouts or server shutdown.
@@ -42,33 +30,26 @@ func (s *Server) Discover(ctx context.Context, address, path string, receiverFun
 // By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
 // Via opts you can specify the network interface, source IP address, and hop limit.
 func (s *Server) DiscoveryRequest(req *pool.Message, address string, receiverFunc func(cc *client.Conn, resp *pool.Message), opts ...coapNet.MulticastOption) error {
-	token := req.Token()
-	if len(token) == 0 {
-		return errors.New("invalid token")
-	}
 	c := s.conn()
 	if c == nil {
 		return errors.New("server doesn't serve connection")
 	}
-	addr, err := net.ResolveUDPAddr(c.Network(), address)
+	addr, err := discovery.Resolve(req, c.Network(), address)
 	if err != nil {
-		return fmt.Errorf("cannot resolve address: %w", err)
+		return err
 	}
 
 	data, err := req.MarshalWithEncoder(coder.DefaultCoder)
 	if err != nil {
 		return fmt.Errorf("cannot marshal req: %w", err)
 	}
-	s.multicastRequests.Store(token.Hash(), req)
-	defer s.multicastRequests.Delete(token.Hash())
-	if _, loaded := s.multicastHandler.LoadOrStore(token.Hash(), func(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message) {
+	unregister, err := discovery.Register(s.multicastRequests, s.multicastHandler, req, func(w *responsewriter.ResponseWriter[*client.Conn], r *pool.Message) {
 		receiverFunc(w.Conn(), r)
-	}); loaded {
-		return pkgErrors.ErrKeyAlreadyExists
+	})
+	if err != nil {
+		return err
 	}
-	defer func() {
-		_, _ = s.multicastHandler.LoadAndDelete(token.Hash())
-	}()
+	defer unregister()
 
 	if addr.IP.IsMulticast() {
 		err = c.WriteMulticast(req.Context(), addr, data, opts...)
@@ -81,11 +62,5 @@ func (s *Server) DiscoveryRequest(req *pool.Message, address string, receiverFun
 			return err
 		}
 	}
-
-	select {
-	case <-req.Context().Done():
-		return nil
-	case <-s.ctx.Done():
-		return fmt.Errorf("server was closed: %w", s.ctx.Err())
-	}
+	return discovery.Wait(s.ctx, req)
 }
@@ -17,6 +17,7 @@ import (
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/cache"
 	coapSync "github.com/daniellgelencser/go-attested-coap-over-ascon/v3/pkg/sync"
 	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/client"
+	"github.com/daniellgelencser/go-attested-coap-over-ascon/v3/udp/coder"
 )
 
 type Server struct {
@@ -303,6 +304,11 @@ func (s *Server) getOrCreateConn(udpConn *coapNet.UDPConn, raddr *net.UDPAddr) (
 		s.cfg.MTU,
 		false,
 	)
+	codec := coder.Codec(coder.DefaultCoder)
+	if s.cfg.CreateCodec != nil {
+		codec = s.cfg.CreateCodec()
+		session.SetCodec(codec)
+	}
 	monitor := s.cfg.CreateInactivityMonitor()
 	cfg := client.DefaultConfig
 	cfg.TransmissionNStart = s.cfg.TransmissionNStart
@@ -331,6 +337,8 @@ func (s *Server) getOrCreateConn(udpConn *coapNet.UDPConn, raddr *net.UDPAddr) (
 		client.WithInactivityMonitor(monitor),
 		client.WithRequestMonitor(requestMonitor),
 		client.WithBlockWise(createBlockWise),
+		client.WithCodec(codec),
+		client.WithInterceptor(s.cfg.Interceptor),
 	)
 	cc.SetContextValue(closeKey, func() {
 		if err := session.Close(); err != nil {
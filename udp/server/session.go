@@ -32,6 +32,7 @@ type Session struct {
 	mtu            uint16
 
 	closeSocket bool
+	codec       coder.Codec
 }
 
 func NewSession(
@@ -55,6 +56,7 @@ func NewSession(
 		closeSocket:    closeSocket,
 		doneCtx:        doneCtx,
 		doneCancel:     doneCancel,
+		codec:          coder.DefaultCoder,
 	}
 	s.ctx.Store(&ctx)
 	return s
@@ -66,6 +68,11 @@ func (s *Session) SetContextValue(key interface{}, val interface{}) {
 	s.ctx.Store(&ctx)
 }
 
+// SetCodec sets the codec which encodes the messages of the session. It must be set before the session is used.
+func (s *Session) SetCodec(codec coder.Codec) {
+	s.codec = codec
+}
+
 // Done signalizes that connection is not more processed.
 func (s *Session) Done() <-chan struct{} {
 	return s.doneCtx.Done()
@@ -105,7 +112,7 @@ func (s *Session) Context() context.Context {
 }
 
 func (s *Session) WriteMessage(req *pool.Message) error {
-	data, err := req.MarshalWithEncoder(coder.DefaultCoder)
+	data, err := req.MarshalWithEncoder(s.codec)
 	if err != nil {
 		return fmt.Errorf("cannot marshal: %w", err)
 	}
@@ -116,7 +123,7 @@ func (s *Session) WriteMessage(req *pool.Message) error {
 // By default it is sent over all network interfaces and all compatible source IP addresses with hop limit 1.
 // Via opts you can specify the network interface, source IP address, and hop limit.
 func (s *Session) WriteMulticastMessage(req *pool.Message, address *net.UDPAddr, opts ...coapNet.MulticastOption) error {
-	data, err := req.MarshalWithEncoder(coder.DefaultCoder)
+	data, err := req.MarshalWithEncoder(s.codec)
 	if err != nil {
 		return fmt.Errorf("cannot marshal: %w", err)
 	}